@@ -0,0 +1,652 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/shaheerkj/snippetbox/internal/models"
+	"github.com/shaheerkj/snippetbox/internal/validator"
+)
+
+// apiPrefix is where version 1 of the JSON API is served
+const apiPrefix = "/api/v1"
+
+// maxAPIBodyBytes is the largest request body the JSON API accepts
+const maxAPIBodyBytes = 1 << 20
+
+// isAPIRequest reports whether a request is for the JSON API, so errors
+// should be sent as JSON rather than as pages or plain text
+func isAPIRequest(r *http.Request) bool {
+	return r.URL.Path == "/api" || strings.HasPrefix(r.URL.Path, "/api/")
+}
+
+// apiError is the body of every error response from the JSON API
+type apiError struct {
+	Error apiErrorDetail `json:"error"`
+}
+
+type apiErrorDetail struct {
+	Status         int               `json:"status" doc:"HTTP status code"`
+	Message        string            `json:"message"`
+	FieldErrors    map[string]string `json:"field_errors,omitempty" doc:"Problems with particular fields, keyed by field name, e.g. files[0].name"`
+	NonFieldErrors []string          `json:"non_field_errors,omitempty" doc:"Problems with the request as a whole"`
+}
+
+// writeJSON sends data as a JSON response with the given status
+func writeJSON(w http.ResponseWriter, status int, data any) error {
+	js, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(js, '\n'))
+	return nil
+}
+
+// writeAPIError sends an error response in the JSON API's format
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, apiError{Error: apiErrorDetail{Status: status, Message: message}})
+}
+
+// statusError sends a client error status with its standard text. API
+// requests get it in the JSON API's error format. It's for middleware that
+// serves both pages and the API; handlers know which they are.
+func statusError(w http.ResponseWriter, r *http.Request, status int) {
+	if isAPIRequest(r) {
+		writeAPIError(w, status, http.StatusText(status))
+		return
+	}
+	http.Error(w, http.StatusText(status), status)
+}
+
+// apiValidationError sends the problems a validator found with a request
+func apiValidationError(w http.ResponseWriter, v validator.Validator) {
+	writeJSON(w, http.StatusUnprocessableEntity, apiError{Error: apiErrorDetail{
+		Status:         http.StatusUnprocessableEntity,
+		Message:        "The request has problems; see field_errors and non_field_errors",
+		FieldErrors:    v.FieldErrors,
+		NonFieldErrors: v.NonFieldErrors,
+	}})
+}
+
+// readJSON decodes a JSON request body into dst. Bodies must be a single
+// JSON value of at most maxAPIBodyBytes without unknown fields. If it
+// returns false an error response has already been sent.
+func readJSON(w http.ResponseWriter, r *http.Request, dst any) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		writeAPIError(w, http.StatusUnsupportedMediaType, "The request body must be JSON, sent with Content-Type: application/json")
+		return false
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxAPIBodyBytes)
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	err = dec.Decode(dst)
+	if err == nil {
+		// Anything after the value means the body wasn't a single value
+		err = dec.Decode(&json.RawMessage{})
+		if err == io.EOF {
+			return true
+		}
+		if err == nil || !errors.As(err, new(*http.MaxBytesError)) {
+			err = errors.New("must only contain a single JSON value")
+		}
+	}
+
+	var (
+		syntaxError    *json.SyntaxError
+		typeError      *json.UnmarshalTypeError
+		maxBytesError  *http.MaxBytesError
+		message        string
+		status         = http.StatusBadRequest
+		unknownFieldPf = "json: unknown field "
+	)
+	switch {
+	case errors.As(err, &maxBytesError):
+		status = http.StatusRequestEntityTooLarge
+		message = fmt.Sprintf("The request body can't be larger than %d bytes", maxBytesError.Limit)
+	case errors.As(err, &syntaxError):
+		message = fmt.Sprintf("The request body has badly-formed JSON at character %d", syntaxError.Offset)
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		message = "The request body has badly-formed JSON"
+	case errors.As(err, &typeError) && typeError.Field != "":
+		message = fmt.Sprintf("The request body has the wrong type of value for %q", typeError.Field)
+	case errors.As(err, &typeError):
+		message = "The request body has the wrong type of value"
+	case errors.Is(err, io.EOF):
+		message = "The request body must not be empty"
+	case strings.HasPrefix(err.Error(), unknownFieldPf):
+		message = "The request body has an unknown field " + strings.TrimPrefix(err.Error(), unknownFieldPf)
+	default:
+		message = "The request body " + strings.TrimPrefix(err.Error(), "json: ")
+	}
+
+	writeAPIError(w, status, message)
+	return false
+}
+
+// apiSnippetSummary is a snippet as it's listed by the JSON API
+type apiSnippetSummary struct {
+	Slug       string     `json:"slug"`
+	URL        string     `json:"url" doc:"Page showing the snippet"`
+	Title      string     `json:"title"`
+	Tags       []string   `json:"tags"`
+	Visibility string     `json:"visibility" enum:"public,unlisted,private"`
+	Created    time.Time  `json:"created"`
+	Expires    *time.Time `json:"expires" doc:"Null if the snippet never expires"`
+}
+
+// apiSnippet is a snippet with its files, as the JSON API returns one
+type apiSnippet struct {
+	apiSnippetSummary
+	Author           *apiAuthor `json:"author" doc:"Null if the author deleted their account"`
+	BurnAfterReading bool       `json:"burn_after_reading" doc:"Only the author can get a burn after reading snippet, files and all, without destroying it; anyone else must reveal it in the browser"`
+	Protected        bool       `json:"protected" doc:"Whether a passphrase is needed to read the snippet"`
+	Revision         int        `json:"revision"`
+	Updated          time.Time  `json:"updated" doc:"When the current revision was written"`
+	Stars            int        `json:"stars"`
+	Files            []apiFile  `json:"files"`
+}
+
+type apiAuthor struct {
+	Handle string `json:"handle"`
+	Name   string `json:"name"`
+}
+
+// apiFile is one file of a snippet, in responses and requests
+type apiFile struct {
+	Name     string `json:"name"`
+	Language string `json:"language" doc:"Highlighting language; detected from the name and content if left empty"`
+	Content  string `json:"content" doc:"At most 100 KB"`
+}
+
+// apiSnippetList is a page of snippets. Pass newer as before, or older as
+// after, to get the pages either side.
+type apiSnippetList struct {
+	Snippets []apiSnippetSummary `json:"snippets"`
+	Newer    string              `json:"newer,omitempty" doc:"Cursor of the newer page, if there is one"`
+	Older    string              `json:"older,omitempty" doc:"Cursor of the older page, if there is one"`
+}
+
+// apiSnippetCreate is the body of a request to create a snippet
+type apiSnippetCreate struct {
+	Title            string     `json:"title"`
+	Files            []apiFile  `json:"files" doc:"Between 1 and 20 files, holding at most 500 KB between them"`
+	Tags             []string   `json:"tags,omitempty"`
+	Expires          string     `json:"expires,omitempty" enum:"10m,1h,1d,1w,1mo,1y,never,custom" doc:"How long until the snippet expires; defaults to 1y. Use custom with expires_at."`
+	ExpiresAt        *time.Time `json:"expires_at,omitempty" doc:"When the snippet expires, with expires set to custom"`
+	Visibility       string     `json:"visibility,omitempty" enum:"public,unlisted,private" doc:"Defaults to public"`
+	BurnAfterReading bool       `json:"burn_after_reading,omitempty"`
+	Passphrase       string     `json:"passphrase,omitempty" doc:"Needed to read the snippet, except by its author"`
+}
+
+// apiSnippetUpdate is the body of a request to save a new revision of a
+// snippet
+type apiSnippetUpdate struct {
+	Title string    `json:"title"`
+	Files []apiFile `json:"files" doc:"Between 1 and 20 files, holding at most 500 KB between them, replacing the current ones"`
+	Tags  []string  `json:"tags,omitempty"`
+}
+
+// apiProfile is a user's public profile
+type apiProfile struct {
+	Handle    string    `json:"handle"`
+	Name      string    `json:"name"`
+	Bio       string    `json:"bio"`
+	AvatarURL string    `json:"avatar_url,omitempty"`
+	URL       string    `json:"url" doc:"Profile page"`
+	Snippets  int       `json:"snippets" doc:"Number of public snippets"`
+	Stars     int       `json:"stars" doc:"Stars given to the user's public snippets"`
+	Joined    time.Time `json:"joined"`
+}
+
+// apiAccount is the authenticated user's own account
+type apiAccount struct {
+	Handle        string    `json:"handle"`
+	Name          string    `json:"name"`
+	Email         string    `json:"email"`
+	EmailVerified bool      `json:"email_verified" doc:"Snippets can only be created once the email is verified"`
+	TwoFactor     bool      `json:"two_factor"`
+	Bio           string    `json:"bio"`
+	Joined        time.Time `json:"joined"`
+}
+
+// apiSummary converts a snippet to its form in listings
+func (app *application) apiSummary(s models.Snippet) apiSnippetSummary {
+	summary := apiSnippetSummary{
+		Slug:       s.Slug,
+		URL:        app.baseURL + "/snippet/view/" + s.Slug,
+		Title:      s.Title,
+		Tags:       s.Tags,
+		Visibility: s.Visibility,
+		Created:    s.Created,
+	}
+	if summary.Tags == nil {
+		summary.Tags = []string{}
+	}
+	if !s.Expires.IsZero() {
+		summary.Expires = &s.Expires
+	}
+	return summary
+}
+
+// apiSnippetList converts a page of snippets to the JSON API's form
+func (app *application) apiSnippetList(snippets []models.Snippet, links models.PageLinks) apiSnippetList {
+	list := apiSnippetList{Snippets: []apiSnippetSummary{}}
+	for _, s := range snippets {
+		list.Snippets = append(list.Snippets, app.apiSummary(s))
+	}
+	if !links.Newer.IsZero() {
+		list.Newer = links.Newer.String()
+	}
+	if !links.Older.IsZero() {
+		list.Older = links.Older.String()
+	}
+	return list
+}
+
+// apiSnippet converts a snippet fetched with Get to the JSON API's form
+func (app *application) apiSnippet(s models.Snippet) apiSnippet {
+	snippet := apiSnippet{
+		apiSnippetSummary: app.apiSummary(s),
+		BurnAfterReading:  s.BurnAfterReading,
+		Protected:         s.Protected,
+		Revision:          s.Revision,
+		Updated:           s.Updated,
+		Stars:             s.Stars,
+		Files:             []apiFile{},
+	}
+	if s.UserID != 0 {
+		snippet.Author = &apiAuthor{Handle: s.AuthorHandle, Name: s.AuthorName}
+	}
+	for _, f := range s.Files {
+		snippet.Files = append(snippet.Files, apiFile{Name: f.Name, Language: f.Language, Content: f.Content})
+	}
+	return snippet
+}
+
+// apiFileForms converts files in a request to the form handlers' type, so
+// they're checked the same way
+func apiFileForms(files []apiFile) []snippetFileForm {
+	forms := make([]snippetFileForm, len(files))
+	for i, f := range files {
+		forms[i] = snippetFileForm{Name: f.Name, Language: f.Language, Content: f.Content}
+	}
+	return forms
+}
+
+// apiNotFound answers requests for anything the JSON API doesn't have
+func (app *application) apiNotFound(w http.ResponseWriter, r *http.Request) {
+	writeAPIError(w, http.StatusNotFound, "Not found")
+}
+
+// apiMethodNotAllowed answers requests for a path of the JSON API with a
+// method it doesn't support, listing the methods it does in Allow
+func apiMethodNotAllowed(methods []string) http.HandlerFunc {
+	allow := strings.Join(methods, ", ")
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Allow", allow)
+		writeAPIError(w, http.StatusMethodNotAllowed, "This path doesn't support the "+r.Method+" method; it supports "+allow)
+	}
+}
+
+// apiSnippetSlug reads the {slug} path parameter, sending a 404 if it can't
+// be a slug
+func apiSnippetSlug(w http.ResponseWriter, r *http.Request) (string, bool) {
+	slug, ok := snippetSlug(r)
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, "No snippet has this slug")
+	}
+	return slug, ok
+}
+
+// apiSnippetsLatest lists the latest public snippets
+func (app *application) apiSnippetsLatest(w http.ResponseWriter, r *http.Request) {
+	pr, err := pageRequest(r, 20)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "The before or after cursor is malformed")
+		return
+	}
+
+	snippets, links, err := app.snippets.Latest(pr)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, app.apiSnippetList(snippets, links))
+}
+
+// apiSnippetView returns a snippet with its files. Burn after reading
+// snippets can only be read in the browser, and protected ones only by
+// their author.
+func (app *application) apiSnippetView(w http.ResponseWriter, r *http.Request) {
+	slug, ok := apiSnippetSlug(w, r)
+	if !ok {
+		return
+	}
+
+	snippet, err := app.snippets.Get(slug, app.authenticatedUserID(r))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			writeAPIError(w, http.StatusNotFound, "No snippet has this slug")
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	isAuthor := snippet.UserID != 0 && snippet.UserID == app.authenticatedUserID(r)
+	if snippet.BurnAfterReading && !isAuthor {
+		writeAPIError(w, http.StatusNotFound, "No snippet has this slug")
+		return
+	}
+	if snippet.Protected && !isAuthor {
+		writeAPIError(w, http.StatusForbidden, "This snippet is protected by a passphrase; unlock it in the browser")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, app.apiSnippet(snippet))
+}
+
+// apiSnippetCreate creates a snippet, validated the same way as the create
+// form
+func (app *application) apiSnippetCreate(w http.ResponseWriter, r *http.Request) {
+	var input apiSnippetCreate
+	if !readJSON(w, r, &input) {
+		return
+	}
+
+	form := snippetCreateForm{
+		Title:            input.Title,
+		Files:            apiFileForms(input.Files),
+		Tags:             strings.Join(input.Tags, ","),
+		Expires:          input.Expires,
+		Visibility:       input.Visibility,
+		BurnAfterReading: input.BurnAfterReading,
+		Passphrase:       input.Passphrase,
+	}
+	if form.Expires == "" {
+		form.Expires = "1y"
+	}
+	if input.ExpiresAt != nil {
+		form.ExpiresAt = input.ExpiresAt.UTC().Format(datetimeLocalLayout)
+	}
+	if form.Visibility == "" {
+		form.Visibility = models.VisibilityPublic
+	}
+
+	tags, expires := checkSnippetCreateForm(&form)
+	if !form.Valid() {
+		apiValidationError(w, form.Validator)
+		return
+	}
+
+	userID := app.authenticatedUserID(r)
+	slug, err := app.snippets.Insert(userID, form.Title, snippetFiles(form.Files), tags, expires, form.Visibility, form.BurnAfterReading, form.Passphrase)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	snippet, err := app.snippets.Get(slug, userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Location", apiPrefix+"/snippets/"+slug)
+	writeJSON(w, http.StatusCreated, app.apiSnippet(snippet))
+}
+
+// apiSnippetUpdate saves a new revision of one of the user's snippets
+func (app *application) apiSnippetUpdate(w http.ResponseWriter, r *http.Request) {
+	slug, ok := apiSnippetSlug(w, r)
+	if !ok {
+		return
+	}
+
+	var input apiSnippetUpdate
+	if !readJSON(w, r, &input) {
+		return
+	}
+
+	form := snippetEditForm{
+		Title: input.Title,
+		Files: apiFileForms(input.Files),
+		Tags:  strings.Join(input.Tags, ","),
+	}
+	tags := checkSnippetEditForm(&form)
+	if !form.Valid() {
+		apiValidationError(w, form.Validator)
+		return
+	}
+
+	userID := app.authenticatedUserID(r)
+	err := app.snippets.Update(slug, userID, form.Title, snippetFiles(form.Files), tags)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			writeAPIError(w, http.StatusNotFound, "You don't have a snippet with this slug")
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	snippet, err := app.snippets.Get(slug, userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, app.apiSnippet(snippet))
+}
+
+// apiSnippetDelete moves one of the user's snippets to the trash, where it
+// can be restored in the browser for 30 days
+func (app *application) apiSnippetDelete(w http.ResponseWriter, r *http.Request) {
+	slug, ok := apiSnippetSlug(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.snippets.Delete(slug, app.authenticatedUserID(r))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			writeAPIError(w, http.StatusNotFound, "You don't have a snippet with this slug")
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiAccount returns the authenticated user's account
+func (app *application) apiAccount(w http.ResponseWriter, r *http.Request) {
+	user, err := app.users.Get(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, apiAccount{
+		Handle:        user.Handle,
+		Name:          user.Name,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+		TwoFactor:     user.TwoFactor,
+		Bio:           user.Bio,
+		Joined:        user.Created,
+	})
+}
+
+// apiAccountSnippets lists the authenticated user's snippets, including
+// unlisted and private ones
+func (app *application) apiAccountSnippets(w http.ResponseWriter, r *http.Request) {
+	pr, err := pageRequest(r, 20)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "The before or after cursor is malformed")
+		return
+	}
+
+	snippets, links, err := app.snippets.ByUser(app.authenticatedUserID(r), pr)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, app.apiSnippetList(snippets, links))
+}
+
+// apiUserProfile fetches the profile of the user named by the {handle} path
+// parameter. If it returns false an error response has already been sent.
+func (app *application) apiUserProfile(w http.ResponseWriter, r *http.Request) (models.Profile, bool) {
+	handle := r.PathValue("handle")
+	if !validator.Matches(handle, validator.HandleRX) {
+		writeAPIError(w, http.StatusNotFound, "No user has this handle")
+		return models.Profile{}, false
+	}
+
+	profile, err := app.users.Profile(handle)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			writeAPIError(w, http.StatusNotFound, "No user has this handle")
+		} else {
+			app.serverError(w, r, err)
+		}
+		return models.Profile{}, false
+	}
+
+	return profile, true
+}
+
+// apiUser returns a user's public profile
+func (app *application) apiUser(w http.ResponseWriter, r *http.Request) {
+	profile, ok := app.apiUserProfile(w, r)
+	if !ok {
+		return
+	}
+
+	user := apiProfile{
+		Handle:   profile.Handle,
+		Name:     profile.Name,
+		Bio:      profile.Bio,
+		URL:      app.baseURL + profileURL(profile.Handle),
+		Snippets: profile.Snippets,
+		Stars:    profile.Stars,
+		Joined:   profile.Created,
+	}
+	if profile.HasAvatar {
+		user.AvatarURL = app.baseURL + avatarURL(profile.Handle)
+	}
+
+	writeJSON(w, http.StatusOK, user)
+}
+
+// apiUserSnippets lists the snippets shown on a user's profile
+func (app *application) apiUserSnippets(w http.ResponseWriter, r *http.Request) {
+	profile, ok := app.apiUserProfile(w, r)
+	if !ok {
+		return
+	}
+
+	pr, err := pageRequest(r, 20)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "The before or after cursor is malformed")
+		return
+	}
+
+	snippets, links, err := app.snippets.PublicByUser(profile.ID, pr)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, app.apiSnippetList(snippets, links))
+}
+
+// apiEndpoint describes one route of the JSON API. The routes are
+// registered and the OpenAPI document is generated from the same list, so
+// the two can't drift apart.
+type apiEndpoint struct {
+	Method   string
+	Path     string // Relative to apiPrefix
+	Summary  string
+	Scope    string // Token scope needed, which also means the user must be logged in; "" for anyone
+	Verified bool   // Whether the user's email must be verified
+	Strict   bool   // Whether the strict rate limit applies
+	Query    []apiParam
+	Request  any // Zero value of the request body's type, nil if there's no body
+	Status   int // Status of a successful response
+	Response any // Zero value of the response body's type, nil if there's no body
+	Handler  http.HandlerFunc
+}
+
+// apiParam is a query parameter of an endpoint
+type apiParam struct {
+	Name        string
+	Description string
+}
+
+// pageParams are the query parameters of endpoints that list snippets
+var pageParams = []apiParam{
+	{"size", "Snippets per page: 10, 20 (the default), 50 or 100"},
+	{"before", "Cursor of a page; lists the snippets newer than it"},
+	{"after", "Cursor of a page; lists the snippets older than it"},
+}
+
+// apiEndpoints returns every endpoint of the JSON API
+func (app *application) apiEndpoints() []apiEndpoint {
+	return []apiEndpoint{
+		{
+			Method: "GET", Path: "/snippets", Summary: "List the latest public snippets",
+			Query: pageParams, Status: http.StatusOK, Response: apiSnippetList{}, Handler: app.apiSnippetsLatest,
+		},
+		{
+			Method: "POST", Path: "/snippets", Summary: "Create a snippet",
+			Scope: models.ScopeWrite, Verified: true, Strict: true,
+			Request: apiSnippetCreate{}, Status: http.StatusCreated, Response: apiSnippet{}, Handler: app.apiSnippetCreate,
+		},
+		{
+			Method: "GET", Path: "/snippets/{slug}", Summary: "Get a snippet with its files",
+			Status: http.StatusOK, Response: apiSnippet{}, Handler: app.apiSnippetView,
+		},
+		{
+			Method: "PUT", Path: "/snippets/{slug}", Summary: "Save a new revision of one of your snippets",
+			Scope:   models.ScopeWrite,
+			Request: apiSnippetUpdate{}, Status: http.StatusOK, Response: apiSnippet{}, Handler: app.apiSnippetUpdate,
+		},
+		{
+			Method: "DELETE", Path: "/snippets/{slug}", Summary: "Move one of your snippets to the trash",
+			Scope: models.ScopeWrite, Status: http.StatusNoContent, Handler: app.apiSnippetDelete,
+		},
+		{
+			Method: "GET", Path: "/user", Summary: "Get your account",
+			Scope: models.ScopeRead, Status: http.StatusOK, Response: apiAccount{}, Handler: app.apiAccount,
+		},
+		{
+			Method: "GET", Path: "/user/snippets", Summary: "List your snippets, including unlisted and private ones",
+			Scope: models.ScopeRead, Query: pageParams, Status: http.StatusOK, Response: apiSnippetList{}, Handler: app.apiAccountSnippets,
+		},
+		{
+			Method: "GET", Path: "/users/{handle}", Summary: "Get a user's public profile",
+			Status: http.StatusOK, Response: apiProfile{}, Handler: app.apiUser,
+		},
+		{
+			Method: "GET", Path: "/users/{handle}/snippets", Summary: "List a user's public snippets",
+			Query: pageParams, Status: http.StatusOK, Response: apiSnippetList{}, Handler: app.apiUserSnippets,
+		},
+	}
+}
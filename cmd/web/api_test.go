@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shaheerkj/snippetbox/internal/models"
+	"github.com/shaheerkj/snippetbox/internal/validator"
+)
+
+func TestAPIEndpointsAreRouted(t *testing.T) {
+	app := newTestApplication(t, nil)
+	mux := app.router()
+
+	documented := app.openAPI()["paths"].(map[string]any)
+
+	for _, e := range app.apiEndpoints() {
+		t.Run(e.Method+" "+e.Path, func(t *testing.T) {
+			path := pathParamRX.ReplaceAllString(e.Path, "x")
+			r := httptest.NewRequest(e.Method, apiPrefix+path, nil)
+
+			_, pattern := mux.Handler(r)
+			if want := e.Method + " " + apiPrefix + e.Path; pattern != want {
+				t.Errorf("routed to %q; want %q", pattern, want)
+			}
+
+			item, _ := documented[e.Path].(map[string]any)
+			if _, ok := item[strings.ToLower(e.Method)]; !ok {
+				t.Error("not in the OpenAPI document")
+			}
+		})
+	}
+}
+
+func TestAPIMethodNotAllowed(t *testing.T) {
+	app := newTestApplication(t, nil)
+	ts := newTestServer(t, app.routes())
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		wantStatus int
+		wantAllow  string
+	}{
+		{"Deleting a user", http.MethodDelete, "/users/alice", http.StatusMethodNotAllowed, "GET, HEAD"},
+		{"Posting to your account", http.MethodPost, "/user", http.StatusMethodNotAllowed, "GET, HEAD"},
+		{"Patching a snippet", http.MethodPatch, "/snippets/abcDEF12345", http.StatusMethodNotAllowed, "GET, HEAD, PUT, DELETE"},
+		{"Deleting every snippet", http.MethodDelete, "/snippets", http.StatusMethodNotAllowed, "GET, HEAD, POST"},
+		{"Posting the OpenAPI document", http.MethodPost, "/openapi.json", http.StatusMethodNotAllowed, "GET, HEAD"},
+		{"Unknown path", http.MethodGet, "/nothing", http.StatusNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, ts.URL+apiPrefix+tt.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res, body := ts.do(t, req)
+
+			if res.StatusCode != tt.wantStatus {
+				t.Errorf("got status %d; want %d", res.StatusCode, tt.wantStatus)
+			}
+			if got := res.Header.Get("Allow"); got != tt.wantAllow {
+				t.Errorf("got Allow %q; want %q", got, tt.wantAllow)
+			}
+
+			var e apiError
+			err = json.Unmarshal([]byte(body), &e)
+			if err != nil {
+				t.Fatalf("body isn't an API error: %v\n%s", err, body)
+			}
+			if e.Error.Status != tt.wantStatus {
+				t.Errorf("got error status %d; want %d", e.Error.Status, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestReadJSON(t *testing.T) {
+	type body struct {
+		Title string   `json:"title"`
+		Tags  []string `json:"tags"`
+	}
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		wantOK      bool
+		wantStatus  int
+		wantMessage string
+	}{
+		{"Valid", "application/json", `{"title": "Hello", "tags": ["go"]}`, true, 0, ""},
+		{"Valid with charset", "application/json; charset=utf-8", `{"title": "Hello"}`, true, 0, ""},
+		{"Largest allowed", "application/json", `{"title": "` + strings.Repeat("a", maxAPIBodyBytes-13) + `"}`, true, 0, ""},
+		{"Too large", "application/json", `{"title": "` + strings.Repeat("a", maxAPIBodyBytes) + `"}`, false, http.StatusRequestEntityTooLarge, "can't be larger than"},
+		{"Unknown field", "application/json", `{"title": "Hello", "author": "mallory"}`, false, http.StatusBadRequest, `unknown field "author"`},
+		{"Not JSON", "text/plain", `{"title": "Hello"}`, false, http.StatusUnsupportedMediaType, "must be JSON"},
+		{"No content type", "", `{"title": "Hello"}`, false, http.StatusUnsupportedMediaType, "must be JSON"},
+		{"Empty", "application/json", ``, false, http.StatusBadRequest, "must not be empty"},
+		{"Badly formed", "application/json", `{"title": }`, false, http.StatusBadRequest, "badly-formed JSON at character"},
+		{"Cut short", "application/json", `{"title": "Hello"`, false, http.StatusBadRequest, "badly-formed JSON"},
+		{"Wrong type", "application/json", `{"title": 1}`, false, http.StatusBadRequest, `wrong type of value for "title"`},
+		{"Not an object", "application/json", `[]`, false, http.StatusBadRequest, "wrong type of value"},
+		{"Two values", "application/json", `{"title": "Hello"}{"title": "Again"}`, false, http.StatusBadRequest, "single JSON value"},
+		{"Trailing garbage", "application/json", `{"title": "Hello"} x`, false, http.StatusBadRequest, "single JSON value"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, apiPrefix+"/snippets", strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			rr := httptest.NewRecorder()
+
+			var dst body
+			ok := readJSON(rr, r, &dst)
+			if ok != tt.wantOK {
+				t.Fatalf("got %t; want %t (response %d %s)", ok, tt.wantOK, rr.Code, rr.Body)
+			}
+			if ok {
+				if rr.Body.Len() != 0 {
+					t.Errorf("wrote a response when it succeeded: %s", rr.Body)
+				}
+				return
+			}
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("got status %d; want %d", rr.Code, tt.wantStatus)
+			}
+			var e apiError
+			err := json.Unmarshal(rr.Body.Bytes(), &e)
+			if err != nil {
+				t.Fatalf("body isn't an API error: %v\n%s", err, rr.Body)
+			}
+			if e.Error.Status != tt.wantStatus || !strings.Contains(e.Error.Message, tt.wantMessage) {
+				t.Errorf("got error %+v; want status %d and a message containing %q", e.Error, tt.wantStatus, tt.wantMessage)
+			}
+		})
+	}
+}
+
+func TestAPIValidationError(t *testing.T) {
+	var v validator.Validator
+	v.AddFieldError("files[0].name", "This field cannot be blank")
+	v.AddFieldError("title", "This field cannot be more than 100 characters long")
+	v.AddNonFieldError("A snippet needs at least one file")
+
+	rr := httptest.NewRecorder()
+	apiValidationError(rr, v)
+
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("got status %d; want %d", rr.Code, http.StatusUnprocessableEntity)
+	}
+	if got := rr.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("got Content-Type %q; want application/json", got)
+	}
+
+	// Compare with the documented shape rather than apiError, so renaming
+	// its fields breaks the test as it would break clients
+	var got map[string]any
+	err := json.Unmarshal(rr.Body.Bytes(), &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{
+		"error": map[string]any{
+			"status":  float64(http.StatusUnprocessableEntity),
+			"message": "The request has problems; see field_errors and non_field_errors",
+			"field_errors": map[string]any{
+				"files[0].name": "This field cannot be blank",
+				"title":         "This field cannot be more than 100 characters long",
+			},
+			"non_field_errors": []any{"A snippet needs at least one file"},
+		},
+	}
+	gotJSON, _ := json.Marshal(got)
+	wantJSON, _ := json.Marshal(want)
+	if string(gotJSON) != string(wantJSON) {
+		t.Errorf("got %s; want %s", gotJSON, wantJSON)
+	}
+}
+
+func TestAPISnippetViewBurnAfterReading(t *testing.T) {
+	db := newTestDB(t)
+	app := newTestApplication(t, db)
+	ts := newTestServer(t, app.routes())
+
+	author := newTestUser(t, app, "alice", "alice@example.com", "pa55word!")
+	reader := newTestUser(t, app, "bob", "bob@example.com", "pa55word!")
+	files := []models.File{{Name: "secret.txt", Language: "plaintext", Content: "The launch code is 0000"}}
+
+	slug, err := app.snippets.Insert(author, "Secret", files, nil, time.Time{}, models.VisibilityUnlisted, true, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokens := make(map[int]string)
+	for _, id := range []int{author, reader} {
+		tokens[id], err = app.users.CreateAPIToken(id, "cli", models.ScopeRead)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		userID     int
+		wantStatus int
+		wantFiles  []apiFile
+	}{
+		{"Author", author, http.StatusOK, []apiFile{{Name: "secret.txt", Language: "plaintext", Content: "The launch code is 0000"}}},
+		{"Someone else", reader, http.StatusNotFound, nil},
+		{"Anonymous", 0, http.StatusNotFound, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, ts.URL+apiPrefix+"/snippets/"+slug, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.userID != 0 {
+				req.Header.Set("Authorization", "Bearer "+tokens[tt.userID])
+			}
+			res, body := ts.do(t, req)
+
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("got status %d; want %d", res.StatusCode, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var got apiSnippet
+			err = json.Unmarshal([]byte(body), &got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got.Files, tt.wantFiles) {
+				t.Errorf("got files %+v; want %+v", got.Files, tt.wantFiles)
+			}
+		})
+	}
+
+	// Viewing it through the API mustn't have destroyed it
+	s, err := app.snippets.Consume(slug, reader)
+	if err != nil {
+		t.Fatalf("couldn't reveal it after the author viewed it: %v", err)
+	}
+	if len(s.Files) != 1 {
+		t.Errorf("got %d files when revealing it; want 1", len(s.Files))
+	}
+}
@@ -0,0 +1,17 @@
+package main
+
+// contextKey is the type of the keys the application stores request context
+// values under, so they can't clash with keys set by other packages
+type contextKey string
+
+// isAuthenticatedContextKey is set by the authenticate middleware once it has
+// checked the session's user still exists and the session is still valid
+const isAuthenticatedContextKey = contextKey("isAuthenticated")
+
+// tokenUserIDContextKey and tokenScopeContextKey are set by the
+// authenticateToken middleware for requests made with a personal access
+// token
+const (
+	tokenUserIDContextKey = contextKey("tokenUserID")
+	tokenScopeContextKey  = contextKey("tokenScope")
+)
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/shaheerkj/snippetbox/internal/mailer"
+	"github.com/shaheerkj/snippetbox/internal/models"
+)
+
+// verificationEmail holds the link to verify a new account's address
+func verificationEmail(to, name, link string) mailer.Message {
+	return mailer.Message{
+		To:      to,
+		Subject: "Verify your Snippetbox email address",
+		Body: fmt.Sprintf(`Hi %s,
+
+Thanks for signing up to Snippetbox. Follow this link to verify your email
+address, so you can start creating snippets:
+
+%s
+
+If you didn't sign up, you can ignore this email and the account will be
+deleted.
+`, name, link),
+	}
+}
+
+// passwordResetEmail holds the link to reset a forgotten password
+func passwordResetEmail(to, name, link string) mailer.Message {
+	return mailer.Message{
+		To:      to,
+		Subject: "Reset your Snippetbox password",
+		Body: fmt.Sprintf(`Hi %s,
+
+Someone asked to reset the password of your Snippetbox account. To choose a
+new password, follow this link within %d minutes:
+
+%s
+
+If it wasn't you, you can ignore this email; your password hasn't changed.
+`, name, models.PasswordResetMinutes, link),
+	}
+}
+
+// emailChangeEmail asks to confirm a new email address
+func emailChangeEmail(to, name, link string) mailer.Message {
+	return mailer.Message{
+		To:      to,
+		Subject: "Confirm your new Snippetbox email address",
+		Body: fmt.Sprintf(`Hi %s,
+
+To start using this address for your Snippetbox account, follow this link
+within %d hours:
+
+%s
+
+If you didn't ask for this, you can ignore this email.
+`, name, models.EmailChangeHours, link),
+	}
+}
+
+// emailChangeNotice warns the current address of a user that an email
+// change has been requested, in case it wasn't them
+func emailChangeNotice(to, name, newEmail string) mailer.Message {
+	return mailer.Message{
+		To:      to,
+		Subject: "Your Snippetbox email address is being changed",
+		Body: fmt.Sprintf(`Hi %s,
+
+Someone signed in to your Snippetbox account asked to change its email
+address to %s. It will change once the link sent there is followed.
+
+If it wasn't you, change your password straight away.
+`, name, newEmail),
+	}
+}
+
+// lockoutEmail tells the owner of an account that logging in to it has been
+// locked after too many wrong passwords
+func lockoutEmail(to, name, ip, resetLink string) mailer.Message {
+	return mailer.Message{
+		To:      to,
+		Subject: "Your Snippetbox account has been locked",
+		Body: fmt.Sprintf(`Hi %s,
+
+There have been %d failed attempts to log in to your Snippetbox account, the
+latest from %s, so logging in has been locked for %s.
+
+If this was you, you can try again once the lock is over. If it wasn't,
+someone may be trying to guess your password; you can choose a new one here:
+
+%s
+`, name, loginByEmailPolicy.LockoutAfter, ip, waitText(loginByEmailPolicy.Lockout), resetLink),
+	}
+}
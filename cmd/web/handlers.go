@@ -1,41 +1,162 @@
 package main
 
 import (
+	"archive/zip"
+	"bytes"
 	"errors"
 	"fmt"
+	"mime"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/shaheerkj/snippetbox/internal/highlight"
 	"github.com/shaheerkj/snippetbox/internal/models"
+	"github.com/shaheerkj/snippetbox/internal/qrcode"
+	"github.com/shaheerkj/snippetbox/internal/signer"
+	"github.com/shaheerkj/snippetbox/internal/totp"
 	"github.com/shaheerkj/snippetbox/internal/validator"
 )
 
 // snippetCreateForm holds form data and validation errors for snippet creation
 type snippetCreateForm struct {
-	Title               string     `form:"title"`
-	Content             string     `form:"content"`
-	Expires             int        `form:"expires"`
-	validator.Validator `form:"-"` // "-" tells to ignore this field during decoding
+	Title               string            `form:"title"`
+	Files               []snippetFileForm `form:"files"`
+	Tags                string            `form:"tags"`       // Separated by commas or spaces
+	Expires             string            `form:"expires"`    // One of the expiry options, e.g. "1w" or "custom"
+	ExpiresAt           string            `form:"expires_at"` // Custom expiry as a datetime-local value, in UTC
+	Visibility          string            `form:"visibility"`
+	BurnAfterReading    bool              `form:"burn_after_reading"`
+	Passphrase          string            `form:"passphrase"`
+	validator.Validator `form:"-"`        // "-" tells to ignore this field during decoding
 	// removing the explicit fieldErrors struct field and instead
 	// embedding the validator struct.
 }
+
+// snippetFileForm holds one file of a snippet being created or edited
+type snippetFileForm struct {
+	Name     string `form:"name"`
+	Language string `form:"language"` // Empty to detect the language from the name and content
+	Content  string `form:"content"`
+}
+
+// snippetEditForm holds form data and validation errors for editing a snippet
+type snippetEditForm struct {
+	Title               string            `form:"title"`
+	Files               []snippetFileForm `form:"files"`
+	Tags                string            `form:"tags"`
+	validator.Validator `form:"-"`
+}
+
+// snippetUnlockForm holds the passphrase entered for a protected snippet
+type snippetUnlockForm struct {
+	Passphrase          string `form:"passphrase"`
+	validator.Validator `form:"-"`
+}
+
+// searchForm holds the query and filters of a search, which come from the URL
+type searchForm struct {
+	Q                   string `form:"q"`
+	Language            string `form:"language"`
+	Author              string `form:"author"` // Handle of the author
+	From                string `form:"from"`   // Earliest creation date, as YYYY-MM-DD
+	To                  string `form:"to"`     // Latest creation date, included in the results
+	validator.Validator `form:"-"`
+}
+
 type userSignupForm struct {
 	Name                string `form:"name"`
+	Handle              string `form:"handle"`
 	Email               string `form:"email"`
 	Password            string `form:"password"`
 	validator.Validator `form:"-"`
 }
 
+// userProfileForm holds the public details a user can change about
+// themselves. The avatar is read from the multipart form separately.
+type userProfileForm struct {
+	Name                string `form:"name"`
+	Handle              string `form:"handle"`
+	Bio                 string `form:"bio"`
+	RemoveAvatar        bool   `form:"remove_avatar"`
+	validator.Validator `form:"-"`
+}
+
+// accountConfirmForm holds the password a user enters to re-authenticate
+type accountConfirmForm struct {
+	Password            string `form:"password"`
+	validator.Validator `form:"-"`
+}
+
+// passwordChangeForm holds form data and validation errors for changing the
+// current user's password
+type passwordChangeForm struct {
+	CurrentPassword     string `form:"current_password"`
+	NewPassword         string `form:"new_password"`
+	ConfirmPassword     string `form:"confirm_password"`
+	validator.Validator `form:"-"`
+}
+
+// emailChangeForm holds the new address a user wants to change to
+type emailChangeForm struct {
+	Email               string `form:"email"`
+	validator.Validator `form:"-"`
+}
+
+// emailConfirmForm holds the token from an email change confirmation link
+type emailConfirmForm struct {
+	Token               string `form:"token"`
+	validator.Validator `form:"-"`
+}
+
+// accountDeleteForm holds what to do with a user's snippets when they delete
+// their account: "keep" them up without an author or "delete" them
+type accountDeleteForm struct {
+	Snippets            string `form:"snippets"`
+	validator.Validator `form:"-"`
+}
+
+// passwordResetRequestForm holds the email of a user who forgot their
+// password
+type passwordResetRequestForm struct {
+	Email               string `form:"email"`
+	validator.Validator `form:"-"`
+}
+
+// passwordResetForm holds the token from a password reset link and the new
+// password
+type passwordResetForm struct {
+	Token               string `form:"token"`
+	NewPassword         string `form:"new_password"`
+	ConfirmPassword     string `form:"confirm_password"`
+	validator.Validator `form:"-"`
+}
+
 type userLoginForm struct {
 	Email               string `form:"email"`
 	Password            string `form:"password"`
 	validator.Validator `form:"-"`
 }
 
+// apiTokenForm holds the name and scope of a new personal access token
+type apiTokenForm struct {
+	Name                string `form:"name"`
+	Scope               string `form:"scope"`
+	validator.Validator `form:"-"`
+}
+
+// twoFactorForm holds a code from an authenticator app or a recovery code
+type twoFactorForm struct {
+	Code                string `form:"code"`
+	validator.Validator `form:"-"`
+}
+
 // home displays the homepage with the latest snippets
 func (app *application) home(w http.ResponseWriter, r *http.Request) {
 	// Fetch the 10 most recent non-expired snippets from database
-	snippets, err := app.snippets.Latest()
+	snippets, links, err := app.snippets.Latest(models.PageRequest{Size: 10})
 	if err != nil {
 		app.serverError(w, r, err)
 		return
@@ -44,23 +165,95 @@ func (app *application) home(w http.ResponseWriter, r *http.Request) {
 	// Prepare template data with snippets and common data (current year, etc.)
 	data := app.newTemplateData(r)
 	data.Snippets = snippets
+	// Older snippets are browsed on the archive page
+	setPageLinks(&data, r, "/snippets", links)
 
 	// Render the home template with 200 OK status
 	app.render(w, r, http.StatusOK, "home.html", data)
 }
 
-// snippetView displays a specific snippet by ID
+// snippetArchive lets visitors page through every public snippet
+func (app *application) snippetArchive(w http.ResponseWriter, r *http.Request) {
+	pr, err := pageRequest(r, 20)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	snippets, links, err := app.snippets.Latest(pr)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Snippets = snippets
+	data.PageSize = pr.Size
+	data.PageSizes = pageSizes
+	setPageLinks(&data, r, "/snippets", links)
+	app.render(w, r, http.StatusOK, "archive.html", data)
+}
+
+// tagCloud shows the most used tags, sized by how many snippets have them
+func (app *application) tagCloud(w http.ResponseWriter, r *http.Request) {
+	tags, err := app.snippets.TagCloud(100)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.TagCloud = newTagCloud(tags)
+	app.render(w, r, http.StatusOK, "tags.html", data)
+}
+
+// tagView lists the public snippets with a tag
+func (app *application) tagView(w http.ResponseWriter, r *http.Request) {
+	tag := r.PathValue("tag")
+	if !validator.Matches(tag, validator.TagRX) {
+		http.NotFound(w, r)
+		return
+	}
+
+	pr, err := pageRequest(r, 20)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	snippets, links, err := app.snippets.ByTag(tag, pr)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Tag = tag
+	data.Snippets = snippets
+	setPageLinks(&data, r, tagURL(tag), links)
+	app.render(w, r, http.StatusOK, "tag.html", data)
+}
+
+// snippetView displays a specific snippet by slug
 func (app *application) snippetView(w http.ResponseWriter, r *http.Request) {
-	// Extract the id parameter from the URL path and convert to integer
-	id, err := strconv.Atoi(r.PathValue("id"))
-	if err != nil || id < 1 {
-		// ID is not a valid positive integer
+	// Numeric IDs come from links made before slugs were introduced.
+	// Redirect them to the slug URL, but only for public snippets.
+	if id, err := strconv.Atoi(r.PathValue("slug")); err == nil {
+		app.legacySnippetRedirect(w, r, id)
+		return
+	}
+
+	// Extract the slug parameter from the URL path
+	slug, ok := snippetSlug(r)
+	if !ok {
+		// Not something we could have generated
 		http.NotFound(w, r)
 		return
 	}
 
-	// Fetch the snippet from the database
-	snippet, err := app.snippets.Get(id)
+	// Fetch the snippet from the database; private snippets are only
+	// returned to their author
+	snippet, err := app.snippets.Get(slug, app.authenticatedUserID(r))
 	if err != nil {
 		if errors.Is(err, models.ErrNoRecord) {
 			// Snippet doesn't exist or has expired
@@ -72,157 +265,1836 @@ func (app *application) snippetView(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Prepare template data and render the view
 	data := app.newTemplateData(r)
+
+	// Protected snippets need their passphrase before anything else
+	if app.snippetLocked(r, snippet) {
+		snippet.Files = nil
+		data.Snippet = snippet
+		data.Form = snippetUnlockForm{}
+		app.render(w, r, http.StatusOK, "unlock.html", data)
+		return
+	}
+
+	// Burn after reading snippets are only shown once the viewer confirms,
+	// so link previews and crawlers that just GET the page don't destroy them
+	if snippet.BurnAfterReading {
+		data.Snippet = snippet
+		app.render(w, r, http.StatusOK, "reveal.html", data)
+		return
+	}
+
+	data.ShownRevision = snippet.Revision
+
+	// A ?rev=N permalink shows an earlier version in place of the current one
+	if rev := r.URL.Query().Get("rev"); rev != "" {
+		n, err := strconv.Atoi(rev)
+		if err != nil || n < 1 || n > snippet.Revision {
+			http.NotFound(w, r)
+			return
+		}
+		if n != snippet.Revision {
+			revision, err := app.snippets.GetRevision(snippet.ID, n, app.authenticatedUserID(r))
+			if err != nil {
+				if errors.Is(err, models.ErrNoRecord) {
+					http.NotFound(w, r)
+				} else {
+					app.serverError(w, r, err)
+				}
+				return
+			}
+			snippet.Title = revision.Title
+			snippet.Files = revision.Files
+			snippet.Updated = revision.Created
+			data.ShownRevision = n
+		}
+	}
+
+	// Markdown snippets are rendered unless the raw source is asked for
+	data.ShowSource = r.URL.Query().Get("source") == "1"
+
+	// Prepare template data and render the view
 	data.Snippet = snippet
 	app.render(w, r, http.StatusOK, "view.html", data)
 }
 
-// snippetCreate displays the form for creating a new snippet
-func (app *application) snippetCreate(w http.ResponseWriter, r *http.Request) {
-	// Prepare template data with default form values
-	data := app.newTemplateData(r)
-	data.Form = snippetCreateForm{
-		Expires: 365, // Default to 365 days
+// snippetRevealPost shows a burn after reading snippet and destroys it
+func (app *application) snippetRevealPost(w http.ResponseWriter, r *http.Request) {
+	slug, ok := snippetSlug(r)
+	if !ok {
+		http.NotFound(w, r)
+		return
 	}
-	app.render(w, r, http.StatusOK, "create.html", data)
-}
-
-// snippetCreatePost handles the POST request to create a new snippet
-func (app *application) snippetCreatePost(w http.ResponseWriter, r *http.Request) {
-	// Parse the form data from the request body
 
-	var form snippetCreateForm
-	err := app.decodePostForm(r, &form)
+	// A protected snippet must be unlocked before it can be revealed
+	snippet, err := app.snippets.Get(slug, app.authenticatedUserID(r))
 	if err != nil {
-		app.clientError(w, http.StatusBadRequest)
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
 		return
 	}
-	// Validate title field
-	form.CheckField(validator.NotBlank(form.Title), "title", "This field cannot be blank")
-	form.CheckField(validator.MaxChars(form.Title, 100), "title", "Cannot be more than 100 characters long.")
-
-	form.CheckField(validator.NotBlank(form.Content), "content", "This field cannot be blank")
-	form.CheckField(validator.PermittedValue(form.Expires, 1, 7, 365), "expires", "This field can only be 1, 7 or 365")
-
-	if !form.Valid() {
-		data := app.newTemplateData(r)
-		data.Form = form
-		app.render(w, r, http.StatusUnprocessableEntity, "create.html", data)
+	if app.snippetLocked(r, snippet) {
+		http.Redirect(w, r, fmt.Sprintf("/snippet/view/%s", slug), http.StatusSeeOther)
 		return
 	}
 
-	id, err := app.snippets.Insert(form.Title, form.Content, form.Expires)
+	// Consume marks the snippet as read atomically, so if two people reveal
+	// it at the same time only one of them gets the content
+	snippet, err = app.snippets.Consume(slug, app.authenticatedUserID(r))
 	if err != nil {
-		app.serverError(w, r, err)
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
 		return
 	}
 
-	app.sessionManager.Put(r.Context(), "flash", "Snippet successfully created!")
-
-	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", id), http.StatusSeeOther)
-}
+	// The content no longer exists anywhere else, so don't let it be cached
+	w.Header().Set("Cache-Control", "no-store")
 
-func (app *application) userSignup(w http.ResponseWriter, r *http.Request) {
 	data := app.newTemplateData(r)
-	data.Form = userSignupForm{}
-	app.render(w, r, http.StatusOK, "signup.html", data)
-	// fmt.Fprintln(w, "Dislay a form for signing up a user...")
+	data.Snippet = snippet
+	data.ShownRevision = snippet.Revision
+	app.render(w, r, http.StatusOK, "view.html", data)
 }
-func (app *application) userSignupPost(w http.ResponseWriter, r *http.Request) {
-	var form userSignupForm
 
-	err := app.decodePostForm(r, &form)
-	if err != nil {
-		app.clientError(w, http.StatusBadRequest)
+// snippetUnlockPost checks the passphrase for a protected snippet and, if
+// it's right, remembers in the session that this visitor may read it
+func (app *application) snippetUnlockPost(w http.ResponseWriter, r *http.Request) {
+	slug, ok := snippetSlug(r)
+	if !ok {
+		http.NotFound(w, r)
 		return
 	}
-	form.CheckField(validator.NotBlank(form.Name), "name", "Cannot be blank")
-	form.CheckField(validator.NotBlank(form.Email), "email", "Cannot be blank")
-	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email", "This field must be a valid email")
-	form.CheckField(validator.MinChars(form.Password, 8), "password", "This must be 8 characters long")
 
-	if !form.Valid() {
-		data := app.newTemplateData(r)
-		data.Form = form
-		app.render(w, r, http.StatusUnprocessableEntity, "signup.html", data)
+	snippet, err := app.snippets.Get(slug, app.authenticatedUserID(r))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
 		return
 	}
+	snippet.Files = nil
 
-	err = app.users.Insert(form.Name, form.Email, form.Password)
+	var form snippetUnlockForm
+	err = app.decodePostForm(r, &form)
 	if err != nil {
-		if errors.Is(err, models.ErrDuplicateEmail) {
-			form.AddFieldError("email", "Email Address is already in use")
-			data := app.newTemplateData(r)
-			data.Form = form
-			app.render(w, r, http.StatusUnprocessableEntity, "signup.html", data)
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
 
-		} else {
+	form.CheckField(validator.NotBlank(form.Passphrase), "passphrase", "This field cannot be blank")
+
+	if form.Valid() {
+		err = app.snippets.Unlock(slug, app.authenticatedUserID(r), form.Passphrase)
+		switch {
+		case errors.Is(err, models.ErrInvalidCredentials):
+			form.AddNonFieldError("The passphrase is incorrect")
+		case errors.Is(err, models.ErrTooManyAttempts):
+			form.AddNonFieldError(fmt.Sprintf("Too many incorrect attempts. Please try again in %d minutes.", models.UnlockLockoutMinutes))
+		case errors.Is(err, models.ErrNoRecord):
+			http.NotFound(w, r)
+			return
+		case err != nil:
 			app.serverError(w, r, err)
+			return
 		}
+	}
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Snippet = snippet
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "unlock.html", data)
 		return
 	}
-	app.sessionManager.Put(r.Context(), "flash", "Your signup was successful. Please log in")
-	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
 
-}
+	app.sessionManager.Put(r.Context(), "unlocked:"+slug, true)
 
-func (app *application) userLogin(w http.ResponseWriter, r *http.Request) {
-	data := app.newTemplateData(r)
-	data.Form = userLoginForm{}
-	app.render(w, r, http.StatusOK, "login.html", data)
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%s", slug), http.StatusSeeOther)
 }
-func (app *application) userLoginPost(w http.ResponseWriter, r *http.Request) {
 
-	var form userLoginForm
+// legacySnippetRedirect sends an old numeric snippet URL to its slug URL
+func (app *application) legacySnippetRedirect(w http.ResponseWriter, r *http.Request, id int) {
+	if id < 1 {
+		http.NotFound(w, r)
+		return
+	}
 
-	err := app.decodePostForm(r, &form)
+	slug, err := app.snippets.PublicSlug(id)
 	if err != nil {
-		app.clientError(w, http.StatusBadRequest)
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
 		return
 	}
 
-	form.CheckField(validator.NotBlank(form.Email), "email", "This field cannot be blank")
-	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email", "This field must be a valid email address")
-	form.CheckField(validator.NotBlank(form.Password), "password", "This field cannot be blank")
+	// Keep the query string so ?rev=N permalinks survive the redirect
+	url := fmt.Sprintf("/snippet/view/%s", slug)
+	if r.URL.RawQuery != "" {
+		url += "?" + r.URL.RawQuery
+	}
+	http.Redirect(w, r, url, http.StatusMovedPermanently)
+}
 
-	if !form.Valid() {
-		data := app.newTemplateData(r)
-		data.Form = form
-		app.render(w, r, http.StatusUnprocessableEntity, "login.html", data)
+// snippetHistory lists every revision of a snippet and shows a diff between
+// two of them, chosen with the from and to query parameters
+func (app *application) snippetHistory(w http.ResponseWriter, r *http.Request) {
+	slug, ok := snippetSlug(r)
+	if !ok {
+		http.NotFound(w, r)
 		return
 	}
 
-	id, err := app.users.Authenticate(form.Email, form.Password)
+	// Get applies the expiry and visibility rules, so check the snippet is
+	// viewable first
+	snippet, err := app.snippets.Get(slug, app.authenticatedUserID(r))
 	if err != nil {
-		if errors.Is(err, models.ErrInvalidCredentials) {
-			form.AddNonFieldError("Email or password is incorrect")
-			data := app.newTemplateData(r)
-			data.Form = form
-			app.render(w, r, http.StatusUnprocessableEntity, "login.html", data)
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
 		} else {
 			app.serverError(w, r, err)
 		}
 		return
 	}
-	err = app.sessionManager.RenewToken(r.Context())
+
+	// Burn after reading snippets have no history worth showing, and earlier
+	// revisions must not give away the content
+	if snippet.BurnAfterReading {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Send visitors who haven't unlocked a protected snippet to the unlock form
+	if app.snippetLocked(r, snippet) {
+		http.Redirect(w, r, fmt.Sprintf("/snippet/view/%s", slug), http.StatusSeeOther)
+		return
+	}
+
+	revisions, err := app.snippets.Revisions(snippet.ID, app.authenticatedUserID(r))
 	if err != nil {
 		app.serverError(w, r, err)
 		return
 	}
 
-	app.sessionManager.Put(r.Context(), "authenticatedUserID", id)
-	http.Redirect(w, r, "/snippet/create", http.StatusSeeOther)
+	data := app.newTemplateData(r)
+	data.Snippet = snippet
+	data.Revisions = revisions
+
+	// By default compare the current revision with the one before it
+	from, to := snippet.Revision-1, snippet.Revision
+	if v := r.URL.Query().Get("from"); v != "" {
+		from, err = strconv.Atoi(v)
+		if err != nil {
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+	}
+	if v := r.URL.Query().Get("to"); v != "" {
+		to, err = strconv.Atoi(v)
+		if err != nil {
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Revisions are numbered 1..N with no gaps
+	if from >= 1 && from <= len(revisions) && to >= 1 && to <= len(revisions) {
+		fromFiles, err := app.revisionFiles(r, snippet, from)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		toFiles, err := app.revisionFiles(r, snippet, to)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		data.DiffFrom = from
+		data.DiffTo = to
+		data.Diff = diffFiles(fromFiles, toFiles)
+	}
+
+	app.render(w, r, http.StatusOK, "history.html", data)
 }
-func (app *application) userLogoutPost(w http.ResponseWriter, r *http.Request) {
-	err := app.sessionManager.RenewToken(r.Context())
-	if err != nil {
-		app.serverError(w, r, err)
+
+// snippetZip sends every file of a snippet as a zip archive
+func (app *application) snippetZip(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.readableSnippet(w, r)
+	if !ok {
 		return
 	}
+	slug := snippet.Slug
 
-	app.sessionManager.Remove(r.Context(), "authenticatedUserID")
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.zip"`, slug))
 
-	app.sessionManager.Put(r.Context(), "flash", "You've been logged out successfully")
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+	// Files go in a directory named after the slug so extracting the
+	// archive doesn't scatter them into the current directory
+	zw := zip.NewWriter(w)
+	for _, f := range snippet.Files {
+		fw, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     slug + "/" + f.Name,
+			Method:   zip.Deflate,
+			Modified: snippet.Updated,
+		})
+		if err != nil {
+			app.logger.Error(err.Error(), "method", r.Method, "uri", r.URL.RequestURI())
+			return
+		}
+		_, err = fw.Write([]byte(f.Content))
+		if err != nil {
+			app.logger.Error(err.Error(), "method", r.Method, "uri", r.URL.RequestURI())
+			return
+		}
+	}
+
+	// The response has already started, so errors can only be logged
+	err := zw.Close()
+	if err != nil {
+		app.logger.Error(err.Error(), "method", r.Method, "uri", r.URL.RequestURI())
+	}
+}
+
+// snippetRaw sends one file of a snippet as plain text, for use with curl
+// and the like. The first file is sent unless ?file= names another.
+func (app *application) snippetRaw(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.readableSnippet(w, r)
+	if !ok {
+		return
+	}
+
+	file, ok := snippetFile(r, snippet)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	setRawHeaders(w)
+	w.Write([]byte(file.Content))
+}
+
+// snippetDownload sends one file of a snippet as an attachment, chosen the
+// same way as for snippetRaw
+func (app *application) snippetDownload(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.readableSnippet(w, r)
+	if !ok {
+		return
+	}
+
+	file, ok := snippetFile(r, snippet)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	setRawHeaders(w)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": downloadName(snippet, file),
+	}))
+	w.Write([]byte(file.Content))
+}
+
+// snippetCreate displays the form for creating a new snippet
+func (app *application) snippetCreate(w http.ResponseWriter, r *http.Request) {
+	// Prepare template data with default form values
+	data := app.newTemplateData(r)
+	data.Form = snippetCreateForm{
+		Files:      []snippetFileForm{{}}, // Start with a single empty file
+		Expires:    "1y",                  // Default to one year
+		Visibility: models.VisibilityPublic,
+	}
+	app.render(w, r, http.StatusOK, "create.html", data)
+}
+
+// snippetCreatePost handles the POST request to create a new snippet
+func (app *application) snippetCreatePost(w http.ResponseWriter, r *http.Request) {
+	// Parse the form data from the request body
+
+	var form snippetCreateForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	tags, expires := checkSnippetCreateForm(&form)
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "create.html", data)
+		return
+	}
+
+	slug, err := app.snippets.Insert(app.authenticatedUserID(r), form.Title, snippetFiles(form.Files), tags, expires, form.Visibility, form.BurnAfterReading, form.Passphrase)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	if form.BurnAfterReading {
+		app.sessionManager.Put(r.Context(), "flash", "Snippet successfully created! It will be destroyed after it's read once.")
+	} else {
+		app.sessionManager.Put(r.Context(), "flash", "Snippet successfully created!")
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%s", slug), http.StatusSeeOther)
+}
+
+// search shows the snippets matching the q query parameter, narrowed down by
+// the optional filters
+func (app *application) search(w http.ResponseWriter, r *http.Request) {
+	var form searchForm
+	err := app.formDecoder.Decode(&form, r.URL.Query())
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.MaxChars(form.Q, 200), "q", "Cannot be more than 200 characters long.")
+	form.CheckField(form.Language == "" || highlight.Supported(form.Language), "language", "Please choose one of the listed languages")
+
+	filters := models.SearchFilters{
+		Language: form.Language,
+		Author:   strings.TrimSpace(form.Author),
+	}
+	if form.From != "" {
+		filters.From, err = time.Parse(dateLayout, form.From)
+		form.CheckField(err == nil, "from", "Please enter a valid date")
+	}
+	if form.To != "" {
+		filters.To, err = time.Parse(dateLayout, form.To)
+		form.CheckField(err == nil, "to", "Please enter a valid date")
+		// Include the whole of the last day
+		filters.To = filters.To.AddDate(0, 0, 1)
+	}
+
+	pr, err := pageRequest(r, 20)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	data := app.newTemplateData(r)
+
+	if !form.Valid() {
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "search.html", data)
+		return
+	}
+
+	if validator.NotBlank(form.Q) {
+		results, links, err := app.snippets.Search(form.Q, filters, pr, app.authenticatedUserID(r))
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		data.SearchResults = results
+		setPageLinks(&data, r, "/search", links)
+	}
+
+	data.Form = form
+	app.render(w, r, http.StatusOK, "search.html", data)
+}
+
+// userSnippets lists the snippets created by the logged in user
+func (app *application) userSnippets(w http.ResponseWriter, r *http.Request) {
+	pr, err := pageRequest(r, 20)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	snippets, links, err := app.snippets.ByUser(app.authenticatedUserID(r), pr)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Snippets = snippets
+	setPageLinks(&data, r, "/user/snippets", links)
+	app.render(w, r, http.StatusOK, "mysnippets.html", data)
+}
+
+// snippetEdit displays the edit form for a snippet owned by the current user
+func (app *application) snippetEdit(w http.ResponseWriter, r *http.Request) {
+	slug, ok := snippetSlug(r)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	snippet, err := app.snippets.Get(slug, app.authenticatedUserID(r))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	// Only the author may edit; don't reveal the snippet exists to anyone else.
+	// Burn after reading snippets can't be edited at all.
+	if snippet.UserID != app.authenticatedUserID(r) || snippet.BurnAfterReading {
+		http.NotFound(w, r)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Snippet = snippet
+	form := snippetEditForm{Title: snippet.Title, Tags: strings.Join(snippet.Tags, " ")}
+	for _, f := range snippet.Files {
+		form.Files = append(form.Files, snippetFileForm{Name: f.Name, Language: f.Language, Content: f.Content})
+	}
+	data.Form = form
+	app.render(w, r, http.StatusOK, "edit.html", data)
+}
+
+// snippetEditPost saves a new revision of a snippet
+func (app *application) snippetEditPost(w http.ResponseWriter, r *http.Request) {
+	slug, ok := snippetSlug(r)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	var form snippetEditForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	tags := checkSnippetEditForm(&form)
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Snippet.Slug = slug
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "edit.html", data)
+		return
+	}
+
+	// Update checks ownership itself, so a non-owner gets ErrNoRecord
+	err = app.snippets.Update(slug, app.authenticatedUserID(r), form.Title, snippetFiles(form.Files), tags)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Snippet successfully updated!")
+
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%s", slug), http.StatusSeeOther)
+}
+
+// snippetDeletePost moves a snippet owned by the current user to the trash
+func (app *application) snippetDeletePost(w http.ResponseWriter, r *http.Request) {
+	slug, ok := snippetSlug(r)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	err := app.snippets.Delete(slug, app.authenticatedUserID(r))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Snippet moved to the trash.")
+
+	http.Redirect(w, r, "/user/trash", http.StatusSeeOther)
+}
+
+// snippetRestorePost takes a snippet back out of the current user's trash
+func (app *application) snippetRestorePost(w http.ResponseWriter, r *http.Request) {
+	slug, ok := snippetSlug(r)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	err := app.snippets.Restore(slug, app.authenticatedUserID(r))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Snippet restored!")
+
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%s", slug), http.StatusSeeOther)
+}
+
+// snippetStarPost stars a snippet for the current user
+func (app *application) snippetStarPost(w http.ResponseWriter, r *http.Request) {
+	app.setStar(w, r, true)
+}
+
+// snippetUnstarPost removes the current user's star from a snippet
+func (app *application) snippetUnstarPost(w http.ResponseWriter, r *http.Request) {
+	app.setStar(w, r, false)
+}
+
+// setStar stars or unstars the snippet in the URL for the current user, as
+// long as they can see it, then sends them back to it
+func (app *application) setStar(w http.ResponseWriter, r *http.Request, star bool) {
+	slug, ok := snippetSlug(r)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	userID := app.authenticatedUserID(r)
+	snippet, err := app.snippets.Get(slug, userID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	if star {
+		err = app.snippets.Star(snippet.ID, userID)
+	} else {
+		err = app.snippets.Unstar(snippet.ID, userID)
+	}
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, snippetURL(snippet.Slug), http.StatusSeeOther)
+}
+
+// userTrash lists the current user's deleted snippets that can be restored
+func (app *application) userTrash(w http.ResponseWriter, r *http.Request) {
+	snippets, err := app.snippets.Trash(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Snippets = snippets
+	app.render(w, r, http.StatusOK, "trash.html", data)
+}
+
+// userProfile shows a user's public profile: their details, totals and a
+// page of their public snippets
+func (app *application) userProfile(w http.ResponseWriter, r *http.Request) {
+	handle := r.PathValue("handle")
+	if !validator.Matches(handle, validator.HandleRX) {
+		http.NotFound(w, r)
+		return
+	}
+
+	profile, err := app.users.Profile(handle)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	// Handles are matched regardless of case; send visitors to the one the
+	// user chose
+	if handle != profile.Handle {
+		http.Redirect(w, r, profileURL(profile.Handle), http.StatusMovedPermanently)
+		return
+	}
+
+	pr, err := pageRequest(r, 20)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	snippets, links, err := app.snippets.PublicByUser(profile.ID, pr)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Profile = profile
+	data.Snippets = snippets
+	setPageLinks(&data, r, profileURL(profile.Handle), links)
+	app.render(w, r, http.StatusOK, "profile.html", data)
+}
+
+// userAvatar serves a user's avatar image
+func (app *application) userAvatar(w http.ResponseWriter, r *http.Request) {
+	handle := r.PathValue("handle")
+	if !validator.Matches(handle, validator.HandleRX) {
+		http.NotFound(w, r)
+		return
+	}
+
+	image, contentType, updated, err := app.users.Avatar(handle)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	// Avatars were checked to be images when uploaded, but don't let
+	// browsers treat them as anything else
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; sandbox")
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeContent(w, r, "", updated, bytes.NewReader(image))
+}
+
+// userProfileEdit displays the profile form for the current user
+func (app *application) userProfileEdit(w http.ResponseWriter, r *http.Request) {
+	user, err := app.users.Get(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Profile = models.Profile{User: user}
+	data.Form = userProfileForm{
+		Name:   user.Name,
+		Handle: user.Handle,
+		Bio:    user.Bio,
+	}
+	app.render(w, r, http.StatusOK, "profile-edit.html", data)
+}
+
+// userProfileEditPost saves the current user's profile and avatar
+func (app *application) userProfileEditPost(w http.ResponseWriter, r *http.Request) {
+	userID := app.authenticatedUserID(r)
+	user, err := app.users.Get(userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// The form has to be multipart to carry the avatar
+	err = r.ParseMultipartForm(models.MaxAvatarBytes)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	var form userProfileForm
+	err = app.formDecoder.Decode(&form, r.PostForm)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.Name = strings.TrimSpace(form.Name)
+	form.CheckField(validator.NotBlank(form.Name), "name", "Cannot be blank")
+	form.CheckField(validator.MaxChars(form.Name, 255), "name", "Cannot be more than 255 characters long")
+	form.CheckField(validator.Matches(form.Handle, validator.HandleRX), "handle", "Must be 3 to 30 letters, digits, underscores or hyphens")
+	form.CheckField(validator.MaxChars(form.Bio, 500), "bio", "Cannot be more than 500 characters long")
+
+	var avatar []byte
+	var avatarType string
+	file, header, err := r.FormFile("avatar")
+	switch {
+	case err == nil:
+		defer file.Close()
+		avatar, avatarType, err = avatarImage(file, header.Size)
+		if err != nil {
+			form.AddFieldError("avatar", "Please choose a PNG, JPEG or GIF image of at most 256 KB")
+		}
+	case !errors.Is(err, http.ErrMissingFile):
+		app.serverError(w, r, err)
+		return
+	}
+
+	renderForm := func() {
+		data := app.newTemplateData(r)
+		data.Profile = models.Profile{User: user}
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "profile-edit.html", data)
+	}
+
+	if !form.Valid() {
+		renderForm()
+		return
+	}
+
+	err = app.users.UpdateProfile(userID, form.Name, form.Handle, form.Bio)
+	if err != nil {
+		if errors.Is(err, models.ErrDuplicateHandle) {
+			form.AddFieldError("handle", "Handle is already taken")
+			renderForm()
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	switch {
+	case avatar != nil:
+		err = app.users.SetAvatar(userID, avatarType, avatar)
+	case form.RemoveAvatar:
+		err = app.users.RemoveAvatar(userID)
+	}
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Your profile has been saved.")
+
+	http.Redirect(w, r, profileURL(form.Handle), http.StatusSeeOther)
+}
+
+func (app *application) userSignup(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = userSignupForm{}
+	app.render(w, r, http.StatusOK, "signup.html", data)
+	// fmt.Fprintln(w, "Dislay a form for signing up a user...")
+}
+func (app *application) userSignupPost(w http.ResponseWriter, r *http.Request) {
+	var form userSignupForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	form.CheckField(validator.NotBlank(form.Name), "name", "Cannot be blank")
+	form.CheckField(validator.Matches(form.Handle, validator.HandleRX), "handle", "Must be 3 to 30 letters, digits, underscores or hyphens")
+	form.CheckField(validator.NotBlank(form.Email), "email", "Cannot be blank")
+	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email", "This field must be a valid email")
+	form.CheckField(validator.MinChars(form.Password, 8), "password", "This must be 8 characters long")
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "signup.html", data)
+		return
+	}
+
+	id, err := app.users.Insert(form.Name, form.Handle, form.Email, form.Password)
+	if err != nil {
+		if errors.Is(err, models.ErrDuplicateEmail) {
+			form.AddFieldError("email", "Email Address is already in use")
+			data := app.newTemplateData(r)
+			data.Form = form
+			app.render(w, r, http.StatusUnprocessableEntity, "signup.html", data)
+
+		} else if errors.Is(err, models.ErrDuplicateHandle) {
+			form.AddFieldError("handle", "Handle is already taken")
+			data := app.newTemplateData(r)
+			data.Form = form
+			app.render(w, r, http.StatusUnprocessableEntity, "signup.html", data)
+
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	app.sendVerificationEmail(models.User{ID: id, Name: form.Name, Email: form.Email})
+
+	app.sessionManager.Put(r.Context(), "flash", "Your signup was successful. Check your email for a link to verify your address, then log in")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+
+}
+
+func (app *application) userLogin(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = userLoginForm{}
+	app.render(w, r, http.StatusOK, "login.html", data)
+}
+func (app *application) userLoginPost(w http.ResponseWriter, r *http.Request) {
+
+	var form userLoginForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Email), "email", "This field cannot be blank")
+	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email", "This field must be a valid email address")
+	form.CheckField(validator.NotBlank(form.Password), "password", "This field cannot be blank")
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "login.html", data)
+		return
+	}
+
+	// Refuse attempts while throttled without checking the password, so
+	// guesses made then can't succeed
+	wait, err := app.loginWait(r, form.Email)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if wait > 0 {
+		form.AddNonFieldError("Too many failed login attempts. Please try again in " + waitText(wait) + ".")
+		setRetryAfter(w, wait)
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusTooManyRequests, "login.html", data)
+		return
+	}
+
+	id, err := app.users.Authenticate(form.Email, form.Password)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidCredentials) {
+			err = app.loginFailed(r, form.Email)
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+			form.AddNonFieldError("Email or password is incorrect")
+			data := app.newTemplateData(r)
+			data.Form = form
+			app.render(w, r, http.StatusUnprocessableEntity, "login.html", data)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	// Users with two-factor authentication on still need to enter a code
+	secret, _, err := app.users.TwoFactorSecret(id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if secret != nil {
+		err = app.startTwoFactorLogin(r, id)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		http.Redirect(w, r, "/user/login/2fa", http.StatusSeeOther)
+		return
+	}
+
+	err = app.loginSucceeded(r, form.Email)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.logIn(r, id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, "/snippet/create", http.StatusSeeOther)
+}
+
+// userLoginTwoFactor asks a user who has entered their password for their
+// two-factor code
+func (app *application) userLoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if app.pendingTwoFactorUserID(r) == 0 {
+		app.sessionManager.Put(r.Context(), "flash", "Please log in again.")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Form = twoFactorForm{}
+	app.render(w, r, http.StatusOK, "login-2fa.html", data)
+}
+
+// userLoginTwoFactorPost checks a two-factor code and finishes logging the
+// user in. After too many wrong codes they have to start again with their
+// password.
+func (app *application) userLoginTwoFactorPost(w http.ResponseWriter, r *http.Request) {
+	id := app.pendingTwoFactorUserID(r)
+	if id == 0 {
+		app.endTwoFactorLogin(r)
+		app.sessionManager.Put(r.Context(), "flash", "Please log in again.")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	var form twoFactorForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Code), "code", "This field cannot be blank")
+
+	user, err := app.users.Get(id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// Codes are throttled along with passwords
+	wait, err := app.loginWait(r, user.Email)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if wait > 0 {
+		form.AddNonFieldError("Too many failed login attempts. Please try again in " + waitText(wait) + ".")
+		setRetryAfter(w, wait)
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusTooManyRequests, "login-2fa.html", data)
+		return
+	}
+
+	var recovery bool
+	if form.Valid() {
+		attempts := app.sessionManager.GetInt(r.Context(), "twoFactorAttempts") + 1
+		app.sessionManager.Put(r.Context(), "twoFactorAttempts", attempts)
+
+		var ok bool
+		ok, recovery, err = app.checkTwoFactor(id, form.Code)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		// Wrong codes count as failed logins too, so they can't be guessed
+		// by entering the password again every few tries
+		if !ok {
+			err = app.loginFailed(r, user.Email)
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+		}
+
+		if !ok && attempts >= maxTwoFactorAttempts {
+			app.endTwoFactorLogin(r)
+			app.sessionManager.Put(r.Context(), "flash", "Too many incorrect codes. Please log in again.")
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+			return
+		}
+		if !ok {
+			form.AddFieldError("code", "Code is incorrect")
+		}
+	}
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "login-2fa.html", data)
+		return
+	}
+
+	app.endTwoFactorLogin(r)
+	err = app.loginSucceeded(r, user.Email)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.logIn(r, id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	if recovery {
+		left, err := app.users.RecoveryCodesLeft(id)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("You used a recovery code. You have %d left.", left))
+	}
+
+	http.Redirect(w, r, "/snippet/create", http.StatusSeeOther)
+}
+
+// userPasswordReset displays the form for requesting a password reset link
+func (app *application) userPasswordReset(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = passwordResetRequestForm{}
+	app.render(w, r, http.StatusOK, "password-reset.html", data)
+}
+
+// userPasswordResetPost emails a password reset link to the address entered,
+// if it belongs to a user. The response is the same either way so the form
+// can't be used to find out who has an account.
+func (app *application) userPasswordResetPost(w http.ResponseWriter, r *http.Request) {
+	var form passwordResetRequestForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Email), "email", "This field cannot be blank")
+	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email", "This field must be a valid email address")
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "password-reset.html", data)
+		return
+	}
+
+	token, name, err := app.users.CreatePasswordReset(form.Email)
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		app.serverError(w, r, err)
+		return
+	}
+	if err == nil {
+		link := app.baseURL + "/user/password/reset/confirm?token=" + url.QueryEscape(token)
+		app.sendMail(passwordResetEmail(form.Email, name, link))
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "If an account uses that address, a link to reset its password is on its way.")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// userPasswordResetConfirm displays the form for choosing a new password
+// from a reset link
+func (app *application) userPasswordResetConfirm(w http.ResponseWriter, r *http.Request) {
+	form := passwordResetForm{Token: r.URL.Query().Get("token")}
+
+	valid, err := app.users.ValidPasswordReset(form.Token)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if !valid {
+		form.AddNonFieldError("This link is invalid or has expired. Please request a new one.")
+	}
+
+	data := app.newTemplateData(r)
+	data.Form = form
+	app.render(w, r, http.StatusOK, "password-reset-confirm.html", data)
+}
+
+// userPasswordResetConfirmPost sets the new password chosen from a reset
+// link
+func (app *application) userPasswordResetConfirmPost(w http.ResponseWriter, r *http.Request) {
+	var form passwordResetForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.MinChars(form.NewPassword, 8), "new_password", "This must be 8 characters long")
+	form.CheckField(form.NewPassword == form.ConfirmPassword, "confirm_password", "Passwords do not match")
+
+	if form.Valid() {
+		err = app.users.ResetPassword(form.Token, form.NewPassword)
+		if errors.Is(err, models.ErrNoRecord) {
+			form.AddNonFieldError("This link is invalid or has expired. Please request a new one.")
+		} else if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "password-reset-confirm.html", data)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Your password has been reset and your personal access tokens revoked. Please log in.")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// userVerify verifies the email address a signed verification link was
+// sent to
+func (app *application) userVerify(w http.ResponseWriter, r *http.Request) {
+	id, email, err := app.parseVerificationToken(r.URL.Query().Get("token"))
+	if err == nil {
+		err = app.users.VerifyEmail(id, email)
+	}
+
+	switch {
+	case err == nil:
+		app.sessionManager.Put(r.Context(), "flash", "Your email address has been verified.")
+	case errors.Is(err, signer.ErrInvalid), errors.Is(err, signer.ErrExpired), errors.Is(err, models.ErrNoRecord):
+		app.sessionManager.Put(r.Context(), "flash", "This verification link is invalid or has expired.")
+	default:
+		app.serverError(w, r, err)
+		return
+	}
+
+	if app.isAuthenticated(r) {
+		http.Redirect(w, r, "/account", http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// userVerifyResendPost sends the current user a new verification link
+func (app *application) userVerifyResendPost(w http.ResponseWriter, r *http.Request) {
+	user, err := app.users.Get(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	if user.EmailVerified {
+		app.sessionManager.Put(r.Context(), "flash", "Your email address is already verified.")
+	} else {
+		app.sendVerificationEmail(user)
+		app.sessionManager.Put(r.Context(), "flash", "A new verification link is on its way to "+user.Email+".")
+	}
+
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
+
+func (app *application) userLogoutPost(w http.ResponseWriter, r *http.Request) {
+	err := app.logOut(r)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "You've been logged out successfully")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// account shows the current user's account settings
+func (app *application) account(w http.ResponseWriter, r *http.Request) {
+	userID := app.authenticatedUserID(r)
+	user, err := app.users.Get(userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	pendingEmail, err := app.users.PendingEmail(userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.User = user
+	data.PendingEmail = pendingEmail
+	app.render(w, r, http.StatusOK, "account.html", data)
+}
+
+// accountConfirm asks the current user for their password before a
+// sensitive change
+func (app *application) accountConfirm(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = accountConfirmForm{}
+	app.render(w, r, http.StatusOK, "confirm.html", data)
+}
+
+// accountConfirmPost checks the current user's password and sends them on to
+// the page that asked for it. Wrong passwords count as failed logins, so this
+// can't be used to get around the login throttle.
+func (app *application) accountConfirmPost(w http.ResponseWriter, r *http.Request) {
+	var form accountConfirmForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Password), "password", "This field cannot be blank")
+
+	user, err := app.users.Get(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	wait, err := app.loginWait(r, user.Email)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if wait > 0 {
+		form.AddFieldError("password", "Too many failed attempts. Please try again in "+waitText(wait)+".")
+		setRetryAfter(w, wait)
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusTooManyRequests, "confirm.html", data)
+		return
+	}
+
+	if form.Valid() {
+		err = app.users.CheckPassword(user.ID, form.Password)
+		if errors.Is(err, models.ErrInvalidCredentials) {
+			err = app.loginFailed(r, user.Email)
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+			form.AddFieldError("password", "Password is incorrect")
+		} else if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "confirm.html", data)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "reauthenticatedAt", time.Now().Unix())
+
+	// Only ever redirect to a path requireReauthentication stored, never to
+	// anything taken from the request
+	redirect := app.sessionManager.PopString(r.Context(), "reauthenticateRedirect")
+	if redirect == "" {
+		redirect = "/account"
+	}
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+}
+
+// accountPassword displays the form for changing the current user's password
+func (app *application) accountPassword(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = passwordChangeForm{}
+	app.render(w, r, http.StatusOK, "password.html", data)
+}
+
+// accountPasswordPost changes the current user's password and logs out all
+// of their other sessions. Wrong current passwords count as failed logins.
+func (app *application) accountPasswordPost(w http.ResponseWriter, r *http.Request) {
+	var form passwordChangeForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.CurrentPassword), "current_password", "This field cannot be blank")
+	form.CheckField(validator.MinChars(form.NewPassword, 8), "new_password", "This must be 8 characters long")
+	form.CheckField(form.NewPassword == form.ConfirmPassword, "confirm_password", "Passwords do not match")
+
+	user, err := app.users.Get(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	wait, err := app.loginWait(r, user.Email)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if wait > 0 {
+		form.AddFieldError("current_password", "Too many failed attempts. Please try again in "+waitText(wait)+".")
+		setRetryAfter(w, wait)
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusTooManyRequests, "password.html", data)
+		return
+	}
+
+	if form.Valid() {
+		err = app.users.ChangePassword(user.ID, form.CurrentPassword, form.NewPassword)
+		if errors.Is(err, models.ErrInvalidCredentials) {
+			err = app.loginFailed(r, user.Email)
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+			form.AddFieldError("current_password", "Current password is incorrect")
+		} else if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "password.html", data)
+		return
+	}
+
+	// The session version has changed, which logs out every session; log
+	// this one back in with the new version
+	err = app.logIn(r, app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Your password has been changed. Any other sessions have been logged out and your personal access tokens revoked.")
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
+
+// accountEmail displays the form for changing the current user's email
+func (app *application) accountEmail(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = emailChangeForm{}
+	app.render(w, r, http.StatusOK, "email.html", data)
+}
+
+// accountEmailPost starts changing the current user's email address. The
+// change only happens once the link sent to the new address is followed.
+func (app *application) accountEmailPost(w http.ResponseWriter, r *http.Request) {
+	var form emailChangeForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Email), "email", "This field cannot be blank")
+	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email", "This field must be a valid email address")
+
+	var token string
+	if form.Valid() {
+		token, err = app.users.RequestEmailChange(app.authenticatedUserID(r), form.Email)
+		if errors.Is(err, models.ErrDuplicateEmail) {
+			form.AddFieldError("email", "Email Address is already in use")
+		} else if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "email.html", data)
+		return
+	}
+
+	user, err := app.users.Get(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	link := app.baseURL + "/account/email/confirm?token=" + url.QueryEscape(token)
+	app.sendMail(emailChangeEmail(form.Email, user.Name, link))
+	app.sendMail(emailChangeNotice(user.Email, user.Name, form.Email))
+
+	app.sessionManager.Put(r.Context(), "flash", "Follow the link sent to your new address to confirm it.")
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
+
+// accountEmailConfirm asks to confirm an email change from the link sent to
+// the new address. Applying the change takes a POST so link scanners that
+// just GET the page can't trigger it.
+func (app *application) accountEmailConfirm(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = emailConfirmForm{Token: r.URL.Query().Get("token")}
+	app.render(w, r, http.StatusOK, "email-confirm.html", data)
+}
+
+// accountEmailConfirmPost applies the email change a confirmation link was
+// sent for
+func (app *application) accountEmailConfirmPost(w http.ResponseWriter, r *http.Request) {
+	var form emailConfirmForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	_, err = app.users.ConfirmEmailChange(form.Token)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrNoRecord):
+			form.AddNonFieldError("This link is invalid or has expired. Please request a new one.")
+		case errors.Is(err, models.ErrDuplicateEmail):
+			form.AddNonFieldError("This email address is now in use by another account.")
+		default:
+			app.serverError(w, r, err)
+			return
+		}
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "email-confirm.html", data)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Your email address has been changed.")
+
+	if app.isAuthenticated(r) {
+		http.Redirect(w, r, "/account", http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// accountDelete displays the form for deleting the current user's account
+func (app *application) accountDelete(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = accountDeleteForm{Snippets: "delete"}
+	app.render(w, r, http.StatusOK, "delete-account.html", data)
+}
+
+// accountDeletePost deletes the current user's account, keeping or deleting
+// their snippets as they chose, and logs them out
+func (app *application) accountDeletePost(w http.ResponseWriter, r *http.Request) {
+	var form accountDeleteForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.PermittedValue(form.Snippets, "keep", "delete"), "snippets", "Please choose what to do with your snippets")
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "delete-account.html", data)
+		return
+	}
+
+	err = app.users.Delete(app.authenticatedUserID(r), form.Snippets == "keep")
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.logOut(r)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Your account has been deleted.")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// accountTwoFactor shows whether two-factor authentication is on for the
+// current user. If it isn't, it shows a new secret to set it up with.
+func (app *application) accountTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user, err := app.users.Get(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.renderTwoFactor(w, r, http.StatusOK, user, twoFactorForm{})
+}
+
+// renderTwoFactor renders the two-factor settings page for a user
+func (app *application) renderTwoFactor(w http.ResponseWriter, r *http.Request, status int, user models.User, form twoFactorForm) {
+	data := app.newTemplateData(r)
+	data.User = user
+	data.Form = form
+
+	if user.TwoFactor {
+		left, err := app.users.RecoveryCodesLeft(user.ID)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		data.RecoveryCodesLeft = left
+	} else {
+		secret, err := app.pendingTOTPSecret(r)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		data.TwoFactorKey = totp.EncodeSecret(secret)
+	}
+
+	app.render(w, r, status, "two-factor.html", data)
+}
+
+// accountTwoFactorPost turns on two-factor authentication once the user has
+// shown their authenticator app has the secret by entering a code from it.
+// Their recovery codes are shown this once.
+func (app *application) accountTwoFactorPost(w http.ResponseWriter, r *http.Request) {
+	userID := app.authenticatedUserID(r)
+	user, err := app.users.Get(userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	if user.TwoFactor {
+		http.Redirect(w, r, "/account/2fa", http.StatusSeeOther)
+		return
+	}
+
+	var form twoFactorForm
+	err = app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	secret, err := app.pendingTOTPSecret(r)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Code), "code", "This field cannot be blank")
+	step, ok := totp.Validate(secret, strings.ReplaceAll(strings.TrimSpace(form.Code), " ", ""), time.Now())
+	if form.Valid() {
+		form.CheckField(ok, "code", "Code is incorrect. Check the time on your device is correct.")
+	}
+
+	if !form.Valid() {
+		app.renderTwoFactor(w, r, http.StatusUnprocessableEntity, user, form)
+		return
+	}
+
+	encrypted, err := app.secrets.Seal(secret)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	codes, err := app.users.EnableTwoFactor(userID, encrypted, step)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.sessionManager.Remove(r.Context(), "totpPendingSecret")
+
+	// Don't let the recovery codes be cached anywhere
+	w.Header().Set("Cache-Control", "no-store")
+
+	data := app.newTemplateData(r)
+	data.RecoveryCodes = codes
+	app.render(w, r, http.StatusOK, "recovery-codes.html", data)
+}
+
+// accountTwoFactorQR serves the QR code for the secret the current user is
+// setting up two-factor authentication with, as a PNG or, with
+// ?format=svg, an SVG
+func (app *application) accountTwoFactorQR(w http.ResponseWriter, r *http.Request) {
+	// Only the setup page creates a pending secret, and it needs the
+	// password to have been entered recently
+	if app.sessionManager.GetBytes(r.Context(), "totpPendingSecret") == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	user, err := app.users.Get(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	secret, err := app.pendingTOTPSecret(r)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// Label the account with the handle rather than the email, which can be
+	// too long to fit in a QR code along with the secret
+	code, err := qrcode.Encode(totp.URI("Snippetbox", user.Handle, secret))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; sandbox")
+	w.Header().Set("Cache-Control", "no-store")
+
+	if r.URL.Query().Get("format") == "svg" {
+		w.Header().Set("Content-Type", "image/svg+xml")
+		w.Write([]byte(code.SVG()))
+		return
+	}
+
+	png, err := code.PNG(6)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(png)
+}
+
+// accountTwoFactorDisablePost turns off two-factor authentication for the
+// current user, which needs a current code or a recovery code. Wrong codes
+// count as failed logins, so a stolen session can't be used to guess one.
+func (app *application) accountTwoFactorDisablePost(w http.ResponseWriter, r *http.Request) {
+	userID := app.authenticatedUserID(r)
+	user, err := app.users.Get(userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	if !user.TwoFactor {
+		http.Redirect(w, r, "/account", http.StatusSeeOther)
+		return
+	}
+
+	var form twoFactorForm
+	err = app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Code), "code", "This field cannot be blank")
+
+	wait, err := app.loginWait(r, user.Email)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if wait > 0 {
+		form.AddFieldError("code", "Too many failed attempts. Please try again in "+waitText(wait)+".")
+		setRetryAfter(w, wait)
+		app.renderTwoFactor(w, r, http.StatusTooManyRequests, user, form)
+		return
+	}
+
+	if form.Valid() {
+		ok, _, err := app.checkTwoFactor(userID, form.Code)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		if !ok {
+			err = app.loginFailed(r, user.Email)
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+		}
+		form.CheckField(ok, "code", "Code is incorrect")
+	}
+
+	if !form.Valid() {
+		app.renderTwoFactor(w, r, http.StatusUnprocessableEntity, user, form)
+		return
+	}
+
+	err = app.users.DisableTwoFactor(userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Two-factor authentication has been turned off.")
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
+
+// accountTokens lists the current user's personal access tokens, with a form
+// to create another
+func (app *application) accountTokens(w http.ResponseWriter, r *http.Request) {
+	app.renderTokens(w, r, http.StatusOK, apiTokenForm{Scope: models.ScopeRead}, "")
+}
+
+// renderTokens renders the personal access tokens page, showing newToken if
+// one was just created
+func (app *application) renderTokens(w http.ResponseWriter, r *http.Request, status int, form apiTokenForm, newToken string) {
+	tokens, err := app.users.APITokens(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.APITokens = tokens
+	data.NewAPIToken = newToken
+	data.Form = form
+	app.render(w, r, status, "tokens.html", data)
+}
+
+// accountTokensPost creates a personal access token for the current user.
+// The token is shown this once; only its hash is kept.
+func (app *application) accountTokensPost(w http.ResponseWriter, r *http.Request) {
+	var form apiTokenForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Name), "name", "This field cannot be blank")
+	form.CheckField(validator.MaxChars(form.Name, 100), "name", "Cannot be more than 100 characters long")
+	form.CheckField(validator.PermittedValue(form.Scope, models.ScopeRead, models.ScopeWrite), "scope", "Please choose read or write")
+
+	userID := app.authenticatedUserID(r)
+	if form.Valid() {
+		n, err := app.users.APITokenCount(userID)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		if n >= models.MaxAPITokens {
+			form.AddNonFieldError(fmt.Sprintf("You can't have more than %d tokens. Revoke one you no longer use first.", models.MaxAPITokens))
+		}
+	}
+
+	if !form.Valid() {
+		app.renderTokens(w, r, http.StatusUnprocessableEntity, form, "")
+		return
+	}
+
+	token, err := app.users.CreateAPIToken(userID, strings.TrimSpace(form.Name), form.Scope)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.renderTokens(w, r, http.StatusOK, apiTokenForm{Scope: models.ScopeRead}, token)
+}
+
+// accountTokenRevokePost revokes one of the current user's personal access
+// tokens
+func (app *application) accountTokenRevokePost(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		http.NotFound(w, r)
+		return
+	}
+
+	err = app.users.RevokeAPIToken(app.authenticatedUserID(r), id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "The token has been revoked.")
+	http.Redirect(w, r, "/account/tokens", http.StatusSeeOther)
 }
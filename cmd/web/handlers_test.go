@@ -0,0 +1,293 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shaheerkj/snippetbox/internal/models"
+	"github.com/shaheerkj/snippetbox/internal/totp"
+)
+
+var resetLinkRX = regexp.MustCompile(`https://snippetbox\.test(/user/password/reset/confirm\?token=\S+)`)
+
+func TestPasswordResetFlow(t *testing.T) {
+	db := newTestDB(t)
+	app := newTestApplication(t, db)
+	ts := newTestServer(t, app.routes())
+
+	newTestUser(t, app, "alice", "alice@example.com", "old password")
+
+	// Unknown addresses get the same response, and no email
+	res, _ := ts.postForm(t, "/user/password/reset", url.Values{"email": {"nobody@example.com"}})
+	if res.StatusCode != http.StatusSeeOther {
+		t.Fatalf("got status %d for an unknown address; want %d", res.StatusCode, http.StatusSeeOther)
+	}
+
+	res, _ = ts.postForm(t, "/user/password/reset", url.Values{"email": {"alice@example.com"}})
+	if res.StatusCode != http.StatusSeeOther {
+		t.Fatalf("got status %d; want %d", res.StatusCode, http.StatusSeeOther)
+	}
+
+	messages := outboxMessages(t, app, 1)
+	if len(messages) != 1 {
+		t.Fatalf("got %d emails; want 1", len(messages))
+	}
+	msg := messages[0]
+	if to := msg.Header.Get("To"); to != "alice@example.com" {
+		t.Errorf("email sent to %q; want alice@example.com", to)
+	}
+	if from := msg.Header.Get("From"); from != `"Snippetbox" <no-reply@example.com>` {
+		t.Errorf("email sent from %q", from)
+	}
+	body, err := io.ReadAll(msg.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := resetLinkRX.FindStringSubmatch(string(body))
+	if m == nil {
+		t.Fatalf("no reset link in the email:\n%s", body)
+	}
+	link := m[1]
+	token, err := url.QueryUnescape(strings.TrimPrefix(link, "/user/password/reset/confirm?token="))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, page := ts.get(t, link)
+	if res.StatusCode != http.StatusOK || strings.Contains(page, "invalid or has expired") {
+		t.Fatalf("reset link rejected: status %d", res.StatusCode)
+	}
+
+	form := url.Values{"token": {token}, "new_password": {"new password"}, "confirm_password": {"new password"}}
+	res, _ = ts.postForm(t, link, form)
+	if res.StatusCode != http.StatusSeeOther {
+		t.Fatalf("got status %d setting the new password; want %d", res.StatusCode, http.StatusSeeOther)
+	}
+
+	if _, err := app.users.Authenticate("alice@example.com", "new password"); err != nil {
+		t.Errorf("can't log in with the new password: %v", err)
+	}
+	if _, err := app.users.Authenticate("alice@example.com", "old password"); err == nil {
+		t.Error("can still log in with the old password")
+	}
+
+	// Links are single-use
+	form.Set("new_password", "another password")
+	form.Set("confirm_password", "another password")
+	res, _ = ts.postForm(t, link, form)
+	if res.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("got status %d reusing the link; want %d", res.StatusCode, http.StatusUnprocessableEntity)
+	}
+}
+
+func TestTwoFactorDisableIsThrottled(t *testing.T) {
+	db := newTestDB(t)
+	app := newTestApplication(t, db)
+	ts := newTestServer(t, app.routes())
+
+	id := newTestUser(t, app, "alice", "alice@example.com", "password123")
+	secret, err := totp.NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := app.secrets.Seal(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.users.EnableTwoFactor(id, encrypted, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	ts.logIn(t, "alice@example.com", "password123", secret)
+
+	// Turning two-factor authentication off needs the password again, and
+	// then goes back to the page the form is on
+	token := ts.csrfToken(t, "/account")
+	res, _ := ts.post(t, "/account/2fa/disable", url.Values{"csrf_token": {token}, "code": {"wrong"}})
+	if loc := res.Header.Get("Location"); res.StatusCode != http.StatusSeeOther || loc != "/account/confirm" {
+		t.Fatalf("got status %d to %q; want a redirect to /account/confirm", res.StatusCode, loc)
+	}
+	res, _ = ts.postForm(t, "/account/confirm", url.Values{"password": {"password123"}})
+	if loc := res.Header.Get("Location"); loc != "/account/2fa" {
+		t.Fatalf("redirected to %q after confirming; want /account/2fa", loc)
+	}
+
+	throttled := false
+	for i := 0; i < loginByEmailPolicy.LockoutAfter && !throttled; i++ {
+		token := ts.csrfToken(t, "/account/2fa")
+		res, _ := ts.post(t, "/account/2fa/disable", url.Values{"csrf_token": {token}, "code": {"wrong"}})
+		switch res.StatusCode {
+		case http.StatusUnprocessableEntity:
+		case http.StatusTooManyRequests:
+			throttled = true
+			if res.Header.Get("Retry-After") == "" {
+				t.Error("no Retry-After header")
+			}
+		default:
+			t.Fatalf("got status %d for a wrong code", res.StatusCode)
+		}
+	}
+	if !throttled {
+		t.Fatal("wrong codes were never throttled")
+	}
+
+	// The attempts count against logging in too
+	wait, err := app.loginByEmail.Wait("email:alice@example.com", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wait <= 0 {
+		t.Error("logging in isn't throttled after wrong codes")
+	}
+
+	user, err := app.users.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !user.TwoFactor {
+		t.Error("two-factor authentication was turned off")
+	}
+}
+
+func TestAccountConfirmIsThrottled(t *testing.T) {
+	db := newTestDB(t)
+	app := newTestApplication(t, db)
+	ts := newTestServer(t, app.routes())
+
+	newTestUser(t, app, "alice", "alice@example.com", "password123")
+	ts.logIn(t, "alice@example.com", "password123", nil)
+
+	throttled := false
+	for i := 0; i < loginByEmailPolicy.LockoutAfter && !throttled; i++ {
+		res, _ := ts.postForm(t, "/account/confirm", url.Values{"password": {"wrong password"}})
+		switch res.StatusCode {
+		case http.StatusUnprocessableEntity:
+		case http.StatusTooManyRequests:
+			throttled = true
+		default:
+			t.Fatalf("got status %d for a wrong password", res.StatusCode)
+		}
+	}
+	if !throttled {
+		t.Fatal("wrong passwords were never throttled")
+	}
+
+	// While throttled even the right password is refused, here and when
+	// logging in
+	res, _ := ts.postForm(t, "/account/confirm", url.Values{"password": {"password123"}})
+	if res.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("got status %d for the right password while throttled; want %d", res.StatusCode, http.StatusTooManyRequests)
+	}
+
+	other := newTestServer(t, app.routes())
+	res, _ = other.postForm(t, "/user/login", url.Values{"email": {"alice@example.com"}, "password": {"password123"}})
+	if res.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("got status %d logging in while throttled; want %d", res.StatusCode, http.StatusTooManyRequests)
+	}
+}
+
+func TestHiddenSnippetsStayHidden(t *testing.T) {
+	db := newTestDB(t)
+	app := newTestApplication(t, db)
+
+	alice := newTestUser(t, app, "alice", "alice@example.com", "password123")
+	newTestUser(t, app, "bob", "bob@example.com", "password123")
+
+	slugs := map[string]string{}
+	for _, visibility := range []string{models.VisibilityPublic, models.VisibilityUnlisted, models.VisibilityPrivate} {
+		files := []models.File{{Name: "zebracorn.txt", Content: "zebracorn " + visibility}}
+		slug, err := app.snippets.Insert(alice, "Zebracorn "+visibility, files, []string{"zebracorn"}, time.Time{}, visibility, false, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		slugs[visibility] = slug
+	}
+
+	anonymous := newTestServer(t, app.routes())
+	bob := newTestServer(t, app.routes())
+	bob.logIn(t, "bob@example.com", "password123", nil)
+	author := newTestServer(t, app.routes())
+	author.logIn(t, "alice@example.com", "password123", nil)
+
+	viewers := []struct {
+		name string
+		ts   *testServer
+	}{
+		{"Anonymous", anonymous},
+		{"Another user", bob},
+	}
+
+	// Lists only ever show public snippets, even to their author
+	lists := []string{
+		"/",
+		"/snippets",
+		"/tags/zebracorn",
+		"/u/alice",
+		"/search?q=zebracorn",
+		"/api/v1/snippets",
+		"/api/v1/users/alice/snippets",
+	}
+	for _, v := range viewers {
+		for _, path := range lists {
+			t.Run(v.name+" "+path, func(t *testing.T) {
+				res, body := v.ts.get(t, path)
+				if res.StatusCode != http.StatusOK {
+					t.Fatalf("got status %d; want %d", res.StatusCode, http.StatusOK)
+				}
+				if !strings.Contains(body, slugs[models.VisibilityPublic]) {
+					t.Error("public snippet isn't listed")
+				}
+				for _, visibility := range []string{models.VisibilityUnlisted, models.VisibilityPrivate} {
+					if strings.Contains(body, slugs[visibility]) || strings.Contains(strings.ToLower(body), "zebracorn "+visibility) {
+						t.Errorf("%s snippet is listed", visibility)
+					}
+				}
+			})
+		}
+	}
+
+	// A private snippet can't be reached by its link either, except by its
+	// author
+	links := []string{
+		"/snippet/view/%s",
+		"/s/%s",
+		"/snippet/view/%s/history",
+		"/snippet/raw/%s",
+		"/snippet/download/%s",
+		"/snippet/view/%s/zip",
+		"/api/v1/snippets/%s",
+	}
+	for _, link := range links {
+		for _, v := range viewers {
+			t.Run(v.name+" "+link, func(t *testing.T) {
+				path := fmt.Sprintf(link, slugs[models.VisibilityPrivate])
+				res, body := v.ts.get(t, path)
+				if res.StatusCode != http.StatusNotFound {
+					t.Errorf("got status %d; want %d", res.StatusCode, http.StatusNotFound)
+				}
+				if strings.Contains(strings.ToLower(body), "zebracorn private") {
+					t.Error("private title or content in the response")
+				}
+
+				// Unlisted snippets are for anyone with the link
+				path = fmt.Sprintf(link, slugs[models.VisibilityUnlisted])
+				if res, _ := v.ts.get(t, path); res.StatusCode != http.StatusOK {
+					t.Errorf("got status %d for an unlisted snippet; want %d", res.StatusCode, http.StatusOK)
+				}
+			})
+		}
+
+		t.Run("Author "+link, func(t *testing.T) {
+			path := fmt.Sprintf(link, slugs[models.VisibilityPrivate])
+			if res, _ := author.get(t, path); res.StatusCode != http.StatusOK {
+				t.Errorf("got status %d; want %d", res.StatusCode, http.StatusOK)
+			}
+		})
+	}
+}
@@ -4,11 +4,33 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"image"
+	_ "image/gif"  // Register the GIF format for avatarImage
+	_ "image/jpeg" // Register the JPEG format for avatarImage
+	_ "image/png"  // Register the PNG format for avatarImage
+	"io"
+	"math"
 	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/go-playground/form/v4"
 	"github.com/justinas/nosurf"
+	"github.com/shaheerkj/snippetbox/internal/diff"
+	"github.com/shaheerkj/snippetbox/internal/highlight"
+	"github.com/shaheerkj/snippetbox/internal/mailer"
+	"github.com/shaheerkj/snippetbox/internal/models"
+	"github.com/shaheerkj/snippetbox/internal/secretbox"
+	"github.com/shaheerkj/snippetbox/internal/signer"
+	"github.com/shaheerkj/snippetbox/internal/throttle"
+	"github.com/shaheerkj/snippetbox/internal/totp"
+	"github.com/shaheerkj/snippetbox/internal/validator"
 )
 
 // newTemplateData creates a templateData struct populated with common data
@@ -16,10 +38,11 @@ import (
 // Takes *http.Request as parameter for future expansion (sessions, auth, etc.)
 func (app *application) newTemplateData(r *http.Request) templateData {
 	return templateData{
-		CurrentYear:     time.Now().Year(), // Used in footer copyright
-		Flash:           app.sessionManager.PopString(r.Context(), "flash"),
-		IsAuthenticated: app.isAuthenticated(r),
-		CSRFToken:       nosurf.Token(r),
+		CurrentYear:         time.Now().Year(), // Used in footer copyright
+		Flash:               app.sessionManager.PopString(r.Context(), "flash"),
+		IsAuthenticated:     app.isAuthenticated(r),
+		AuthenticatedUserID: app.authenticatedUserID(r),
+		CSRFToken:           nosurf.Token(r),
 	}
 }
 
@@ -34,7 +57,7 @@ func (app *application) serverError(w http.ResponseWriter, r *http.Request, err
 	app.logger.Error(err.Error(), "method", method, "uri", uri)
 
 	// Send generic 500 error response to user (don't leak error details)
-	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	statusError(w, r, http.StatusInternalServerError)
 }
 
 // clientError sends a specific HTTP status code and error message to the user
@@ -90,6 +113,789 @@ func (app *application) decodePostForm(r *http.Request, dst any) error {
 	return err
 }
 
+// isAuthenticated reports whether the authenticate middleware found a valid
+// logged in session for the request
 func (app *application) isAuthenticated(r *http.Request) bool {
-	return app.sessionManager.Exists(r.Context(), "authenticatedUserID")
+	isAuthenticated, ok := r.Context().Value(isAuthenticatedContextKey).(bool)
+	if !ok {
+		return false
+	}
+	return isAuthenticated
+}
+
+// authenticatedUserID returns the ID of the logged in user, or 0 if the
+// request isn't authenticated
+func (app *application) authenticatedUserID(r *http.Request) int {
+	if !app.isAuthenticated(r) {
+		return 0
+	}
+	if id, ok := r.Context().Value(tokenUserIDContextKey).(int); ok {
+		return id
+	}
+	return app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+}
+
+// tokenScope returns the scope of the personal access token a request was
+// made with, or "" if it wasn't made with one
+func tokenScope(r *http.Request) string {
+	scope, _ := r.Context().Value(tokenScopeContextKey).(string)
+	return scope
+}
+
+// logIn starts an authenticated session for a user. The session token is
+// renewed first to prevent session fixation.
+func (app *application) logIn(r *http.Request, id int) error {
+	version, err := app.users.SessionVersion(id)
+	if err != nil {
+		return err
+	}
+
+	err = app.sessionManager.RenewToken(r.Context())
+	if err != nil {
+		return err
+	}
+
+	app.sessionManager.Put(r.Context(), "authenticatedUserID", id)
+	app.sessionManager.Put(r.Context(), "sessionVersion", version)
+	return nil
+}
+
+// logOut ends the authenticated part of a session, renewing its token
+func (app *application) logOut(r *http.Request) error {
+	err := app.sessionManager.RenewToken(r.Context())
+	if err != nil {
+		return err
+	}
+
+	app.sessionManager.Remove(r.Context(), "authenticatedUserID")
+	app.sessionManager.Remove(r.Context(), "sessionVersion")
+	app.sessionManager.Remove(r.Context(), "reauthenticatedAt")
+	return nil
+}
+
+// reauthenticationWindow is how long after confirming their password a user
+// can make sensitive changes to their account without confirming it again
+const reauthenticationWindow = 10 * time.Minute
+
+// recentlyReauthenticated reports whether the user confirmed their password
+// within the reauthentication window
+func (app *application) recentlyReauthenticated(r *http.Request) bool {
+	at := time.Unix(app.sessionManager.GetInt64(r.Context(), "reauthenticatedAt"), 0)
+	return time.Since(at) < reauthenticationWindow
+}
+
+var (
+	// loginByEmailPolicy slows down guessing the password of one account.
+	// After 3 free failures the wait doubles from a second up to a minute,
+	// and the 10th failure locks the account out for 15 minutes.
+	loginByEmailPolicy = throttle.Policy{
+		FreeFailures: 3,
+		BaseDelay:    time.Second,
+		MaxDelay:     time.Minute,
+		LockoutAfter: 10,
+		Lockout:      15 * time.Minute,
+		Window:       time.Hour,
+	}
+	// loginByIPPolicy slows down one client trying many accounts. It's more
+	// lenient since many people can share an address. Both policies forget
+	// failures after the same time, as they can share a store.
+	loginByIPPolicy = throttle.Policy{
+		FreeFailures: 10,
+		BaseDelay:    time.Second,
+		MaxDelay:     time.Minute,
+		LockoutAfter: 100,
+		Lockout:      15 * time.Minute,
+		Window:       time.Hour,
+	}
+)
+
+// clientIP returns the address a request came from. IPv6 clients usually
+// have a whole /64 to themselves, so they're identified by that instead.
+func clientIP(r *http.Request) string {
+	addr, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	ip := addr.Addr().Unmap()
+	if ip.Is6() {
+		prefix, err := ip.Prefix(64)
+		if err == nil {
+			return prefix.String()
+		}
+	}
+	return ip.String()
+}
+
+// loginKeys returns the keys failed logins are counted under: the email
+// being logged in with, whether or not it has an account, and the client's
+// address
+func loginKeys(r *http.Request, email string) (byEmail, byIP string) {
+	return "email:" + strings.ToLower(strings.TrimSpace(email)), "ip:" + clientIP(r)
+}
+
+// loginWait returns how long a client has to wait before it can try logging
+// in with an email again
+func (app *application) loginWait(r *http.Request, email string) (time.Duration, error) {
+	byEmail, byIP := loginKeys(r, email)
+	now := time.Now()
+
+	emailWait, err := app.loginByEmail.Wait(byEmail, now)
+	if err != nil {
+		return 0, err
+	}
+
+	ipWait, err := app.loginByIP.Wait(byIP, now)
+	if err != nil {
+		return 0, err
+	}
+
+	return max(emailWait, ipWait), nil
+}
+
+// loginFailed counts a failed login against both the email and the client's
+// address. When it locks the email out, the account's owner, if there is
+// one, is told by email.
+func (app *application) loginFailed(r *http.Request, email string) error {
+	byEmail, byIP := loginKeys(r, email)
+	now := time.Now()
+
+	_, err := app.loginByIP.Fail(byIP, now)
+	if err != nil {
+		return err
+	}
+
+	lockedOut, err := app.loginByEmail.Fail(byEmail, now)
+	if err != nil {
+		return err
+	}
+	if lockedOut {
+		app.notifyLockout(email, clientIP(r))
+	}
+	return nil
+}
+
+// loginSucceeded forgets the failed logins for an email. The client's
+// address keeps its count, so logging in to an account of their own doesn't
+// let anyone carry on guessing others.
+func (app *application) loginSucceeded(r *http.Request, email string) error {
+	byEmail, _ := loginKeys(r, email)
+	return app.loginByEmail.Reset(byEmail)
+}
+
+// notifyLockout emails the owner of the account with an email about it
+// being locked out. The account is looked up in the background so the
+// response doesn't take longer when it exists.
+func (app *application) notifyLockout(email, ip string) {
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				app.logger.Error(fmt.Sprintf("%s", err))
+			}
+		}()
+
+		user, err := app.users.GetByEmail(email)
+		if errors.Is(err, models.ErrNoRecord) {
+			return
+		} else if err != nil {
+			app.logger.Error(err.Error())
+			return
+		}
+
+		app.sendMail(lockoutEmail(user.Email, user.Name, ip, app.baseURL+"/user/password/reset"))
+	}()
+}
+
+// setRetryAfter tells the client how many seconds to wait before trying
+// again
+func setRetryAfter(w http.ResponseWriter, d time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(d.Seconds()))))
+}
+
+// waitText describes how long someone has to wait, rounding up to whole
+// seconds under a minute and whole minutes over
+func waitText(d time.Duration) string {
+	n, unit := int(math.Ceil(d.Seconds())), "second"
+	if d > time.Minute {
+		n, unit = int(math.Ceil(d.Minutes())), "minute"
+	}
+	if n != 1 {
+		unit += "s"
+	}
+	return fmt.Sprintf("%d %s", n, unit)
+}
+
+const (
+	// twoFactorLoginWindow is how long a user has to enter their code after
+	// entering their password
+	twoFactorLoginWindow = 5 * time.Minute
+	// maxTwoFactorAttempts is how many codes can be tried before the user
+	// has to start logging in again
+	maxTwoFactorAttempts = 5
+)
+
+// startTwoFactorLogin records that a user got their password right but still
+// needs to enter a code. They aren't logged in until they do.
+func (app *application) startTwoFactorLogin(r *http.Request, id int) error {
+	err := app.sessionManager.RenewToken(r.Context())
+	if err != nil {
+		return err
+	}
+
+	app.sessionManager.Put(r.Context(), "twoFactorUserID", id)
+	app.sessionManager.Put(r.Context(), "twoFactorStartedAt", time.Now().Unix())
+	app.sessionManager.Put(r.Context(), "twoFactorAttempts", 0)
+	return nil
+}
+
+// pendingTwoFactorUserID returns the ID of the user part way through logging
+// in, or 0 if there isn't one or they took too long
+func (app *application) pendingTwoFactorUserID(r *http.Request) int {
+	startedAt := time.Unix(app.sessionManager.GetInt64(r.Context(), "twoFactorStartedAt"), 0)
+	if time.Since(startedAt) >= twoFactorLoginWindow {
+		return 0
+	}
+	return app.sessionManager.GetInt(r.Context(), "twoFactorUserID")
+}
+
+// endTwoFactorLogin forgets a pending two-factor login
+func (app *application) endTwoFactorLogin(r *http.Request) {
+	app.sessionManager.Remove(r.Context(), "twoFactorUserID")
+	app.sessionManager.Remove(r.Context(), "twoFactorStartedAt")
+	app.sessionManager.Remove(r.Context(), "twoFactorAttempts")
+}
+
+// checkTwoFactor checks a code entered by a user with two-factor
+// authentication on. The code can come from their authenticator app or be
+// one of their recovery codes; either can only be used once. Reports whether
+// the code was accepted and whether it was a recovery code.
+func (app *application) checkTwoFactor(id int, code string) (ok, recovery bool, err error) {
+	code = strings.TrimSpace(code)
+
+	encrypted, lastStep, err := app.users.TwoFactorSecret(id)
+	if err != nil || encrypted == nil {
+		return false, false, err
+	}
+
+	secret, err := app.secrets.Open(encrypted)
+	switch {
+	case errors.Is(err, secretbox.ErrDecrypt):
+		// The secret key has changed since two-factor authentication was
+		// set up. Only recovery codes can get the user in now.
+		app.logger.Error("cannot decrypt two-factor secret", "user", id)
+	case err != nil:
+		return false, false, err
+	default:
+		step, valid := totp.Validate(secret, strings.ReplaceAll(code, " ", ""), time.Now())
+		if valid && step > lastStep {
+			ok, err = app.users.UseTOTPStep(id, step)
+			return ok, false, err
+		}
+		if valid {
+			return false, false, nil
+		}
+	}
+
+	ok, err = app.users.UseRecoveryCode(id, code)
+	return ok, ok, err
+}
+
+// pendingTOTPSecret returns the secret a user is setting up two-factor
+// authentication with, generating one the first time. It's kept encrypted in
+// the session so reloading the setup page doesn't invalidate a QR code
+// that's already been scanned.
+func (app *application) pendingTOTPSecret(r *http.Request) ([]byte, error) {
+	encrypted := app.sessionManager.GetBytes(r.Context(), "totpPendingSecret")
+	if encrypted != nil {
+		secret, err := app.secrets.Open(encrypted)
+		if err == nil {
+			return secret, nil
+		}
+	}
+
+	secret, err := totp.NewSecret()
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err = app.secrets.Seal(secret)
+	if err != nil {
+		return nil, err
+	}
+	app.sessionManager.Put(r.Context(), "totpPendingSecret", encrypted)
+	return secret, nil
+}
+
+// datetimeLocalLayout is the format used by <input type='datetime-local'>
+const datetimeLocalLayout = "2006-01-02T15:04"
+
+// dateLayout is the format used by <input type='date'>
+const dateLayout = "2006-01-02"
+
+// pageSizes are the page sizes listings can be shown with
+var pageSizes = []int{10, 20, 50, 100}
+
+// pageRequest reads which page of a listing to show from the size, before
+// and after query parameters. Sizes other than those in pageSizes fall back
+// to defaultSize.
+func pageRequest(r *http.Request, defaultSize int) (models.PageRequest, error) {
+	query := r.URL.Query()
+	pr := models.PageRequest{Size: defaultSize}
+
+	if size, err := strconv.Atoi(query.Get("size")); err == nil && validator.PermittedValue(size, pageSizes...) {
+		pr.Size = size
+	}
+
+	var err error
+	if v := query.Get("before"); v != "" {
+		pr.Before, err = models.ParseCursor(v)
+		if err != nil {
+			return models.PageRequest{}, err
+		}
+	}
+	if v := query.Get("after"); v != "" {
+		pr.After, err = models.ParseCursor(v)
+		if err != nil {
+			return models.PageRequest{}, err
+		}
+	}
+
+	return pr, nil
+}
+
+// setPageLinks turns the cursors returned with a page of a listing into
+// links to the newer and older pages of the listing at path, keeping the
+// rest of the request's query string
+func setPageLinks(data *templateData, r *http.Request, path string, links models.PageLinks) {
+	link := func(name string, c models.Cursor) string {
+		query := r.URL.Query()
+		query.Del("before")
+		query.Del("after")
+		query.Set(name, c.String())
+		return path + "?" + query.Encode()
+	}
+
+	if !links.Newer.IsZero() {
+		data.NewerPage = link("before", links.Newer)
+	}
+	if !links.Older.IsZero() {
+		data.OlderPage = link("after", links.Older)
+	}
+}
+
+// expiryTime works out when a snippet created at now should expire for one of
+// the preset expiry options. It returns the zero time for "never", and for
+// "custom", which the caller handles itself.
+func expiryTime(option string, now time.Time) time.Time {
+	switch option {
+	case "10m":
+		return now.Add(10 * time.Minute)
+	case "1h":
+		return now.Add(time.Hour)
+	case "1d":
+		return now.AddDate(0, 0, 1)
+	case "1w":
+		return now.AddDate(0, 0, 7)
+	case "1mo":
+		return now.AddDate(0, 1, 0)
+	case "1y":
+		return now.AddDate(1, 0, 0)
+	default:
+		return time.Time{}
+	}
+}
+
+// snippetLocked reports whether a passphrase protected snippet still has to
+// be unlocked by the current visitor. Authors never need the passphrase.
+func (app *application) snippetLocked(r *http.Request, s models.Snippet) bool {
+	if !s.Protected || s.UserID != 0 && s.UserID == app.authenticatedUserID(r) {
+		return false
+	}
+	return !app.sessionManager.GetBool(r.Context(), "unlocked:"+s.Slug)
+}
+
+// snippetSlug reads the {slug} path parameter and checks it's a well-formed
+// slug
+func snippetSlug(r *http.Request) (string, bool) {
+	slug := r.PathValue("slug")
+	return slug, models.ValidSlug(slug)
+}
+
+// readableSnippet fetches the snippet named by the {slug} path parameter for
+// handlers that send its content without a page around it. It applies the
+// same rules as snippetView: hidden, expired and deleted snippets are not
+// found, burn after reading snippets can only be read through the reveal
+// step, and visitors who haven't unlocked a protected snippet are sent to
+// its unlock form. If it returns false a response has already been sent.
+func (app *application) readableSnippet(w http.ResponseWriter, r *http.Request) (models.Snippet, bool) {
+	slug, ok := snippetSlug(r)
+	if !ok {
+		http.NotFound(w, r)
+		return models.Snippet{}, false
+	}
+
+	snippet, err := app.snippets.Get(slug, app.authenticatedUserID(r))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return models.Snippet{}, false
+	}
+
+	if snippet.BurnAfterReading {
+		http.NotFound(w, r)
+		return models.Snippet{}, false
+	}
+
+	if app.snippetLocked(r, snippet) {
+		http.Redirect(w, r, fmt.Sprintf("/snippet/view/%s", slug), http.StatusSeeOther)
+		return models.Snippet{}, false
+	}
+
+	return snippet, true
+}
+
+// snippetFile picks the file named by the file query parameter, or the
+// first file if there isn't one
+func snippetFile(r *http.Request, s models.Snippet) (models.File, bool) {
+	name := r.URL.Query().Get("file")
+	for _, f := range s.Files {
+		if name == "" || f.Name == name {
+			return f, true
+		}
+	}
+	return models.File{}, false
+}
+
+// setRawHeaders prepares a response that carries snippet content as plain
+// text. The content is untrusted, so browsers mustn't sniff it as anything
+// else, and the CSP sandboxes it in case they try to render it anyway.
+func setRawHeaders(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; sandbox")
+}
+
+// downloadName picks the file name a download is saved as. Files in
+// multi-file snippets keep their own names; a lone file is named after the
+// snippet's title with the usual extension for its language.
+func downloadName(s models.Snippet, f models.File) string {
+	if len(s.Files) > 1 {
+		return f.Name
+	}
+
+	// Keep the name to characters that are safe on every file system
+	var b strings.Builder
+	dash := false
+	for _, c := range strings.ToLower(s.Title) {
+		if c < utf8.RuneSelf && (unicode.IsLetter(c) || unicode.IsDigit(c) || c == '_') {
+			b.WriteRune(c)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+		if b.Len() >= 100 {
+			break
+		}
+	}
+
+	name := strings.TrimRight(b.String(), "-")
+	if name == "" {
+		name = s.Slug
+	}
+	return name + highlight.Extension(f.Language)
+}
+
+// checkSnippetCreateForm validates a new snippet, cleaning up its files,
+// and returns its tags and when it expires
+func checkSnippetCreateForm(form *snippetCreateForm) ([]string, time.Time) {
+	form.CheckField(validator.NotBlank(form.Title), "title", "This field cannot be blank")
+	form.CheckField(validator.MaxChars(form.Title, 100), "title", "Cannot be more than 100 characters long.")
+
+	form.Files = checkFiles(&form.Validator, form.Files)
+	tags := parseTags(form.Tags)
+	checkTags(&form.Validator, tags)
+	form.CheckField(validator.PermittedValue(form.Expires, "10m", "1h", "1d", "1w", "1mo", "1y", "never", "custom"), "expires", "Please choose one of the expiry options")
+
+	expires := expiryTime(form.Expires, time.Now().UTC())
+	if form.Expires == "custom" {
+		var err error
+		expires, err = time.Parse(datetimeLocalLayout, form.ExpiresAt)
+		form.CheckField(err == nil, "expires_at", "Please enter a valid date and time")
+		form.CheckField(err != nil || expires.After(time.Now().UTC()), "expires_at", "This must be in the future")
+	}
+	form.CheckField(validator.PermittedValue(form.Visibility, models.VisibilityPublic, models.VisibilityUnlisted, models.VisibilityPrivate), "visibility", "This field must be public, unlisted or private")
+	// bcrypt ignores anything past 72 bytes
+	form.CheckField(len(form.Passphrase) <= 72, "passphrase", "Cannot be more than 72 bytes long.")
+
+	return tags, expires
+}
+
+// checkSnippetEditForm validates a new revision of a snippet, cleaning up
+// its files, and returns its tags
+func checkSnippetEditForm(form *snippetEditForm) []string {
+	form.CheckField(validator.NotBlank(form.Title), "title", "This field cannot be blank")
+	form.CheckField(validator.MaxChars(form.Title, 100), "title", "Cannot be more than 100 characters long.")
+	form.Files = checkFiles(&form.Validator, form.Files)
+	tags := parseTags(form.Tags)
+	checkTags(&form.Validator, tags)
+	return tags
+}
+
+// checkFiles validates the files of a snippet form and returns them without
+// the ones left entirely blank, e.g. because they were removed in the
+// browser. Unnamed files are given a default name. Errors are keyed by the
+// form field name of the file in the returned slice, e.g. "files[1].name".
+// Content is limited in bytes rather than characters, since that's what
+// storing and rendering it costs.
+func checkFiles(v *validator.Validator, files []snippetFileForm) []snippetFileForm {
+	var kept []snippetFileForm
+	for _, f := range files {
+		if validator.NotBlank(f.Name) || validator.NotBlank(f.Content) {
+			kept = append(kept, f)
+		}
+	}
+
+	v.CheckField(len(kept) > 0, "files", "Add at least one file")
+	v.CheckField(len(kept) <= models.MaxFiles, "files", fmt.Sprintf("A snippet can't have more than %d files", models.MaxFiles))
+
+	seen := map[string]bool{}
+	total := 0
+	for i := range kept {
+		f := &kept[i]
+		key := fmt.Sprintf("files[%d]", i)
+
+		f.Name = strings.TrimSpace(f.Name)
+		if f.Name == "" {
+			f.Name = fmt.Sprintf("file%d", i+1)
+		}
+		v.CheckField(validator.MaxChars(f.Name, 100), key+".name", "Cannot be more than 100 characters long.")
+		v.CheckField(validFileName(f.Name), key+".name", "File names can't contain slashes or control characters")
+		// Names are compared case-insensitively so the zip archive
+		// extracts cleanly on case-insensitive file systems
+		v.CheckField(!seen[strings.ToLower(f.Name)], key+".name", "Another file already has this name")
+		seen[strings.ToLower(f.Name)] = true
+
+		v.CheckField(f.Language == "" || highlight.Supported(f.Language), key+".language", "Please choose one of the listed languages")
+		v.CheckField(validator.NotBlank(f.Content), key+".content", "This field cannot be blank")
+		v.CheckField(validator.MaxBytes(f.Content, models.MaxFileBytes), key+".content", fmt.Sprintf("Cannot be more than %d KB.", models.MaxFileBytes>>10))
+		total += len(f.Content)
+	}
+	v.CheckField(total <= models.MaxSnippetBytes, "files", fmt.Sprintf("A snippet's files can't hold more than %d KB between them", models.MaxSnippetBytes>>10))
+
+	return kept
+}
+
+// parseTags splits the tags typed into a form, which may be separated by
+// commas or spaces. They're lowercased, stripped of a leading #, sorted and
+// deduplicated.
+func parseTags(s string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+
+	var tags []string
+	for _, f := range fields {
+		if f = strings.TrimLeft(f, "#"); f != "" {
+			tags = append(tags, f)
+		}
+	}
+
+	slices.Sort(tags)
+	return slices.Compact(tags)
+}
+
+// checkTags validates tags parsed with parseTags
+func checkTags(v *validator.Validator, tags []string) {
+	v.CheckField(validator.MaxCount(tags, models.MaxTags), "tags", fmt.Sprintf("A snippet can't have more than %d tags", models.MaxTags))
+	v.CheckField(validator.AllMatch(tags, validator.TagRX), "tags", "Tags can only contain letters, digits and + # . _ -, up to 30 characters each")
+}
+
+// validFileName reports whether name is safe to use as a file name in a zip
+// archive, where it mustn't reach outside the snippet's directory
+func validFileName(name string) bool {
+	if name == "." || name == ".." {
+		return false
+	}
+	return !strings.ContainsAny(name, `/\`) && !strings.ContainsFunc(name, unicode.IsControl)
+}
+
+// snippetFiles converts validated form files into model files, detecting
+// the language of any file without one
+func snippetFiles(files []snippetFileForm) []models.File {
+	list := make([]models.File, len(files))
+	for i, f := range files {
+		language := f.Language
+		if language == "" {
+			language = highlight.DetectFile(f.Name, f.Content)
+		}
+		list[i] = models.File{Position: i, Name: f.Name, Language: language, Content: f.Content}
+	}
+	return list
+}
+
+// revisionFiles returns the files of revision n of a snippet fetched with Get
+func (app *application) revisionFiles(r *http.Request, s models.Snippet, n int) ([]models.File, error) {
+	if n == s.Revision {
+		return s.Files, nil
+	}
+	revision, err := app.snippets.GetRevision(s.ID, n, app.authenticatedUserID(r))
+	if err != nil {
+		return nil, err
+	}
+	return revision.Files, nil
+}
+
+// diffFiles compares two versions of a snippet's files by name. Files that
+// are unchanged are left out, so an empty result means nothing changed.
+func diffFiles(from, to []models.File) []fileDiff {
+	old := map[string]string{}
+	for _, f := range from {
+		old[f.Name] = f.Content
+	}
+
+	var diffs []fileDiff
+	for _, f := range to {
+		prev, ok := old[f.Name]
+		delete(old, f.Name)
+		if ok && prev == f.Content {
+			continue
+		}
+		d := fileDiff{Name: f.Name, Hunks: diff.Unified(prev, f.Content, 3)}
+		if !ok {
+			d.Status = "added"
+		}
+		diffs = append(diffs, d)
+	}
+
+	// Whatever is left was removed; keep the old order
+	for _, f := range from {
+		if prev, ok := old[f.Name]; ok {
+			diffs = append(diffs, fileDiff{Name: f.Name, Status: "removed", Hunks: diff.Unified(prev, "", 3)})
+		}
+	}
+
+	return diffs
+}
+
+// purge periodically removes snippets that have sat in the trash, or
+// expired, for longer than the retention period, along with expired password
+// reset tokens and accounts that were never verified. It runs until the
+// process exits, so call it in its own goroutine.
+func (app *application) purge(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		n, err := app.snippets.Purge()
+		if err != nil {
+			app.logger.Error(err.Error())
+		} else if n > 0 {
+			app.logger.Info("Purged snippets", "count", n)
+		}
+
+		n, err = app.users.PurgePasswordResets()
+		if err != nil {
+			app.logger.Error(err.Error())
+		} else if n > 0 {
+			app.logger.Info("Purged password reset tokens", "count", n)
+		}
+
+		n, err = app.users.PurgeUnverified(app.unverifiedTTL)
+		if err != nil {
+			app.logger.Error(err.Error())
+		} else if n > 0 {
+			app.logger.Info("Purged unverified accounts", "count", n)
+		}
+
+		for _, t := range []*throttle.Throttle{app.loginByEmail, app.loginByIP} {
+			n, err = t.Prune(time.Now())
+			if err != nil {
+				app.logger.Error(err.Error())
+			} else if n > 0 {
+				app.logger.Info("Pruned failed login records", "count", n)
+			}
+		}
+	}
+}
+
+// verifyEmailPurpose is the signer purpose of email verification links
+const verifyEmailPurpose = "verify-email"
+
+// sendVerificationEmail emails a user a signed link to verify their address.
+// The link names the address so it stops working if the address changes, and
+// lasts as long as an unverified account does.
+func (app *application) sendVerificationEmail(user models.User) {
+	payload := strconv.Itoa(user.ID) + " " + user.Email
+	token := app.signer.Sign(verifyEmailPurpose, payload, time.Now().Add(app.unverifiedTTL))
+	link := app.baseURL + "/user/verify?token=" + url.QueryEscape(token)
+	app.sendMail(verificationEmail(user.Email, user.Name, link))
+}
+
+// parseVerificationToken returns the user ID and email address a
+// verification link was sent for
+func (app *application) parseVerificationToken(token string) (int, string, error) {
+	payload, err := app.signer.Verify(verifyEmailPurpose, token, time.Now())
+	if err != nil {
+		return 0, "", err
+	}
+
+	idString, email, ok := strings.Cut(payload, " ")
+	id, err := strconv.Atoi(idString)
+	if !ok || err != nil {
+		return 0, "", signer.ErrInvalid
+	}
+	return id, email, nil
+}
+
+// sendMail sends an email in the background so the request doesn't wait on
+// the mail server, and so responses take the same time whether or not an
+// email was sent. Failures are logged.
+func (app *application) sendMail(msg mailer.Message) {
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				app.logger.Error(fmt.Sprintf("%s", err))
+			}
+		}()
+
+		err := app.mailer.Send(msg)
+		if err != nil {
+			app.logger.Error(err.Error(), "to", msg.To, "subject", msg.Subject)
+		}
+	}()
+}
+
+// maxAvatarSide is the largest width or height of an avatar, in pixels
+const maxAvatarSide = 1024
+
+// avatarImage reads an uploaded avatar of the given size and checks it's a
+// PNG, JPEG or GIF image browsers can show. It returns the image along with
+// its content type.
+func avatarImage(file io.Reader, size int64) ([]byte, string, error) {
+	if size > models.MaxAvatarBytes {
+		return nil, "", errors.New("avatar is too large")
+	}
+
+	b, err := io.ReadAll(io.LimitReader(file, models.MaxAvatarBytes+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(b) > models.MaxAvatarBytes {
+		return nil, "", errors.New("avatar is too large")
+	}
+
+	// Only the header is decoded, which is enough to tell the format and
+	// catch images too big to be sensible
+	config, format, err := image.DecodeConfig(bytes.NewReader(b))
+	if err != nil {
+		return nil, "", err
+	}
+	if config.Width > maxAvatarSide || config.Height > maxAvatarSide {
+		return nil, "", errors.New("avatar is too large")
+	}
+
+	return b, "image/" + format, nil
 }
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shaheerkj/snippetbox/internal/models"
+	"github.com/shaheerkj/snippetbox/internal/throttle"
+	"github.com/shaheerkj/snippetbox/internal/validator"
+)
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name       string
+		remoteAddr string
+		want       string
+	}{
+		{"IPv4", "192.0.2.1:1234", "192.0.2.1"},
+		{"IPv4-mapped IPv6", "[::ffff:192.0.2.1]:1234", "192.0.2.1"},
+		{"IPv6", "[2001:db8:1:2:3:4:5:6]:1234", "2001:db8:1:2::/64"},
+		{"IPv6 in the same /64", "[2001:db8:1:2:ffff:ffff:ffff:ffff]:1234", "2001:db8:1:2::/64"},
+		{"IPv6 in another /64", "[2001:db8:1:3::1]:1234", "2001:db8:1:3::/64"},
+		{"Unparseable", "pipe", "pipe"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+
+			if got := clientIP(r); got != tt.want {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoginKeys(t *testing.T) {
+	tests := []struct {
+		name       string
+		email      string
+		remoteAddr string
+		byEmail    string
+		byIP       string
+	}{
+		{"Plain", "alice@example.com", "192.0.2.1:1234", "email:alice@example.com", "ip:192.0.2.1"},
+		{"Case and spaces", " Alice@Example.COM ", "192.0.2.1:1234", "email:alice@example.com", "ip:192.0.2.1"},
+		{"IPv6", "alice@example.com", "[2001:db8::1]:1234", "email:alice@example.com", "ip:2001:db8::/64"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/user/login", nil)
+			r.RemoteAddr = tt.remoteAddr
+
+			byEmail, byIP := loginKeys(r, tt.email)
+			if byEmail != tt.byEmail || byIP != tt.byIP {
+				t.Errorf("got %q, %q; want %q, %q", byEmail, byIP, tt.byEmail, tt.byIP)
+			}
+		})
+	}
+}
+
+func TestLoginThrottling(t *testing.T) {
+	store := throttle.NewMemoryStore()
+	app := &application{
+		loginByEmail: &throttle.Throttle{Store: store, Policy: loginByEmailPolicy},
+		loginByIP:    &throttle.Throttle{Store: store, Policy: loginByIPPolicy},
+	}
+
+	request := func(remoteAddr string) *http.Request {
+		r := httptest.NewRequest("POST", "/user/login", nil)
+		r.RemoteAddr = remoteAddr
+		return r
+	}
+	alice := request("192.0.2.1:1234")
+
+	// The free failures don't slow anyone down
+	for i := 0; i < loginByEmailPolicy.FreeFailures; i++ {
+		if err := app.loginFailed(alice, "alice@example.com"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wait, err := app.loginWait(alice, "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wait != 0 {
+		t.Fatalf("wait after the free failures = %s; want 0", wait)
+	}
+
+	if err := app.loginFailed(alice, "alice@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		r         *http.Request
+		email     string
+		throttled bool
+	}{
+		{"Same email and address", alice, "alice@example.com", true},
+		{"Same email in another case", alice, "ALICE@example.com", true},
+		{"Same email from elsewhere", request("198.51.100.7:1234"), "alice@example.com", true},
+		{"Another email from the same address", alice, "bob@example.com", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wait, err := app.loginWait(tt.r, tt.email)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if throttled := wait > 0; throttled != tt.throttled {
+				t.Errorf("throttled = %t (wait %s); want %t", throttled, wait, tt.throttled)
+			}
+		})
+	}
+
+	// Succeeding forgets the email's failures but not the address's
+	if err := app.loginSucceeded(alice, "alice@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	wait, err = app.loginWait(alice, "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wait != 0 {
+		t.Errorf("wait after logging in = %s; want 0", wait)
+	}
+	rec, err := store.Get("ip:192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Failures != loginByEmailPolicy.FreeFailures+1 {
+		t.Errorf("address has %d failures after logging in; want %d", rec.Failures, loginByEmailPolicy.FreeFailures+1)
+	}
+}
+
+func TestWaitText(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{time.Millisecond, "1 second"},
+		{1500 * time.Millisecond, "2 seconds"},
+		{time.Minute, "60 seconds"},
+		{61 * time.Second, "2 minutes"},
+		{15 * time.Minute, "15 minutes"},
+	}
+
+	for _, tt := range tests {
+		if got := waitText(tt.d); got != tt.want {
+			t.Errorf("waitText(%s) = %q; want %q", tt.d, got, tt.want)
+		}
+	}
+}
+
+func TestCheckFiles(t *testing.T) {
+	file := func(name string, size int) snippetFileForm {
+		return snippetFileForm{Name: name, Content: strings.Repeat("a", size)}
+	}
+	many := func(n, size int) []snippetFileForm {
+		var files []snippetFileForm
+		for i := 0; i < n; i++ {
+			files = append(files, file(fmt.Sprintf("f%d.txt", i), size))
+		}
+		return files
+	}
+
+	tests := []struct {
+		name      string
+		files     []snippetFileForm
+		wantKept  int
+		wantError string // Field with an error, or "" for none
+	}{
+		{"One file", many(1, 10), 1, ""},
+		{"Blank files are dropped", append(many(1, 10), snippetFileForm{}), 1, ""},
+		{"No files", []snippetFileForm{{}}, 0, "files"},
+		{"Too many files", many(models.MaxFiles+1, 1), models.MaxFiles + 1, "files"},
+		{"Largest file", many(1, models.MaxFileBytes), 1, ""},
+		{"File too large", append(many(1, 1), file("big.txt", models.MaxFileBytes+1)), 2, "files[1].content"},
+		{"Multi-byte characters count as bytes", []snippetFileForm{{Name: "a.txt", Content: strings.Repeat("é", models.MaxFileBytes/2+1)}}, 1, "files[0].content"},
+		{"Largest snippet", many(models.MaxSnippetBytes/models.MaxFileBytes, models.MaxFileBytes), models.MaxSnippetBytes / models.MaxFileBytes, ""},
+		{"Snippet too large", append(many(models.MaxSnippetBytes/models.MaxFileBytes, models.MaxFileBytes), file("more.txt", 1)), models.MaxSnippetBytes/models.MaxFileBytes + 1, "files"},
+		{"Name too long", []snippetFileForm{file(strings.Repeat("a", 101), 1)}, 1, "files[0].name"},
+		{"Name with a slash", []snippetFileForm{file("a/b", 1)}, 1, "files[0].name"},
+		{"Duplicate names", []snippetFileForm{file("A.txt", 1), file("a.txt", 1)}, 2, "files[1].name"},
+		{"Unknown language", []snippetFileForm{{Name: "a", Language: "cobol", Content: "a"}}, 1, "files[0].language"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v validator.Validator
+			kept := checkFiles(&v, tt.files)
+
+			if len(kept) != tt.wantKept {
+				t.Errorf("kept %d files; want %d", len(kept), tt.wantKept)
+			}
+			if tt.wantError == "" {
+				if !v.Valid() {
+					t.Errorf("got errors %v; want none", v.FieldErrors)
+				}
+				return
+			}
+			if _, ok := v.FieldErrors[tt.wantError]; !ok || len(v.FieldErrors) != 1 {
+				t.Errorf("got errors %v; want one for %s", v.FieldErrors, tt.wantError)
+			}
+		})
+	}
+}
@@ -1,20 +1,29 @@
 package main
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
 	"crypto/tls"
 	"database/sql"
+	"encoding/hex"
 	"flag"
+	"html/template"
 	"log/slog"
 	"net/http"
+	"net/mail"
 	"os"
-	"text/template"
+	"strings"
 	"time"
 
 	"github.com/alexedwards/scs/mysqlstore"
 	"github.com/alexedwards/scs/v2"
 	"github.com/go-playground/form/v4"
 	_ "github.com/go-sql-driver/mysql" // Import MySQL driver (blank import to register driver)
+	"github.com/shaheerkj/snippetbox/internal/mailer"
 	"github.com/shaheerkj/snippetbox/internal/models"
+	"github.com/shaheerkj/snippetbox/internal/secretbox"
+	"github.com/shaheerkj/snippetbox/internal/signer"
+	"github.com/shaheerkj/snippetbox/internal/throttle"
 )
 
 // application holds application-wide dependencies and shared resources
@@ -26,12 +35,28 @@ type application struct {
 	templateCache  map[string]*template.Template // Pre-parsed templates for better performance
 	formDecoder    *form.Decoder
 	sessionManager *scs.SessionManager
+	mailer         mailer.Mailer
+	baseURL        string // Scheme and host the site is reached at, for links in emails
+	signer         *signer.Signer
+	secrets        *secretbox.Box     // Encrypts two-factor secrets stored in the database
+	unverifiedTTL  time.Duration      // How long accounts can go without verifying their email
+	loginByEmail   *throttle.Throttle // Failed logins per account email
+	loginByIP      *throttle.Throttle // Failed logins per client IP address
 }
 
 func main() {
 	// Parse command-line flags for configuration
 	addr := flag.String("addr", ":4000", "HTTP network address")
 	dsn := flag.String("dsn", "shaheer:110434@/snippetbox?parseTime=true", "MySQL DSN String")
+	baseURL := flag.String("base-url", "https://localhost:4000", "URL the site is reached at, for links in emails")
+	smtpAddr := flag.String("smtp-addr", "", "SMTP server host:port (emails are written to -outbox if empty)")
+	smtpUsername := flag.String("smtp-username", "", "SMTP username")
+	smtpPassword := flag.String("smtp-password", "", "SMTP password")
+	mailFrom := flag.String("mail-from", "Snippetbox <no-reply@snippetbox.local>", `Sender of outgoing emails, as an address or "Name <address>"`)
+	outbox := flag.String("outbox", "./tmp/outbox", "Directory emails are written to when no SMTP server is set")
+	secretKey := flag.String("secret-key", "", "Hex-encoded key of at least 32 bytes for signing links and encrypting two-factor secrets; required, and must not change between runs")
+	throttleStore := flag.String("throttle-store", "memory", `Where failed logins are counted: "memory", or "mysql" to share counts between servers`)
+	unverifiedTTL := flag.Duration("unverified-ttl", 7*24*time.Hour, "How long new accounts have to verify their email before they're deleted")
 
 	flag.Parse() // Parse the flags from command line
 
@@ -50,6 +75,7 @@ func main() {
 	templateCache, err := newTemplateCache()
 	if err != nil {
 		logger.Error(err.Error())
+		os.Exit(1)
 	}
 
 	// initialize the form decoder
@@ -60,6 +86,52 @@ func main() {
 	sessionManager.Store = mysqlstore.New(db)
 	sessionManager.Lifetime = 12 * time.Hour
 
+	// Links in emails are signed with the secret key and two-factor secrets
+	// are encrypted with a key derived from it. It has to stay the same
+	// between runs, or two-factor secrets already stored can't be decrypted
+	// and their users are left with only their recovery codes.
+	key, err := hex.DecodeString(*secretKey)
+	if err != nil || len(key) < 32 {
+		logger.Error("-secret-key must be set to at least 32 hex-encoded bytes, e.g. from `openssl rand -hex 32`, and kept the same between runs")
+		os.Exit(1)
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("snippetbox two-factor secrets"))
+	secrets, err := secretbox.New(mac.Sum(nil))
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	// Failed logins are counted in memory unless several servers need to
+	// share the counts
+	var failures throttle.Store
+	switch *throttleStore {
+	case "memory":
+		failures = throttle.NewMemoryStore()
+	case "mysql":
+		failures = &throttle.MySQLStore{DB: db}
+	default:
+		logger.Error(`-throttle-store must be "memory" or "mysql"`)
+		os.Exit(1)
+	}
+
+	// The sender can have a display name, but SMTP needs the bare address
+	// for the envelope, so parse it now rather than on the first email
+	from, err := mail.ParseAddress(*mailFrom)
+	if err != nil {
+		logger.Error("-mail-from must be an email address, optionally with a name: " + err.Error())
+		os.Exit(1)
+	}
+
+	// Send emails through SMTP if a server is configured, or write them to
+	// files for local development
+	var mailSender mailer.Mailer = &mailer.Outbox{Dir: *outbox, From: *from}
+	if *smtpAddr != "" {
+		mailSender = &mailer.SMTP{Addr: *smtpAddr, Username: *smtpUsername, Password: *smtpPassword, From: *from}
+	}
+
 	// Initialize application dependencies
 	// Using & creates a pointer, allowing the struct to be shared across handlers
 	app := &application{
@@ -69,8 +141,19 @@ func main() {
 		templateCache:  templateCache,
 		formDecoder:    formDecoder,
 		sessionManager: sessionManager,
+		mailer:         mailSender,
+		baseURL:        strings.TrimSuffix(*baseURL, "/"),
+		signer:         signer.New(key),
+		secrets:        secrets,
+		unverifiedTTL:  *unverifiedTTL,
+		loginByEmail:   &throttle.Throttle{Store: failures, Policy: loginByEmailPolicy},
+		loginByIP:      &throttle.Throttle{Store: failures, Policy: loginByIPPolicy},
 	}
 
+	// Hard-delete old trashed and expired snippets and stale tokens in the
+	// background
+	go app.purge(time.Hour)
+
 	tlsConfig := &tls.Config{
 		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
 	}
@@ -1,10 +1,18 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/justinas/nosurf"
+	"github.com/shaheerkj/snippetbox/internal/models"
+	"github.com/shaheerkj/snippetbox/internal/ratelimit"
 )
 
 // commonHeaders sets security headers on all responses
@@ -59,6 +67,12 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 func (app *application) requireAuthentication(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if !app.isAuthenticated(r) {
+			// Programs using the JSON API can't follow a login form
+			if isAPIRequest(r) {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				statusError(w, r, http.StatusUnauthorized)
+				return
+			}
 			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
 			return
 		}
@@ -67,6 +81,97 @@ func (app *application) requireAuthentication(next http.Handler) http.Handler {
 	})
 }
 
+// authenticate checks that the user a session is logged in as still exists
+// and that the session hasn't been invalidated since, e.g. by a password
+// change elsewhere. Invalid sessions are logged out; valid ones are marked
+// as authenticated in the request context.
+func (app *application) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Requests made with a personal access token don't use the session
+		if tokenScope(r) != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		id := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+		if id == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		version, err := app.users.SessionVersion(id)
+		if err != nil && !errors.Is(err, models.ErrNoRecord) {
+			app.serverError(w, r, err)
+			return
+		}
+
+		if err != nil || version != app.sessionManager.GetInt(r.Context(), "sessionVersion") {
+			app.sessionManager.Remove(r.Context(), "authenticatedUserID")
+			app.sessionManager.Remove(r.Context(), "sessionVersion")
+			app.sessionManager.Remove(r.Context(), "reauthenticatedAt")
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), isAuthenticatedContextKey, true)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requireReauthentication sends users who haven't confirmed their password
+// recently to do so before continuing to a sensitive page. It must come
+// after requireAuthentication.
+func (app *application) requireReauthentication(next http.Handler) http.Handler {
+	return app.requireReauthenticationFrom("")(next)
+}
+
+// requireReauthenticationFrom is requireReauthentication for forms that are
+// shown on page but posted somewhere else. Users are sent back to page once
+// they've confirmed their password.
+func (app *application) requireReauthenticationFrom(page string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !app.recentlyReauthenticated(r) {
+				// Remember where to come back to. Most forms are posted to
+				// the same path they're shown at, so the path is enough.
+				redirect := page
+				if redirect == "" {
+					redirect = r.URL.Path
+				}
+				app.sessionManager.Put(r.Context(), "reauthenticateRedirect", redirect)
+				http.Redirect(w, r, "/account/confirm", http.StatusSeeOther)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// requireVerifiedEmail stops users who haven't verified their email address
+// from going further, showing them how to get a new verification link. It
+// must come after requireAuthentication.
+func (app *application) requireVerifiedEmail(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, err := app.users.Get(app.authenticatedUserID(r))
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		if !user.EmailVerified {
+			if isAPIRequest(r) {
+				writeAPIError(w, http.StatusForbidden, "Verify your email address first")
+				return
+			}
+			data := app.newTemplateData(r)
+			data.User = user
+			app.render(w, r, http.StatusForbidden, "verify.html", data)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 func noSurf(next http.Handler) http.Handler {
 	csrfHandler := nosurf.New(next)
 	csrfHandler.SetBaseCookie(http.Cookie{
@@ -74,5 +179,98 @@ func noSurf(next http.Handler) http.Handler {
 		Path:     "/",
 		Secure:   true,
 	})
+	// Browsers never add a personal access token to a request by
+	// themselves, so requests made with one can't be forged
+	csrfHandler.ExemptFunc(func(r *http.Request) bool {
+		return tokenScope(r) != ""
+	})
+	csrfHandler.SetFailureHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		statusError(w, r, http.StatusBadRequest)
+	}))
 	return csrfHandler
 }
+
+// authenticateToken authenticates requests that carry a personal access
+// token in an "Authorization: Bearer" header, for scripts and other
+// programs that don't have a session. Requests without the header are left
+// to the session. It must come before noSurf and authenticate.
+func (app *application) authenticateToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		if header == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token, ok := strings.CutPrefix(header, "Bearer ")
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_request"`)
+			statusError(w, r, http.StatusUnauthorized)
+			return
+		}
+
+		id, scope, err := app.users.AuthenticateAPIToken(strings.TrimSpace(token))
+		if err != nil {
+			if errors.Is(err, models.ErrInvalidCredentials) {
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				statusError(w, r, http.StatusUnauthorized)
+			} else {
+				app.serverError(w, r, err)
+			}
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), isAuthenticatedContextKey, true)
+		ctx = context.WithValue(ctx, tokenUserIDContextKey, id)
+		ctx = context.WithValue(ctx, tokenScopeContextKey, scope)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requireScope stops requests made with a personal access token that
+// doesn't allow scope. Write tokens can read too, and requests made with a
+// session can do anything.
+func requireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			have := tokenScope(r)
+			if have != "" && have != scope && have != models.ScopeWrite {
+				w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="`+scope+`"`)
+				statusError(w, r, http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// rateLimit returns middleware that limits how often each client can make
+// requests, identifying logged in users by their ID and everyone else by IP
+// address. It must come after authenticate to tell users apart. The limit
+// and what's left of it are sent in RateLimit-* headers, and requests over
+// it get a 429 response.
+func (app *application) rateLimit(limiter *ratelimit.Limiter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := "ip:" + clientIP(r)
+			if id := app.authenticatedUserID(r); id != 0 {
+				key = "user:" + strconv.Itoa(id)
+			}
+
+			result := limiter.Allow(key, time.Now())
+
+			w.Header().Set("RateLimit-Policy", limiter.Limit.Policy())
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(limiter.Limit.Requests))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+			w.Header().Set("RateLimit-Reset", strconv.Itoa(int(math.Ceil(result.Reset.Seconds()))))
+
+			if !result.Allowed {
+				setRetryAfter(w, result.RetryAfter)
+				statusError(w, r, http.StatusTooManyRequests)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
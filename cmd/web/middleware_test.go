@@ -0,0 +1,136 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/shaheerkj/snippetbox/internal/ratelimit"
+)
+
+func TestRateLimit(t *testing.T) {
+	app := &application{}
+	limiter := ratelimit.New(ratelimit.Limit{Requests: 2, Per: time.Minute})
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	})
+	handler := app.rateLimit(limiter)(next)
+
+	// Use up one of another address's requests, which shouldn't count
+	limiter.Allow("ip:198.51.100.7", time.Now())
+
+	tests := []struct {
+		name       string
+		path       string
+		wantStatus int
+		remaining  string
+		reset      string
+		retryAfter string
+		wantJSON   bool
+	}{
+		{"First request", "/", http.StatusOK, "1", "30", "", false},
+		{"Last request", "/", http.StatusOK, "0", "60", "", false},
+		{"Over the limit", "/", http.StatusTooManyRequests, "0", "60", "30", false},
+		{"Over the limit on the API", apiPrefix + "/snippets", http.StatusTooManyRequests, "0", "60", "30", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			r.RemoteAddr = "192.0.2.1:1234"
+			rr := httptest.NewRecorder()
+
+			handler.ServeHTTP(rr, r)
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("got status %d; want %d", rr.Code, tt.wantStatus)
+			}
+			headers := map[string]string{
+				"RateLimit-Policy":    "2;w=60",
+				"RateLimit-Limit":     "2",
+				"RateLimit-Remaining": tt.remaining,
+				"RateLimit-Reset":     tt.reset,
+				"Retry-After":         tt.retryAfter,
+			}
+			for name, want := range headers {
+				if got := rr.Header().Get(name); got != want {
+					t.Errorf("got %s %q; want %q", name, got, want)
+				}
+			}
+			if isJSON := rr.Header().Get("Content-Type") == "application/json"; isJSON != tt.wantJSON {
+				t.Errorf("got Content-Type %q; want JSON %t", rr.Header().Get("Content-Type"), tt.wantJSON)
+			}
+		})
+	}
+}
+
+func TestRouteRateLimits(t *testing.T) {
+	app := newTestApplication(t, nil)
+	ts := newTestServer(t, app.routes())
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		limit  ratelimit.Limit
+	}{
+		{"Static files", http.MethodGet, "/static/css/main.css", staticRateLimit},
+		{"Pages", http.MethodGet, "/user/login", pageRateLimit},
+		{"Logging in", http.MethodPost, "/user/login", strictRateLimit},
+		{"Signing up", http.MethodPost, "/user/signup", strictRateLimit},
+		{"Password resets", http.MethodPost, "/user/password/reset", strictRateLimit},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var res *http.Response
+			if tt.method == http.MethodPost {
+				// An empty form fails validation before the database is
+				// needed
+				res, _ = ts.postForm(t, tt.path, url.Values{})
+			} else {
+				res, _ = ts.get(t, tt.path)
+			}
+
+			if got, want := res.Header.Get("RateLimit-Limit"), strconv.Itoa(tt.limit.Requests); got != want {
+				t.Errorf("got RateLimit-Limit %q; want %q", got, want)
+			}
+			if got, want := res.Header.Get("RateLimit-Policy"), tt.limit.Policy(); got != want {
+				t.Errorf("got RateLimit-Policy %q; want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestStrictRateLimit(t *testing.T) {
+	app := newTestApplication(t, nil)
+	ts := newTestServer(t, app.routes())
+
+	token := ts.csrfToken(t, "/user/login")
+	form := url.Values{"csrf_token": {token}}
+
+	for i := 1; i <= strictRateLimit.Requests; i++ {
+		res, _ := ts.post(t, "/user/login", form)
+		if res.StatusCode != http.StatusUnprocessableEntity {
+			t.Fatalf("request %d: got status %d; want %d", i, res.StatusCode, http.StatusUnprocessableEntity)
+		}
+	}
+
+	res, _ := ts.post(t, "/user/login", form)
+	if res.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("got status %d once over the limit; want %d", res.StatusCode, http.StatusTooManyRequests)
+	}
+	if res.Header.Get("Retry-After") == "" {
+		t.Error("no Retry-After header once over the limit")
+	}
+
+	// Other pages have their own limit
+	res, _ = ts.get(t, "/user/login")
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("got status %d for the login page; want %d", res.StatusCode, http.StatusOK)
+	}
+}
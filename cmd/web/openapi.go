@@ -0,0 +1,234 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// pathParamRX matches the {name} path parameters in a route
+var pathParamRX = regexp.MustCompile(`\{(\w+)\}`)
+
+// openAPI builds an OpenAPI 3 document describing the JSON API from its
+// endpoint table, with schemas taken from the request and response types
+func (app *application) openAPI() map[string]any {
+	schemas := map[string]any{}
+	s := schemaBuilder{schemas: schemas}
+
+	errorResponse := map[string]any{
+		"description": "Error",
+		"content": map[string]any{
+			"application/json": map[string]any{"schema": s.schema(reflect.TypeOf(apiError{}))},
+		},
+	}
+
+	paths := map[string]any{}
+	for _, e := range app.apiEndpoints() {
+		var params []any
+		for _, m := range pathParamRX.FindAllStringSubmatch(e.Path, -1) {
+			params = append(params, map[string]any{
+				"name": m[1], "in": "path", "required": true,
+				"schema": map[string]any{"type": "string"},
+			})
+		}
+		for _, q := range e.Query {
+			params = append(params, map[string]any{
+				"name": q.Name, "in": "query", "description": q.Description,
+				"schema": map[string]any{"type": "string"},
+			})
+		}
+
+		success := map[string]any{"description": http.StatusText(e.Status)}
+		if e.Response != nil {
+			success["content"] = map[string]any{
+				"application/json": map[string]any{"schema": s.schema(reflect.TypeOf(e.Response))},
+			}
+		}
+
+		op := map[string]any{
+			"summary":     e.Summary,
+			"operationId": operationID(e),
+			"responses": map[string]any{
+				strconv.Itoa(e.Status): success,
+				"default":              errorResponse,
+			},
+		}
+		if params != nil {
+			op["parameters"] = params
+		}
+		if e.Request != nil {
+			op["requestBody"] = map[string]any{
+				"required": true,
+				"content": map[string]any{
+					"application/json": map[string]any{"schema": s.schema(reflect.TypeOf(e.Request))},
+				},
+			}
+		}
+		if e.Scope != "" {
+			op["security"] = []any{map[string]any{"bearerAuth": []string{e.Scope}}}
+			op["description"] = "Needs a personal access token with the " + e.Scope + " scope, or a session."
+		}
+
+		item, _ := paths[e.Path].(map[string]any)
+		if item == nil {
+			item = map[string]any{}
+			paths[e.Path] = item
+		}
+		item[strings.ToLower(e.Method)] = op
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       "Snippetbox API",
+			"version":     "1",
+			"description": "Request bodies are JSON of at most " + strconv.Itoa(maxAPIBodyBytes) + " bytes. Errors have the Error schema.",
+		},
+		"servers": []any{map[string]any{"url": app.baseURL + apiPrefix}},
+		"paths":   paths,
+		"components": map[string]any{
+			"schemas": schemas,
+			"securitySchemes": map[string]any{
+				"bearerAuth": map[string]any{
+					"type":        "http",
+					"scheme":      "bearer",
+					"description": "A personal access token from /account/tokens",
+				},
+			},
+		},
+	}
+}
+
+// operationID names an endpoint for code generators, from its method and
+// path, e.g. getUsersHandleSnippets
+func operationID(e apiEndpoint) string {
+	id := strings.ToLower(e.Method)
+	for _, part := range strings.FieldsFunc(e.Path, func(r rune) bool { return r == '/' || r == '{' || r == '}' }) {
+		id += strings.ToUpper(part[:1]) + part[1:]
+	}
+	return id
+}
+
+// schemaBuilder turns Go types into OpenAPI schemas, adding each named
+// struct to schemas once and referring to it from then on
+type schemaBuilder struct {
+	schemas map[string]any
+}
+
+// schemaNames are the names request and response types are documented
+// under, rather than their Go names
+var schemaNames = map[string]string{
+	"apiError":          "Error",
+	"apiErrorDetail":    "ErrorDetail",
+	"apiSnippetSummary": "SnippetSummary",
+	"apiSnippet":        "Snippet",
+	"apiAuthor":         "Author",
+	"apiFile":           "File",
+	"apiSnippetList":    "SnippetList",
+	"apiSnippetCreate":  "SnippetCreate",
+	"apiSnippetUpdate":  "SnippetUpdate",
+	"apiProfile":        "Profile",
+	"apiAccount":        "Account",
+}
+
+func (b schemaBuilder) schema(t reflect.Type) map[string]any {
+	switch {
+	case t == reflect.TypeOf(time.Time{}):
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Pointer:
+		s := b.schema(t.Elem())
+		if ref, ok := s["$ref"]; ok {
+			// Siblings of $ref are ignored in OpenAPI 3.0
+			return map[string]any{"nullable": true, "allOf": []any{map[string]any{"$ref": ref}}}
+		}
+		s["nullable"] = true
+		return s
+	case t.Kind() == reflect.Slice:
+		return map[string]any{"type": "array", "items": b.schema(t.Elem())}
+	case t.Kind() == reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": b.schema(t.Elem())}
+	case t.Kind() == reflect.String:
+		return map[string]any{"type": "string"}
+	case t.Kind() == reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		return map[string]any{"type": "number"}
+	case t.Kind() == reflect.Struct:
+		name := schemaNames[t.Name()]
+		if name == "" {
+			name = t.Name()
+		}
+		if _, ok := b.schemas[name]; !ok {
+			// Placeholder, in case the type refers to itself
+			b.schemas[name] = nil
+			b.schemas[name] = b.object(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]any{}
+}
+
+// object describes a struct's JSON fields. Embedded structs' fields are
+// included as if they were the struct's own, as encoding/json does.
+func (b schemaBuilder) object(t reflect.Type) map[string]any {
+	properties := map[string]any{}
+	var required []string
+
+	var addFields func(t reflect.Type)
+	addFields = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.Anonymous && f.Type.Kind() == reflect.Struct {
+				addFields(f.Type)
+				continue
+			}
+			if !f.IsExported() {
+				continue
+			}
+
+			name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+
+			s := b.schema(f.Type)
+			if doc := f.Tag.Get("doc"); doc != "" {
+				if _, ok := s["$ref"]; ok {
+					s = map[string]any{"allOf": []any{s}}
+				}
+				s["description"] = doc
+			}
+			if enum := f.Tag.Get("enum"); enum != "" {
+				s["enum"] = strings.Split(enum, ",")
+			}
+			properties[name] = s
+
+			if !strings.Contains(opts, "omitempty") {
+				required = append(required, name)
+			}
+		}
+	}
+	addFields(t)
+
+	object := map[string]any{"type": "object", "properties": properties}
+	if required != nil {
+		object["required"] = required
+	}
+	return object
+}
+
+// apiOpenAPI serves the OpenAPI document for the JSON API
+func (app *application) apiOpenAPI(w http.ResponseWriter, r *http.Request) {
+	err := writeJSON(w, http.StatusOK, app.openAPI())
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
@@ -2,42 +2,156 @@ package main
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/justinas/alice" // Middleware chaining library
+	"github.com/shaheerkj/snippetbox/internal/models"
+	"github.com/shaheerkj/snippetbox/internal/ratelimit"
+)
+
+// Rate limits per client for each group of routes. Every group counts
+// requests separately, and strict routes count towards the page limit too.
+var (
+	staticRateLimit = ratelimit.Limit{Requests: 600, Per: time.Minute}
+	pageRateLimit   = ratelimit.Limit{Requests: 120, Per: time.Minute}
+	// strictRateLimit is for routes that create accounts or content, check
+	// passwords or send emails
+	strictRateLimit = ratelimit.Limit{Requests: 10, Per: time.Minute}
 )
 
 // routes sets up the application's HTTP routes and middleware chain
 func (app *application) routes() http.Handler {
+	// Create middleware chain (executed in order: recoverPanic -> logRequest -> commonHeaders)
+	standard := alice.New(app.recoverPanic, app.logRequest, commonHeaders)
+
+	// Wrap the router with the middleware chain
+	return standard.Then(app.router())
+}
+
+// router registers each route with its own middleware
+func (app *application) router() *http.ServeMux {
 	// Create a new router/mux
 	mux := http.NewServeMux()
 
+	staticLimit := app.rateLimit(ratelimit.New(staticRateLimit))
+	pageLimit := app.rateLimit(ratelimit.New(pageRateLimit))
+	strictLimit := app.rateLimit(ratelimit.New(strictRateLimit))
+
 	// Serve static files (CSS, JS, images) from ./ui/static/
 	// StripPrefix removes "/static" from the URL before looking up the file
 	fileServer := http.FileServer(http.Dir("./ui/static/"))
-	mux.Handle("GET /static/", http.StripPrefix("/static", fileServer))
+	mux.Handle("GET /static/", staticLimit(http.StripPrefix("/static", fileServer)))
 
 	//creating a new middleware chain containing the middleware specific to our
 	//dynamic application routes.
-	dynamic := alice.New(app.sessionManager.LoadAndSave, noSurf)
+	dynamic := alice.New(app.sessionManager.LoadAndSave, noSurf, app.authenticate, pageLimit)
 
 	// Application routes
-	mux.Handle("GET /{$}", dynamic.ThenFunc(app.home))                      // Homepage (exact match only)
-	mux.Handle("GET /snippet/view/{id}", dynamic.ThenFunc(app.snippetView)) // View individual snippet
+	mux.Handle("GET /{$}", dynamic.ThenFunc(app.home))                        // Homepage (exact match only)
+	mux.Handle("GET /snippet/view/{slug}", dynamic.ThenFunc(app.snippetView)) // View individual snippet
+	mux.Handle("GET /s/{slug}", dynamic.ThenFunc(app.snippetView))            // Short link to a snippet
+	mux.Handle("GET /snippets", dynamic.ThenFunc(app.snippetArchive))
+	mux.Handle("GET /search", dynamic.ThenFunc(app.search))
+	mux.Handle("GET /tags", dynamic.ThenFunc(app.tagCloud))
+	mux.Handle("GET /tags/{tag}", dynamic.ThenFunc(app.tagView))
+	mux.Handle("GET /snippet/view/{slug}/history", dynamic.ThenFunc(app.snippetHistory))
+	mux.Handle("POST /snippet/view/{slug}/reveal", dynamic.ThenFunc(app.snippetRevealPost))
+	mux.Handle("POST /snippet/view/{slug}/unlock", dynamic.ThenFunc(app.snippetUnlockPost))
+	mux.Handle("GET /u/{handle}", dynamic.ThenFunc(app.userProfile))
+	mux.Handle("GET /u/{handle}/avatar", dynamic.ThenFunc(app.userAvatar))
+
+	// Routes meant for programs as well as browsers also accept personal
+	// access tokens, which skip the CSRF check
+	api := alice.New(app.sessionManager.LoadAndSave, app.authenticateToken, noSurf, app.authenticate, pageLimit)
+	readAPI := api.Append(requireScope(models.ScopeRead))
+	mux.Handle("GET /snippet/view/{slug}/zip", readAPI.ThenFunc(app.snippetZip))
+	mux.Handle("GET /snippet/raw/{slug}", readAPI.ThenFunc(app.snippetRaw))
+	mux.Handle("GET /snippet/download/{slug}", readAPI.ThenFunc(app.snippetDownload))
+
+	// The JSON API. Its routes and its OpenAPI document both come from
+	// apiEndpoints.
+	allowed := map[string][]string{apiPrefix + "/openapi.json": {"GET", "HEAD"}}
+	for _, e := range app.apiEndpoints() {
+		path := apiPrefix + e.Path
+		allowed[path] = append(allowed[path], e.Method)
+		if e.Method == "GET" {
+			allowed[path] = append(allowed[path], "HEAD")
+		}
+
+		chain := api
+		if e.Scope != "" {
+			chain = chain.Append(app.requireAuthentication, requireScope(e.Scope))
+		}
+		if e.Verified {
+			chain = chain.Append(app.requireVerifiedEmail)
+		}
+		if e.Strict {
+			chain = chain.Append(strictLimit)
+		}
+		mux.Handle(e.Method+" "+path, chain.ThenFunc(e.Handler))
+	}
+	mux.Handle("GET "+apiPrefix+"/openapi.json", api.ThenFunc(app.apiOpenAPI))
+	// Other methods on the API's paths would otherwise fall through to the
+	// catch-all below and look like the path doesn't exist. They're answered
+	// before the CSRF check, which would reject them first.
+	for path, methods := range allowed {
+		mux.Handle(path, pageLimit(apiMethodNotAllowed(methods)))
+	}
+	mux.Handle("/api/", api.ThenFunc(app.apiNotFound))
 
 	// user routes
 	mux.Handle("GET /user/signup", dynamic.ThenFunc(app.userSignup))
-	mux.Handle("POST /user/signup", dynamic.ThenFunc(app.userSignupPost))
+	mux.Handle("POST /user/signup", dynamic.Append(strictLimit).ThenFunc(app.userSignupPost))
 	mux.Handle("GET /user/login", dynamic.ThenFunc(app.userLogin))
-	mux.Handle("POST /user/login", dynamic.ThenFunc(app.userLoginPost))
+	mux.Handle("POST /user/login", dynamic.Append(strictLimit).ThenFunc(app.userLoginPost))
+	mux.Handle("GET /user/login/2fa", dynamic.ThenFunc(app.userLoginTwoFactor))
+	mux.Handle("POST /user/login/2fa", dynamic.ThenFunc(app.userLoginTwoFactorPost))
+	mux.Handle("GET /user/password/reset", dynamic.ThenFunc(app.userPasswordReset))
+	mux.Handle("POST /user/password/reset", dynamic.Append(strictLimit).ThenFunc(app.userPasswordResetPost))
+	mux.Handle("GET /user/password/reset/confirm", dynamic.ThenFunc(app.userPasswordResetConfirm))
+	mux.Handle("POST /user/password/reset/confirm", dynamic.ThenFunc(app.userPasswordResetConfirmPost))
+	mux.Handle("GET /user/verify", dynamic.ThenFunc(app.userVerify))
+	mux.Handle("GET /account/email/confirm", dynamic.ThenFunc(app.accountEmailConfirm))
+	mux.Handle("POST /account/email/confirm", dynamic.ThenFunc(app.accountEmailConfirmPost))
 
 	protected := dynamic.Append(app.requireAuthentication)
-	mux.Handle("GET /snippet/create", protected.ThenFunc(app.snippetCreate))
-	mux.Handle("POST /snippet/create", protected.ThenFunc(app.snippetCreatePost))
+
+	// Only users who have verified their email address can create snippets
+	verified := protected.Append(app.requireVerifiedEmail)
+	mux.Handle("GET /snippet/create", verified.ThenFunc(app.snippetCreate))
+	mux.Handle("POST /snippet/create", verified.Append(strictLimit).ThenFunc(app.snippetCreatePost))
+
+	mux.Handle("GET /snippet/edit/{slug}", protected.ThenFunc(app.snippetEdit))
+	mux.Handle("POST /snippet/edit/{slug}", protected.ThenFunc(app.snippetEditPost))
+	mux.Handle("POST /snippet/delete/{slug}", protected.ThenFunc(app.snippetDeletePost))
+	mux.Handle("POST /snippet/restore/{slug}", protected.ThenFunc(app.snippetRestorePost))
+	mux.Handle("POST /snippet/star/{slug}", protected.ThenFunc(app.snippetStarPost))
+	mux.Handle("POST /snippet/unstar/{slug}", protected.ThenFunc(app.snippetUnstarPost))
+	mux.Handle("GET /user/snippets", protected.ThenFunc(app.userSnippets))
+	mux.Handle("GET /user/trash", protected.ThenFunc(app.userTrash))
+	mux.Handle("GET /user/profile", protected.ThenFunc(app.userProfileEdit))
+	mux.Handle("POST /user/profile", protected.ThenFunc(app.userProfileEditPost))
 	mux.Handle("POST /user/logout", protected.ThenFunc(app.userLogoutPost))
+	mux.Handle("POST /user/verify/resend", protected.Append(strictLimit).ThenFunc(app.userVerifyResendPost))
+	mux.Handle("GET /account", protected.ThenFunc(app.account))
+	mux.Handle("GET /account/confirm", protected.ThenFunc(app.accountConfirm))
+	mux.Handle("POST /account/confirm", protected.ThenFunc(app.accountConfirmPost))
+	mux.Handle("GET /account/password", protected.ThenFunc(app.accountPassword))
+	mux.Handle("POST /account/password", protected.ThenFunc(app.accountPasswordPost))
+	mux.Handle("GET /account/tokens", protected.ThenFunc(app.accountTokens))
+	mux.Handle("POST /account/tokens/{id}/revoke", protected.ThenFunc(app.accountTokenRevokePost))
+	mux.Handle("GET /account/2fa/qr", protected.ThenFunc(app.accountTwoFactorQR))
 
-	// Create middleware chain (executed in order: recoverPanic -> logRequest -> commonHeaders)
-	standard := alice.New(app.recoverPanic, app.logRequest, commonHeaders)
+	// Sensitive account changes need the password to have been entered recently
+	sensitive := protected.Append(app.requireReauthentication)
+	mux.Handle("GET /account/email", sensitive.ThenFunc(app.accountEmail))
+	mux.Handle("POST /account/email", sensitive.Append(strictLimit).ThenFunc(app.accountEmailPost))
+	mux.Handle("GET /account/delete", sensitive.ThenFunc(app.accountDelete))
+	mux.Handle("POST /account/delete", sensitive.ThenFunc(app.accountDeletePost))
+	mux.Handle("GET /account/2fa", sensitive.ThenFunc(app.accountTwoFactor))
+	mux.Handle("POST /account/2fa", sensitive.ThenFunc(app.accountTwoFactorPost))
+	mux.Handle("POST /account/2fa/disable", protected.Append(app.requireReauthenticationFrom("/account/2fa")).ThenFunc(app.accountTwoFactorDisablePost))
+	mux.Handle("POST /account/tokens", sensitive.ThenFunc(app.accountTokensPost))
 
-	// Wrap the mux with the middleware chain
-	return standard.Then(mux)
+	return mux
 }
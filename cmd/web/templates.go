@@ -1,35 +1,245 @@
 package main
 
 import (
+	"fmt"
+	"html/template"
+	"net/url"
 	"path/filepath"
-	"text/template"
+	"strings"
 	"time"
+	"unicode"
 
+	"github.com/shaheerkj/snippetbox/internal/diff"
+	"github.com/shaheerkj/snippetbox/internal/highlight"
+	"github.com/shaheerkj/snippetbox/internal/markdown"
 	"github.com/shaheerkj/snippetbox/internal/models"
 )
 
 // templateData holds dynamic data that's passed to HTML templates
 // Provides a consistent structure for all template data
 type templateData struct {
-	Snippet         models.Snippet   // Single snippet (for view page)
-	Snippets        []models.Snippet // Multiple snippets (for home page)
-	CurrentYear     int              // Current year for footer
-	Form            any              // Form data and validation errors
-	Flash           string
-	IsAuthenticated bool
-	CSRFToken       string
+	Snippet             models.Snippet   // Single snippet (for view page)
+	Snippets            []models.Snippet // Multiple snippets (for home page)
+	Profile             models.Profile   // User whose profile is shown
+	User                models.User      // Logged in user (for account pages)
+	PendingEmail        string           // Address the user has asked to change their email to
+	TwoFactorKey        string           // Secret being set up for two-factor authentication, in base32
+	RecoveryCodes       []string         // Shown once, when two-factor authentication is turned on
+	RecoveryCodesLeft   int
+	APITokens           []models.APIToken
+	NewAPIToken         string            // Shown once, right after it's created
+	ShownRevision       int               // Revision of Snippet being displayed
+	ShowSource          bool              // Show Markdown snippets as source rather than rendered
+	Revisions           []models.Revision // Every version of a snippet (for history page)
+	Diff                []fileDiff        // Changes between DiffFrom and DiffTo
+	DiffFrom            int
+	SearchResults       []models.SearchResult
+	Tag                 string // Tag being browsed
+	TagCloud            []tagCloudEntry
+	NewerPage           string // Link to the newer page of a listing, if any
+	OlderPage           string // Link to the older page of a listing, if any
+	PageSize            int
+	PageSizes           []int
+	DiffTo              int
+	CurrentYear         int // Current year for footer
+	Form                any // Form data and validation errors
+	Flash               string
+	IsAuthenticated     bool
+	AuthenticatedUserID int
+	CSRFToken           string
+}
+
+// fileDiff holds the changes to one file between two revisions of a snippet
+type fileDiff struct {
+	Name   string
+	Status string // "added", "removed", or empty for a file in both revisions
+	Hunks  []diff.Hunk
 }
 
 // humanDate formats a time.Time into a human-readable string
 // Uses Go's reference time format: Mon Jan 2 15:04:05 MST 2006
+// Returns an empty string for the zero time, e.g. a snippet that never expires
 func humanDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
 	return t.Format("02 Jan 2006 at 15:04")
 }
 
+// purgeDate returns when a snippet deleted at t will be removed for good
+func purgeDate(t time.Time) time.Time {
+	return t.AddDate(0, 0, models.TrashRetentionDays)
+}
+
+// trustedHTML marks markup generated by the application itself as safe so
+// html/template doesn't escape it. Never pass user-supplied input to this.
+// It's deliberately not in functions, so templates can't reach it.
+func trustedHTML(s string) template.HTML {
+	return template.HTML(s)
+}
+
+// renderMarkdown converts Markdown to HTML. markdown.Render only ever emits
+// allow-listed, escaped markup, so its output can be trusted.
+func renderMarkdown(src string) template.HTML {
+	return trustedHTML(markdown.Render(src))
+}
+
+// snippetURL builds the path to the view page for a snippet
+func snippetURL(slug string) string {
+	return fmt.Sprintf("/snippet/view/%s", slug)
+}
+
+// revisionURL builds the permalink to a specific revision of a snippet
+func revisionURL(slug string, rev int) string {
+	return fmt.Sprintf("/snippet/view/%s?rev=%d", slug, rev)
+}
+
+// lineID builds the anchor for a line of code. Lines of the first file are
+// L1, L2... so links from before snippets had several files keep working;
+// later files are prefixed with their number, e.g. F2-L1.
+func lineID(position, line int) string {
+	if position == 0 {
+		return fmt.Sprintf("L%d", line)
+	}
+	return fmt.Sprintf("F%d-L%d", position+1, line)
+}
+
+// tagCloudEntry is a tag in the tag cloud. Weight runs from 1 for the least
+// used tags to 5 for the most used and sets the size it's shown at.
+type tagCloudEntry struct {
+	models.TagCount
+	Weight int
+}
+
+// newTagCloud weighs tags by how many snippets have them, relative to the
+// most used tag
+func newTagCloud(tags []models.TagCount) []tagCloudEntry {
+	most := 1
+	for _, t := range tags {
+		most = max(most, t.Count)
+	}
+
+	cloud := make([]tagCloudEntry, len(tags))
+	for i, t := range tags {
+		cloud[i] = tagCloudEntry{TagCount: t, Weight: 1 + 4*(t.Count-1)/max(most-1, 1)}
+	}
+	return cloud
+}
+
+// excerptPart is a piece of a search excerpt, marked if it matched the query
+type excerptPart struct {
+	Text  string
+	Match bool
+}
+
+// Search excerpts show a few lines and are cut short on files with very
+// long lines
+const (
+	excerptLines    = 3
+	excerptMaxBytes = 300
+)
+
+// excerpt picks the lines of content starting at the first one containing
+// a word from query, and splits them into parts with the words marked.
+// Matching ignores case, like the FULLTEXT search does.
+func excerpt(content, query string) []excerptPart {
+	terms := strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	})
+
+	lines := strings.Split(content, "\n")
+	start, offset := 0, 0
+	for i, line := range lines {
+		if pos, _ := firstMatch(strings.ToLower(line), terms); pos >= 0 {
+			start = i
+			// Skip ahead on long lines so the match is in view
+			if pos > excerptMaxBytes/2 {
+				offset = pos - excerptMaxBytes/4
+			}
+			break
+		}
+	}
+
+	text := strings.Join(lines[start:min(start+excerptLines, len(lines))], "\n")
+	text = strings.ToValidUTF8(text[min(offset, len(text)):], "")
+	if len(text) > excerptMaxBytes {
+		text = strings.ToValidUTF8(text[:excerptMaxBytes], "") + "…"
+	}
+
+	// Lowercasing some characters changes their length, which would throw
+	// the match positions off, so don't mark anything then
+	lower := strings.ToLower(text)
+	if len(lower) != len(text) {
+		return []excerptPart{{Text: text}}
+	}
+
+	var parts []excerptPart
+	for text != "" {
+		pos, n := firstMatch(lower, terms)
+		if pos < 0 {
+			parts = append(parts, excerptPart{Text: text})
+			break
+		}
+		if pos > 0 {
+			parts = append(parts, excerptPart{Text: text[:pos]})
+		}
+		parts = append(parts, excerptPart{Text: text[pos : pos+n], Match: true})
+		text, lower = text[pos+n:], lower[pos+n:]
+	}
+	return parts
+}
+
+// firstMatch returns the position and length of the earliest of terms in s,
+// preferring the longest term if several start at the same place. The
+// position is -1 if none of them are in s.
+func firstMatch(s string, terms []string) (int, int) {
+	pos, n := -1, 0
+	for _, t := range terms {
+		i := strings.Index(s, t)
+		if i >= 0 && (pos < 0 || i < pos || i == pos && len(t) > n) {
+			pos, n = i, len(t)
+		}
+	}
+	return pos, n
+}
+
+// tagURL builds the path to the listing of snippets with a tag
+func tagURL(tag string) string {
+	return "/tags/" + url.PathEscape(tag)
+}
+
+// profileURL builds the path to a user's public profile
+func profileURL(handle string) string {
+	return "/u/" + url.PathEscape(handle)
+}
+
+// avatarURL builds the path to a user's avatar image
+func avatarURL(handle string) string {
+	return profileURL(handle) + "/avatar"
+}
+
+// pathEscape escapes a value so it can be safely placed in a URL path segment
+func pathEscape(s string) string {
+	return url.PathEscape(s)
+}
+
 // functions is a map of custom functions available in templates
 // Must be registered with template before parsing
 var functions = template.FuncMap{
-	"humanDate": humanDate,
+	"humanDate":     humanDate,
+	"purgeDate":     purgeDate,
+	"markdown":      renderMarkdown,
+	"snippetURL":    snippetURL,
+	"revisionURL":   revisionURL,
+	"lineID":        lineID,
+	"excerpt":       excerpt,
+	"tagURL":        tagURL,
+	"profileURL":    profileURL,
+	"avatarURL":     avatarURL,
+	"pathEscape":    pathEscape,
+	"highlight":     highlight.Lines,
+	"languages":     highlight.Languages,
+	"languageLabel": highlight.Label,
 }
 
 // newTemplateCache parses all templates at application startup and caches them
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shaheerkj/snippetbox/internal/diff"
+	"github.com/shaheerkj/snippetbox/internal/models"
+)
+
+// Payloads that must never reach a page as markup
+var hostileInputs = []string{
+	`<script>alert(1)</script>`,
+	`"><img src=x onerror=alert(1)>`,
+	`javascript:alert(1)`,
+}
+
+// unsafeOutput matches what any of hostileInputs would look like if it got
+// through unescaped: a script tag, an injected element with an event
+// handler, or a javascript: URL in an attribute
+var unsafeOutput = regexp.MustCompile(`(?i)<script>alert|<img src=x|(href|src|action)=['"]?javascript:`)
+
+// pageForms are the forms each page that shows one expects in Form
+func pageForms(h string) map[string]any {
+	files := []snippetFileForm{{Name: h, Language: "go", Content: h}}
+	return map[string]any{
+		"create.html":                 snippetCreateForm{Title: h, Files: files, Tags: h, Expires: h, ExpiresAt: h, Visibility: h},
+		"edit.html":                   snippetEditForm{Title: h, Files: files, Tags: h},
+		"unlock.html":                 snippetUnlockForm{Passphrase: h},
+		"search.html":                 searchForm{Q: h, Language: h, Author: h, From: h, To: h},
+		"signup.html":                 userSignupForm{Name: h, Handle: h, Email: h},
+		"login.html":                  userLoginForm{Email: h},
+		"login-2fa.html":              twoFactorForm{Code: h},
+		"two-factor.html":             twoFactorForm{Code: h},
+		"profile-edit.html":           userProfileForm{Name: h, Handle: h, Bio: h},
+		"confirm.html":                accountConfirmForm{},
+		"password.html":               passwordChangeForm{},
+		"email.html":                  emailChangeForm{Email: h},
+		"email-confirm.html":          emailConfirmForm{Token: h},
+		"delete-account.html":         accountDeleteForm{Snippets: h},
+		"password-reset.html":         passwordResetRequestForm{Email: h},
+		"password-reset-confirm.html": passwordResetForm{Token: h},
+		"tokens.html":                 apiTokenForm{Name: h, Scope: h},
+	}
+}
+
+// withErrors adds h as a field and non-field error to a form, so error
+// messages are covered too
+func withErrors(form any, h string) any {
+	v := reflect.New(reflect.TypeOf(form)).Elem()
+	v.Set(reflect.ValueOf(form))
+	validator := v.FieldByName("Validator")
+	validator.Addr().MethodByName("AddNonFieldError").Call([]reflect.Value{reflect.ValueOf(h)})
+	for i := 0; i < v.NumField(); i++ {
+		if name := v.Type().Field(i).Tag.Get("form"); name != "" && name != "-" {
+			validator.Addr().MethodByName("AddFieldError").Call([]reflect.Value{reflect.ValueOf(name), reflect.ValueOf(h)})
+		}
+	}
+	return v.Interface()
+}
+
+// hostileTemplateData fills every field a page might show with h: titles,
+// file names and contents, tags and author names
+func hostileTemplateData(h string) templateData {
+	snippet := models.Snippet{
+		ID:           1,
+		Slug:         "abcDEF12345",
+		UserID:       1,
+		AuthorName:   h,
+		AuthorHandle: h,
+		Title:        h,
+		Tags:         []string{h, "go"},
+		Visibility:   models.VisibilityPublic,
+		Revision:     2,
+		Created:      time.Now(),
+		Expires:      time.Now().Add(time.Hour),
+		Deleted:      time.Now(),
+		Files: []models.File{
+			{Position: 0, Name: h, Language: "markdown", Content: h + "\n\n[link](" + h + ")"},
+			{Position: 1, Name: h, Language: "go", Content: "package main\n// " + h},
+			{Position: 2, Name: h, Language: "", Content: h},
+		},
+	}
+	user := models.User{ID: 1, Name: h, Handle: h, Email: h, Bio: h, HasAvatar: true}
+
+	return templateData{
+		Snippet:             snippet,
+		Snippets:            []models.Snippet{snippet},
+		Profile:             models.Profile{User: user, Snippets: 1},
+		User:                user,
+		PendingEmail:        h,
+		TwoFactorKey:        h,
+		RecoveryCodes:       []string{h},
+		APITokens:           []models.APIToken{{ID: 1, Name: h, Scope: h, Created: time.Now()}},
+		NewAPIToken:         h,
+		ShownRevision:       2,
+		Revisions:           []models.Revision{{SnippetID: 1, Number: 2, Title: h}, {SnippetID: 1, Number: 1, Title: h}},
+		Diff:                []fileDiff{{Name: h, Hunks: diff.Unified("a\n"+h, h+"\nb", 3)}},
+		DiffFrom:            1,
+		DiffTo:              2,
+		SearchResults:       []models.SearchResult{{Snippet: snippet, FileName: h, FileContent: h}},
+		Tag:                 h,
+		TagCloud:            newTagCloud([]models.TagCount{{Name: h, Count: 2}, {Name: "go", Count: 1}}),
+		NewerPage:           "/snippets?before=1-2",
+		OlderPage:           "/snippets?after=1-1",
+		PageSize:            20,
+		PageSizes:           pageSizes,
+		CurrentYear:         2025,
+		Flash:               h,
+		IsAuthenticated:     true,
+		AuthenticatedUserID: 1,
+		CSRFToken:           h,
+	}
+}
+
+func TestPagesEscapeUserInput(t *testing.T) {
+	// Templates are loaded relative to the root of the repository
+	t.Chdir("../..")
+
+	cache, err := newTemplateCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cache) == 0 {
+		t.Fatal("no pages in the template cache")
+	}
+
+	for name := range pageForms("") {
+		if _, ok := cache[name]; !ok {
+			t.Errorf("form given for %s, which isn't a page", name)
+		}
+	}
+
+	for _, h := range hostileInputs {
+		forms := pageForms(h)
+		for name, ts := range cache {
+			t.Run(name+"/"+h, func(t *testing.T) {
+				data := hostileTemplateData(h)
+				if form, ok := forms[name]; ok {
+					data.Form = withErrors(form, h)
+				}
+
+				var buf bytes.Buffer
+				err := ts.ExecuteTemplate(&buf, "base", data)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if m := unsafeOutput.FindString(buf.String()); m != "" {
+					t.Errorf("unescaped input %q in the page:\n%s", m, surrounding(buf.String(), m))
+				}
+			})
+		}
+	}
+}
+
+// surrounding returns the text of page around the first occurrence of s
+func surrounding(page, s string) string {
+	i := strings.Index(page, s)
+	start := strings.LastIndex(page[:max(i-200, 0)], "\n") + 1
+	end := min(i+len(s)+200, len(page))
+	return page[start:end]
+}
+
+func TestFunctionsReturningHTML(t *testing.T) {
+	// Functions whose output isn't escaped by the page, each of which has
+	// been checked to escape its input itself
+	vetted := map[string]bool{"markdown": true}
+
+	html := reflect.TypeOf(template.HTML(""))
+	for name, fn := range functions {
+		ft := reflect.TypeOf(fn)
+		for i := 0; i < ft.NumOut(); i++ {
+			if ft.Out(i) == html && !vetted[name] {
+				t.Errorf("template function %s returns template.HTML without being vetted", name)
+			}
+		}
+	}
+}
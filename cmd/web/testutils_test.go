@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"html"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/go-playground/form/v4"
+	"github.com/shaheerkj/snippetbox/internal/mailer"
+	"github.com/shaheerkj/snippetbox/internal/models"
+	"github.com/shaheerkj/snippetbox/internal/secretbox"
+	"github.com/shaheerkj/snippetbox/internal/signer"
+	"github.com/shaheerkj/snippetbox/internal/throttle"
+	"github.com/shaheerkj/snippetbox/internal/totp"
+)
+
+// newTestDB connects to the test database named by SNIPPETBOX_TEST_DSN and
+// creates the schema in it, dropping it again when the test ends. Tests
+// that need a database are skipped without one. The DSN needs
+// parseTime=true and multiStatements=true.
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	dsn := os.Getenv("SNIPPETBOX_TEST_DSN")
+	if dsn == "" {
+		t.Skip("SNIPPETBOX_TEST_DSN not set")
+	}
+
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Tests may change directory before the teardown runs
+	dir, err := filepath.Abs(filepath.Join("..", "..", "internal", "models", "testdata"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runScript := func(name string) {
+		script, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = db.Exec(string(script))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Clear out anything a crashed run left behind
+	runScript("teardown.sql")
+	runScript("setup.sql")
+
+	t.Cleanup(func() {
+		defer db.Close()
+		runScript("teardown.sql")
+	})
+
+	return db
+}
+
+// newTestApplication returns an application for tests, with the real
+// templates, sessions kept in memory, and emails written to a temporary
+// outbox. Its models use db, which may be nil for tests that don't reach
+// the database.
+func newTestApplication(t *testing.T, db *sql.DB) *application {
+	t.Helper()
+
+	// Templates and static files are loaded relative to the repository root
+	t.Chdir(filepath.Join("..", ".."))
+
+	templateCache, err := newTemplateCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := bytes.Repeat([]byte{1}, 32)
+	secrets, err := secretbox.New(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessionManager := scs.New()
+	sessionManager.Lifetime = 12 * time.Hour
+	sessionManager.Cookie.Secure = true
+
+	failures := throttle.NewMemoryStore()
+
+	return &application{
+		logger:         slog.New(slog.NewTextHandler(io.Discard, nil)),
+		snippets:       &models.SnippetModel{DB: db},
+		users:          &models.UserModel{DB: db},
+		templateCache:  templateCache,
+		formDecoder:    form.NewDecoder(),
+		sessionManager: sessionManager,
+		mailer:         &mailer.Outbox{Dir: t.TempDir(), From: mail.Address{Name: "Snippetbox", Address: "no-reply@example.com"}},
+		baseURL:        "https://snippetbox.test",
+		signer:         signer.New(key),
+		secrets:        secrets,
+		unverifiedTTL:  7 * 24 * time.Hour,
+		loginByEmail:   &throttle.Throttle{Store: failures, Policy: loginByEmailPolicy},
+		loginByIP:      &throttle.Throttle{Store: failures, Policy: loginByIPPolicy},
+	}
+}
+
+// testServer is a TLS test server with a client that keeps cookies and
+// doesn't follow redirects
+type testServer struct {
+	*httptest.Server
+}
+
+func newTestServer(t *testing.T, h http.Handler) *testServer {
+	t.Helper()
+
+	ts := httptest.NewTLSServer(h)
+	t.Cleanup(ts.Close)
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.Client().Jar = jar
+	ts.Client().CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	return &testServer{ts}
+}
+
+// do sends a request to the server and returns the response with its body
+// read
+func (ts *testServer) do(t *testing.T, req *http.Request) (*http.Response, string) {
+	t.Helper()
+
+	res, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res, string(bytes.TrimSpace(body))
+}
+
+func (ts *testServer) get(t *testing.T, path string) (*http.Response, string) {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ts.do(t, req)
+}
+
+// postForm posts a form to the server, with the CSRF token from the page
+// at the same path added to it
+func (ts *testServer) postForm(t *testing.T, path string, form url.Values) (*http.Response, string) {
+	t.Helper()
+
+	form.Set("csrf_token", ts.csrfToken(t, path))
+	return ts.post(t, path, form)
+}
+
+// csrfToken fetches a page and returns the CSRF token from its form
+func (ts *testServer) csrfToken(t *testing.T, page string) string {
+	t.Helper()
+
+	_, body := ts.get(t, page)
+	return extractCSRFToken(t, body)
+}
+
+// post posts a form to the server as it is, from the server's own origin as
+// a browser would
+func (ts *testServer) post(t *testing.T, path string, form url.Values) (*http.Response, string) {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodPost, ts.URL+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Origin", ts.URL)
+	return ts.do(t, req)
+}
+
+var csrfTokenRX = regexp.MustCompile(`name='csrf_token'\s+value='([^']+)'`)
+
+// extractCSRFToken finds the CSRF token in a page's form
+func extractCSRFToken(t *testing.T, page string) string {
+	t.Helper()
+
+	matches := csrfTokenRX.FindStringSubmatch(page)
+	if len(matches) < 2 {
+		t.Fatal("no CSRF token found in the page")
+	}
+	return html.UnescapeString(matches[1])
+}
+
+// outboxMessages waits briefly for emails sent in the background to reach
+// the outbox and returns them in the order they were sent
+func outboxMessages(t *testing.T, app *application, want int) []*mail.Message {
+	t.Helper()
+
+	dir := app.mailer.(*mailer.Outbox).Dir
+	var files []string
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		var err error
+		files, err = filepath.Glob(filepath.Join(dir, "*.eml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) >= want {
+			break
+		}
+	}
+
+	var messages []*mail.Message
+	for _, name := range files {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg, err := mail.ReadMessage(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		messages = append(messages, msg)
+	}
+	return messages
+}
+
+// newTestUser adds a user with a verified email address to the database and
+// returns their ID
+func newTestUser(t *testing.T, app *application, name, email, password string) int {
+	t.Helper()
+
+	id, err := app.users.Insert(name, name, email, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = app.users.VerifyEmail(id, email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// logIn logs the test server's client in with a password, and a two-factor
+// code from secret if it's not nil
+func (ts *testServer) logIn(t *testing.T, email, password string, secret []byte) {
+	t.Helper()
+
+	res, _ := ts.postForm(t, "/user/login", url.Values{"email": {email}, "password": {password}})
+	if res.StatusCode != http.StatusSeeOther {
+		t.Fatalf("got status %d logging in; want %d", res.StatusCode, http.StatusSeeOther)
+	}
+
+	if secret != nil {
+		res, _ = ts.postForm(t, "/user/login/2fa", url.Values{"code": {totp.Code(secret, time.Now())}})
+		if res.StatusCode != http.StatusSeeOther {
+			t.Fatalf("got status %d entering the two-factor code; want %d", res.StatusCode, http.StatusSeeOther)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Op identifies what happened to a line between two texts
+type Op int
+
+const (
+	Equal Op = iota
+	Insert
+	Delete
+)
+
+// String returns the name of the operation, e.g. for use as a CSS class
+func (o Op) String() string {
+	switch o {
+	case Insert:
+		return "insert"
+	case Delete:
+		return "delete"
+	default:
+		return "equal"
+	}
+}
+
+// Line is a single line of a diff
+type Line struct {
+	Op   Op
+	Text string
+}
+
+// Prefix returns the marker used for the line in a unified diff
+func (l Line) Prefix() string {
+	switch l.Op {
+	case Insert:
+		return "+"
+	case Delete:
+		return "-"
+	default:
+		return " "
+	}
+}
+
+// Hunk is a group of changed lines with surrounding context, in the same
+// shape as a hunk of a unified diff
+type Hunk struct {
+	OldStart, OldLines int
+	NewStart, NewLines int
+	Lines              []Line
+}
+
+// Header returns the "@@ -a,b +c,d @@" line for the hunk
+func (h Hunk) Header() string {
+	return fmt.Sprintf("@@ -%d,%d +%d,%d @@", h.OldStart, h.OldLines, h.NewStart, h.NewLines)
+}
+
+// Lines compares a and b line by line and returns every line of both texts
+// tagged with the operation that turns a into b
+func Lines(a, b string) []Line {
+	x, y := splitLines(a), splitLines(b)
+
+	// lcs[i][j] holds the length of the longest common subsequence of
+	// x[i:] and y[j:]
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var lines []Line
+	i, j := 0, 0
+	for i < len(x) && j < len(y) {
+		switch {
+		case x[i] == y[j]:
+			lines = append(lines, Line{Equal, x[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			lines = append(lines, Line{Delete, x[i]})
+			i++
+		default:
+			lines = append(lines, Line{Insert, y[j]})
+			j++
+		}
+	}
+	for ; i < len(x); i++ {
+		lines = append(lines, Line{Delete, x[i]})
+	}
+	for ; j < len(y); j++ {
+		lines = append(lines, Line{Insert, y[j]})
+	}
+
+	return lines
+}
+
+// Unified compares a and b and groups the changes into hunks, keeping up to
+// context unchanged lines around each change
+func Unified(a, b string, context int) []Hunk {
+	lines := Lines(a, b)
+
+	// Record the old and new line number at the start of every diff line
+	oldNum := make([]int, len(lines))
+	newNum := make([]int, len(lines))
+	o, n := 1, 1
+	var changes []int
+	for i, l := range lines {
+		oldNum[i], newNum[i] = o, n
+		if l.Op != Insert {
+			o++
+		}
+		if l.Op != Delete {
+			n++
+		}
+		if l.Op != Equal {
+			changes = append(changes, i)
+		}
+	}
+
+	var hunks []Hunk
+	for k := 0; k < len(changes); {
+		// Extend the group while the next change is close enough that the
+		// context of the two would overlap
+		first, last := changes[k], changes[k]
+		for k++; k < len(changes) && changes[k]-last <= 2*context; k++ {
+			last = changes[k]
+		}
+
+		start := max(first-context, 0)
+		end := min(last+context+1, len(lines))
+
+		h := Hunk{
+			OldStart: oldNum[start],
+			NewStart: newNum[start],
+			Lines:    lines[start:end],
+		}
+		for _, l := range h.Lines {
+			if l.Op != Insert {
+				h.OldLines++
+			}
+			if l.Op != Delete {
+				h.NewLines++
+			}
+		}
+		// An empty range refers to the line before it, as in GNU diff
+		if h.OldLines == 0 {
+			h.OldStart--
+		}
+		if h.NewLines == 0 {
+			h.NewStart--
+		}
+		hunks = append(hunks, h)
+	}
+
+	return hunks
+}
+
+// splitLines splits s into lines, ignoring a single trailing newline and
+// normalising Windows line endings
+func splitLines(s string) []string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	s = strings.TrimSuffix(s, "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
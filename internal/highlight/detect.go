@@ -0,0 +1,126 @@
+package highlight
+
+import (
+	"encoding/json"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// hint is a pattern that suggests src is written in a language. Each
+// matching hint adds its weight to the language's score.
+type hint struct {
+	lang   string
+	rx     *regexp.Regexp
+	weight int
+}
+
+var hints = []hint{
+	{"go", regexp.MustCompile(`(?m)^package \w+\s*$`), 5},
+	{"go", regexp.MustCompile(`(?m)^func (\(\w+ \*?\w+\) )?\w+\(`), 4},
+	{"go", regexp.MustCompile(`\w+ := `), 2},
+	{"go", regexp.MustCompile(`\bfmt\.\w+\(|\berr != nil\b`), 3},
+
+	{"python", regexp.MustCompile(`(?m)^\s*def \w+\(.*\):\s*$`), 5},
+	{"python", regexp.MustCompile(`(?m)^(from [\w.]+ )?import [\w., ]+$`), 2},
+	{"python", regexp.MustCompile(`\bself\.\w+|\belif\b|__name__`), 3},
+	{"python", regexp.MustCompile(`(?m):\s*$`), 1},
+
+	{"javascript", regexp.MustCompile(`\bfunction\s*\w*\s*\(`), 3},
+	{"javascript", regexp.MustCompile(`\b(const|let) \w+ = `), 3},
+	{"javascript", regexp.MustCompile(`=>|\bconsole\.log\(|\bdocument\.\w+|\brequire\(`), 3},
+
+	{"java", regexp.MustCompile(`\bpublic (static )?(final )?(class|void|interface)\b`), 5},
+	{"java", regexp.MustCompile(`\bSystem\.out\.print|\bimport java\.`), 5},
+
+	{"c", regexp.MustCompile(`(?m)^#include\s*[<"]`), 5},
+	{"c", regexp.MustCompile(`\bint main\s*\(|\bprintf\s*\(|\bmalloc\s*\(`), 3},
+
+	{"rust", regexp.MustCompile(`(?m)^\s*(pub )?fn \w+`), 4},
+	{"rust", regexp.MustCompile(`\blet mut\b|\bprintln!|\bimpl\b|::new\(`), 3},
+
+	{"sql", regexp.MustCompile(`(?im)^\s*(select\s.+\sfrom|insert into|update \w+ set|create (table|database|index)|delete from|alter table)\b`), 6},
+
+	{"bash", regexp.MustCompile(`(?m)^\s*(echo|export|sudo|cd|apt(-get)?|curl|mkdir|chmod) `), 3},
+	{"bash", regexp.MustCompile(`\$\{?\w+\}?|\bfi\b|\bthen\b|\bdone\b`), 1},
+
+	{"html", regexp.MustCompile(`(?i)<!doctype html|<html\b|</(div|p|body|head|span|a|ul|li)>`), 6},
+
+	{"css", regexp.MustCompile(`(?m)^\s*[.#]?[\w-]+(\s*[,>]\s*[.#]?[\w-]+)*\s*\{\s*$`), 3},
+	{"css", regexp.MustCompile(`(?m)^\s*[\w-]+\s*:\s*[^;]+;\s*$`), 2},
+
+	{"markdown", regexp.MustCompile(`(?m)^#{1,6} \S`), 3},
+	{"markdown", regexp.MustCompile("(?m)^```"), 2},
+	{"markdown", regexp.MustCompile(`\[[^\]]+\]\([^)]+\)|\*\*\S[^*]*\*\*`), 2},
+
+	{"yaml", regexp.MustCompile(`(?m)^[\w-]+:(\s+\S.*)?$`), 2},
+	{"yaml", regexp.MustCompile(`(?m)^\s*- \S`), 1},
+	{"yaml", regexp.MustCompile(`(?m)^---\s*$`), 3},
+}
+
+// shebangs maps interpreters named on a #! line to languages
+var shebangs = map[string]string{
+	"bash":    "bash",
+	"sh":      "bash",
+	"zsh":     "bash",
+	"python":  "python",
+	"python3": "python",
+	"node":    "javascript",
+}
+
+// minScore is the lowest score that's trusted; anything less is plain text
+const minScore = 3
+
+// Detect guesses the language of src from a #! line, whether it's valid
+// JSON, and otherwise by scoring it against patterns typical of each
+// language. It returns Plaintext when nothing is convincing.
+func Detect(src string) string {
+	trimmed := strings.TrimSpace(src)
+	if trimmed == "" {
+		return Plaintext
+	}
+
+	if strings.HasPrefix(trimmed, "#!") {
+		fields := strings.Fields(trimmed[2:lineEnd(trimmed)])
+		if len(fields) > 0 {
+			interp := fields[0][strings.LastIndex(fields[0], "/")+1:]
+			// "#!/usr/bin/env python3" names the interpreter second
+			if interp == "env" && len(fields) > 1 {
+				interp = fields[1]
+			}
+			if lang, ok := shebangs[interp]; ok {
+				return lang
+			}
+		}
+	}
+
+	if (trimmed[0] == '{' || trimmed[0] == '[') && json.Valid([]byte(trimmed)) {
+		return "json"
+	}
+
+	scores := map[string]int{}
+	for _, h := range hints {
+		if h.rx.MatchString(src) {
+			scores[h.lang] += h.weight
+		}
+	}
+
+	// Walk languages in display order so ties are broken consistently
+	best, bestScore := Plaintext, minScore-1
+	for _, l := range languages {
+		if scores[l.Name] > bestScore {
+			best, bestScore = l.Name, scores[l.Name]
+		}
+	}
+
+	return best
+}
+
+// DetectFile guesses the language of a named file, trusting a known file
+// name extension over the content
+func DetectFile(name, src string) string {
+	if l, ok := byExtension[strings.ToLower(path.Ext(name))]; ok {
+		return l.Name
+	}
+	return Detect(src)
+}
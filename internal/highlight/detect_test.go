@@ -0,0 +1,82 @@
+package highlight
+
+import "testing"
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"Empty", "  \n", Plaintext},
+		{"Prose", "Remember to buy milk", Plaintext},
+		{"Bash shebang", "#!/bin/bash\nls", "bash"},
+		{"sh shebang", "#!/bin/sh\nls", "bash"},
+		{"zsh shebang", "#!/usr/bin/zsh\nls", "bash"},
+		{"env python3 shebang", "#!/usr/bin/env python3\nx = 1", "python"},
+		{"Python shebang with flags", "#!/usr/bin/python -u\nx = 1", "python"},
+		{"env node shebang", "#!/usr/bin/env node\nx = 1", "javascript"},
+		{"Unknown shebang", "#!/usr/bin/perl\nprint 1;", Plaintext},
+		{"Shebang after blank lines", "\n\n#!/bin/bash\nls", "bash"},
+		{"JSON object", `{"a": [1, 2]}`, "json"},
+		{"JSON array", `[{"a": 1}]`, "json"},
+		{"Invalid JSON", `{"a": }`, Plaintext},
+		{"Go", "package main\n\nfunc main() {\n\tfmt.Println(1)\n}", "go"},
+		{"Python", "def f(x):\n    return x\n\nif __name__ == '__main__':\n    f(1)", "python"},
+		{"JavaScript", "const f = (x) => x;\nconsole.log(f(1));", "javascript"},
+		{"Java", "public class A {\n  public static void main(String[] a) {\n    System.out.println(1);\n  }\n}", "java"},
+		{"C", "#include <stdio.h>\n\nint main(void) {\n  printf(\"hi\");\n}", "c"},
+		{"Rust", "fn main() {\n    let mut x = 1;\n    println!(\"{}\", x);\n}", "rust"},
+		{"SQL", "SELECT id, title FROM snippets WHERE id = 1;", "sql"},
+		{"HTML", "<!DOCTYPE html>\n<html><body><p>hi</p></body></html>", "html"},
+		{"CSS", ".a {\n  color: red;\n}", "css"},
+		{"Markdown", "# Title\n\nSome **bold** text and [a link](https://example.com)", "markdown"},
+		{"YAML", "---\nname: app\nversion: 1", "yaml"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Detect(tt.src); got != tt.want {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDetectFile(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		src  string
+		want string
+	}{
+		{"Extension", "main.go", "", "go"},
+		{"Upper case extension", "MAIN.GO", "", "go"},
+		{"Other extension of a language", "lib.mjs", "", "javascript"},
+		{"Header file", "stdio.h", "", "c"},
+		{"YAML", "config.yml", "", "yaml"},
+		{"Extension beats content", "notes.txt", "package main\n\nfunc main() {}", Plaintext},
+		{"Extension beats shebang", "run.py", "#!/bin/bash\nls", "python"},
+		{"Unknown extension uses content", "run.tool", "#!/bin/bash\nls", "bash"},
+		{"No extension uses content", "Makefile", `{"a": 1}`, "json"},
+		{"Dot file", ".bashrc", "export PATH=$PATH:/opt/bin", "bash"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DetectFile(tt.file, tt.src); got != tt.want {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtensions(t *testing.T) {
+	// Every language's usual extension must be detected as that language,
+	// so downloads open in the right editor mode when uploaded again
+	for _, l := range Languages() {
+		if got := DetectFile("file"+Extension(l.Name), ""); got != l.Name {
+			t.Errorf("file%s detected as %q; want %q", Extension(l.Name), got, l.Name)
+		}
+	}
+}
@@ -0,0 +1,279 @@
+package highlight
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Token classes. Plain text has an empty class.
+const (
+	ClassKeyword = "keyword"
+	ClassType    = "type"
+	ClassString  = "string"
+	ClassNumber  = "number"
+	ClassComment = "comment"
+	ClassFunc    = "func"
+	ClassMeta    = "meta"
+	ClassTag     = "tag"
+)
+
+// Token is a run of source text that's styled the same way
+type Token struct {
+	Class string
+	Text  string
+}
+
+// Line is a single numbered line of highlighted source
+type Line struct {
+	Number int
+	Tokens []Token
+}
+
+// Lines tokenizes src as the named language and splits the result into
+// lines. Unknown languages are treated as plain text. Tokens never contain
+// newlines, so each line can be rendered on its own.
+func Lines(lang, src string) []Line {
+	src = strings.ReplaceAll(src, "\r\n", "\n")
+	src = strings.TrimSuffix(src, "\n")
+
+	l, ok := byName[lang]
+	if !ok {
+		l = byName[Plaintext]
+	}
+
+	lines := []Line{{Number: 1}}
+	for _, tok := range tokenize(l, src) {
+		parts := strings.Split(tok.Text, "\n")
+		for i, part := range parts {
+			if i > 0 {
+				lines = append(lines, Line{Number: len(lines) + 1})
+			}
+			if part != "" {
+				cur := &lines[len(lines)-1]
+				cur.Tokens = append(cur.Tokens, Token{tok.Class, part})
+			}
+		}
+	}
+
+	return lines
+}
+
+// tokenize splits src into tokens according to the rules for l
+func tokenize(l *Language, src string) []Token {
+	var toks []Token
+
+	// emit appends the next text of src as a token, merging it with the
+	// previous one when they share a class to keep the output small. Tokens
+	// cover src in order, so merging re-slices src rather than copying the
+	// token's text every time it grows.
+	pos := 0
+	emit := func(class, text string) {
+		if text == "" {
+			return
+		}
+		if n := len(toks); n > 0 && toks[n-1].Class == class {
+			toks[n-1].Text = src[pos-len(toks[n-1].Text) : pos+len(text)]
+		} else {
+			toks = append(toks, Token{class, src[pos : pos+len(text)]})
+		}
+		pos += len(text)
+	}
+
+	if l.Name == Plaintext {
+		emit("", src)
+		return toks
+	}
+	if l.markup {
+		return tokenizeMarkup(src)
+	}
+
+	lineStart := true
+	for i := 0; i < len(src); {
+		rest := src[i:]
+
+		if rest[0] == '\n' {
+			emit("", "\n")
+			i++
+			lineStart = true
+			continue
+		}
+
+		// Preprocessor directives run to the end of the line
+		if l.preprocessor && lineStart && strings.HasPrefix(strings.TrimLeft(rest, " \t"), "#") {
+			end := lineEnd(rest)
+			emit(ClassMeta, rest[:end])
+			i += end
+			continue
+		}
+		if rest[0] != ' ' && rest[0] != '\t' {
+			lineStart = false
+		}
+
+		if n := matchComment(l, rest); n > 0 {
+			emit(ClassComment, rest[:n])
+			i += n
+			continue
+		}
+
+		if n := matchString(l, rest); n > 0 {
+			emit(ClassString, rest[:n])
+			i += n
+			continue
+		}
+
+		r, size := utf8.DecodeRuneInString(rest)
+
+		if isDigit(rest[0]) {
+			n := 1
+			for n < len(rest) && (isIdent(rune(rest[n])) || rest[n] == '.') {
+				n++
+			}
+			emit(ClassNumber, rest[:n])
+			i += n
+			continue
+		}
+
+		if isIdentStart(r) {
+			n := size
+			for n < len(rest) {
+				r, size := utf8.DecodeRuneInString(rest[n:])
+				if !isIdent(r) {
+					break
+				}
+				n += size
+			}
+			word := rest[:n]
+			emit(classifyWord(l, word, rest[n:]), word)
+			i += n
+			continue
+		}
+
+		emit("", rest[:size])
+		i += size
+	}
+
+	return toks
+}
+
+// matchComment returns the length of the comment at the start of s, or 0
+func matchComment(l *Language, s string) int {
+	for _, prefix := range l.lineComments {
+		if strings.HasPrefix(s, prefix) {
+			return lineEnd(s)
+		}
+	}
+	for _, delims := range l.blockComments {
+		if strings.HasPrefix(s, delims[0]) {
+			end := strings.Index(s[len(delims[0]):], delims[1])
+			if end < 0 {
+				return len(s)
+			}
+			return len(delims[0]) + end + len(delims[1])
+		}
+	}
+	return 0
+}
+
+// matchString returns the length of the string literal at the start of s,
+// or 0. Unterminated literals run to the end of the line, or of the input for
+// multi-line ones.
+func matchString(l *Language, s string) int {
+	for _, d := range l.strings {
+		if !strings.HasPrefix(s, d.open) {
+			continue
+		}
+		for i := len(d.open); i < len(s); {
+			switch {
+			case d.escapes && s[i] == '\\' && i+1 < len(s) && s[i+1] != '\n':
+				i += 2
+			case strings.HasPrefix(s[i:], d.close):
+				return i + len(d.close)
+			case s[i] == '\n' && !d.multiline:
+				return i
+			default:
+				i++
+			}
+		}
+		return len(s)
+	}
+	return 0
+}
+
+// classifyWord decides how to style an identifier. rest is the source that
+// follows it, used to spot function calls.
+func classifyWord(l *Language, word, rest string) string {
+	match := word
+	if l.caseInsensitive {
+		match = strings.ToLower(word)
+	}
+	for _, kw := range l.keywords {
+		if kw == match {
+			return ClassKeyword
+		}
+	}
+	for _, t := range l.types {
+		if t == match {
+			return ClassType
+		}
+	}
+	if strings.HasPrefix(strings.TrimLeft(rest, " "), "(") {
+		return ClassFunc
+	}
+	return ""
+}
+
+// tokenizeMarkup splits HTML-like source into comments, tags and text
+func tokenizeMarkup(src string) []Token {
+	var toks []Token
+	for len(src) > 0 {
+		switch {
+		case strings.HasPrefix(src, "<!--"):
+			end := strings.Index(src, "-->")
+			if end < 0 {
+				end = len(src)
+			} else {
+				end += len("-->")
+			}
+			toks = append(toks, Token{ClassComment, src[:end]})
+			src = src[end:]
+		case src[0] == '<':
+			end := strings.IndexByte(src, '>')
+			if end < 0 {
+				end = len(src)
+			} else {
+				end++
+			}
+			toks = append(toks, Token{ClassTag, src[:end]})
+			src = src[end:]
+		default:
+			end := strings.IndexByte(src, '<')
+			if end < 0 {
+				end = len(src)
+			}
+			toks = append(toks, Token{"", src[:end]})
+			src = src[end:]
+		}
+	}
+	return toks
+}
+
+// lineEnd returns the index of the first newline in s, or len(s)
+func lineEnd(s string) int {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return i
+	}
+	return len(s)
+}
+
+func isDigit(b byte) bool {
+	return b >= '0' && b <= '9'
+}
+
+func isIdentStart(r rune) bool {
+	return r == '_' || r == '$' || unicode.IsLetter(r)
+}
+
+func isIdent(r rune) bool {
+	return isIdentStart(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,179 @@
+package highlight
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// classOf returns the class of the token with exactly the given text in
+// the highlighted lines, and whether there is one
+func classOf(lines []Line, text string) (string, bool) {
+	for _, line := range lines {
+		for _, tok := range line.Tokens {
+			if tok.Text == text {
+				return tok.Class, true
+			}
+		}
+	}
+	return "", false
+}
+
+func TestLines(t *testing.T) {
+	tests := []struct {
+		lang  string
+		src   string
+		text  string // A token Lines should produce
+		class string
+	}{
+		{"bash", `echo "hi $USER"`, `"hi $USER"`, ClassString},
+		{"bash", "echo 'it''s'", "'it''s'", ClassString},
+		{"bash", "x=1 # note", "# note", ClassComment},
+		{"bash", "if true; then", "if", ClassKeyword},
+		{"bash", "echo hi", "echo", ClassType},
+		{"bash", "sleep 10", "10", ClassNumber},
+
+		{"c", `printf("%d\n", x);`, `"%d\n"`, ClassString},
+		{"c", `char c = '\'';`, `'\''`, ClassString},
+		{"c", "x = 1; // note", "// note", ClassComment},
+		{"c", "/* a\nb */", "/* a", ClassComment},
+		{"c", "#include <stdio.h>", "#include <stdio.h>", ClassMeta},
+		{"c", "  #define N 10", "  #define N 10", ClassMeta},
+		{"c", "return 0x1F;", "0x1F", ClassNumber},
+		{"c", "while (x)", "while", ClassKeyword},
+		{"c", "unsigned int x;", "unsigned", ClassType},
+		{"c", "foo (1);", "foo", ClassFunc},
+
+		{"css", `a { content: "x"; }`, `"x"`, ClassString},
+		{"css", "/* note */ a {}", "/* note */", ClassComment},
+		{"css", "@media screen", "media", ClassKeyword},
+		{"css", "width: 100px;", "100px", ClassNumber},
+
+		{"go", `fmt.Println("a \"b\"")`, `"a \"b\""`, ClassString},
+		{"go", "x := `raw\n\\n`", "`raw", ClassString},
+		{"go", "r := 'x'", "'x'", ClassString},
+		{"go", "x := 1 // note", "// note", ClassComment},
+		{"go", "/* a */ x", "/* a */", ClassComment},
+		{"go", "x := 3.14", "3.14", ClassNumber},
+		{"go", "x := 1_000", "1_000", ClassNumber},
+		{"go", "func main() {", "func", ClassKeyword},
+		{"go", "var s string", "string", ClassType},
+		{"go", "x := nil", "nil", ClassType},
+		{"go", "doThing()", "doThing", ClassFunc},
+		{"go", "identifier", "identifier", ""},
+		{"go", "#notmeta", "#notmeta", ""},
+
+		{"html", "<p class=\"a\">", "<p class=\"a\">", ClassTag},
+		{"html", "<!-- note -->", "<!-- note -->", ClassComment},
+		{"html", "<b>text</b>", "text", ""},
+
+		{"java", `String s = "hi";`, `"hi"`, ClassString},
+		{"java", "String s = \"\"\"\na\n\"\"\";", `"""`, ClassString},
+		{"java", "// note", "// note", ClassComment},
+		{"java", "int x = 42;", "42", ClassNumber},
+		{"java", "public class A {", "public", ClassKeyword},
+		{"java", "Integer x;", "Integer", ClassType},
+
+		{"javascript", "const s = `a ${b}`;", "`a ${b}`", ClassString},
+		{"javascript", `let s = 'a\'b';`, `'a\'b'`, ClassString},
+		{"javascript", "// note", "// note", ClassComment},
+		{"javascript", "x = 10", "10", ClassNumber},
+		{"javascript", "async function f() {}", "async", ClassKeyword},
+		{"javascript", "$(el)", "$", ClassFunc},
+		{"javascript", "console.log(x)", "console", ClassType},
+		{"javascript", "console.log(x)", "log", ClassFunc},
+
+		{"json", `{"a": "b"}`, `"a"`, ClassString},
+		{"json", `{"a": 12}`, "12", ClassNumber},
+		{"json", `{"a": null}`, "null", ClassType},
+
+		{"markdown", "Use `go test` here", "`go test`", ClassString},
+
+		{"python", `print("hi")`, `"hi"`, ClassString},
+		{"python", "s = '''a\nb'''", "'''a", ClassString},
+		{"python", `s = """doc"""`, `"""doc"""`, ClassString},
+		{"python", "x = 1  # note", "# note", ClassComment},
+		{"python", "x = 1e10", "1e10", ClassNumber},
+		{"python", "def f(self):", "def", ClassKeyword},
+		{"python", "x = None", "None", ClassType},
+
+		{"rust", `let s = "hi";`, `"hi"`, ClassString},
+		{"rust", "// note", "// note", ClassComment},
+		{"rust", "let x = 5u32;", "5u32", ClassNumber},
+		{"rust", "let mut x = 1;", "mut", ClassKeyword},
+		{"rust", "let v: Vec<i32>;", "Vec", ClassType},
+
+		{"sql", "SELECT 'a' FROM t", "'a'", ClassString},
+		{"sql", "SELECT `col` FROM t", "`col`", ClassString},
+		{"sql", "SELECT 1 -- note", "-- note", ClassComment},
+		{"sql", "SELECT 1 # note", "# note", ClassComment},
+		{"sql", "/* note */ SELECT 1", "/* note */", ClassComment},
+		{"sql", "LIMIT 20", "20", ClassNumber},
+		{"sql", "SELECT * FROM t", "SELECT", ClassKeyword},
+		{"sql", "select * from t", "select", ClassKeyword},
+		{"sql", "x VARCHAR(10)", "VARCHAR", ClassType},
+
+		{"yaml", `name: "x"`, `"x"`, ClassString},
+		{"yaml", "name: x # note", "# note", ClassComment},
+		{"yaml", "port: 8080", "8080", ClassNumber},
+		{"yaml", "debug: true", "true", ClassType},
+
+		{"plaintext", `func "x" // 1`, `func "x" // 1`, ""},
+		{"unknown", `func "x" // 1`, `func "x" // 1`, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.lang+"/"+tt.src, func(t *testing.T) {
+			lines := Lines(tt.lang, tt.src)
+			class, ok := classOf(lines, tt.text)
+			if !ok {
+				t.Fatalf("no %q token in %+v", tt.text, lines)
+			}
+			if class != tt.class {
+				t.Errorf("%q has class %q; want %q", tt.text, class, tt.class)
+			}
+		})
+	}
+}
+
+func TestLinesSplitsTokens(t *testing.T) {
+	src := "/* a\nb */\r\nx := \"\"\n"
+	lines := Lines("go", src)
+
+	if len(lines) != 3 {
+		t.Fatalf("got %d lines; want 3", len(lines))
+	}
+	var text []string
+	for i, line := range lines {
+		if line.Number != i+1 {
+			t.Errorf("line %d is numbered %d", i+1, line.Number)
+		}
+		var b strings.Builder
+		for _, tok := range line.Tokens {
+			if strings.Contains(tok.Text, "\n") {
+				t.Errorf("token %q contains a newline", tok.Text)
+			}
+			b.WriteString(tok.Text)
+		}
+		text = append(text, b.String())
+	}
+	if got, want := strings.Join(text, "\n"), "/* a\nb */\nx := \"\""; got != want {
+		t.Errorf("lines rejoin as %q; want %q", got, want)
+	}
+}
+
+// TestLinesTime checks that long runs of one kind of token, which are
+// merged into a single token, take time linear in their length
+func TestLinesTime(t *testing.T) {
+	for _, unit := range []string{"+", "a ", "\n", "1 ", "x("} {
+		t.Run(unit, func(t *testing.T) {
+			src := strings.Repeat(unit, 200000/len(unit))
+
+			start := time.Now()
+			Lines("go", src)
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("took %s to highlight %d bytes", elapsed, len(src))
+			}
+		})
+	}
+}
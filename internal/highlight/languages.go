@@ -0,0 +1,248 @@
+package highlight
+
+// Language describes a language the highlighter understands
+type Language struct {
+	Name  string // Identifier stored with snippets, e.g. "go"
+	Label string // Human-readable name for the language picker
+
+	extensions []string // File name extensions, including the dot, the usual one first
+
+	keywords      []string
+	types         []string // Builtin types, constants and functions
+	lineComments  []string
+	blockComments [][2]string
+	strings       []stringDelim
+	// caseInsensitive languages match keywords regardless of case (SQL)
+	caseInsensitive bool
+	// preprocessor languages treat lines starting with # as directives (C)
+	preprocessor bool
+	// markup languages are tokenized as tags, comments and text (HTML)
+	markup bool
+}
+
+// stringDelim describes one kind of string literal
+type stringDelim struct {
+	open, close string
+	escapes     bool // Whether a backslash escapes the next character
+	multiline   bool // Whether the literal can span lines
+}
+
+var (
+	doubleQuoted = stringDelim{`"`, `"`, true, false}
+	singleQuoted = stringDelim{`'`, `'`, true, false}
+	backtickRaw  = stringDelim{"`", "`", false, true}
+)
+
+// Plaintext is the name used for snippets with no highlighting
+const Plaintext = "plaintext"
+
+// languages lists every supported language in the order they're offered in
+// the language picker
+var languages = []*Language{
+	{
+		Name:       Plaintext,
+		Label:      "Plain text",
+		extensions: []string{".txt"},
+	},
+	{
+		Name:       "bash",
+		Label:      "Bash",
+		extensions: []string{".sh", ".bash"},
+		keywords: []string{"if", "then", "else", "elif", "fi", "case", "esac", "for", "while", "until",
+			"do", "done", "in", "function", "select", "return", "local", "export", "readonly", "declare"},
+		types: []string{"echo", "printf", "read", "cd", "pwd", "exit", "set", "unset", "shift", "source",
+			"test", "true", "false", "eval", "exec", "trap"},
+		lineComments: []string{"#"},
+		strings:      []stringDelim{doubleQuoted, {`'`, `'`, false, true}},
+	},
+	{
+		Name:       "c",
+		Label:      "C",
+		extensions: []string{".c", ".h"},
+		keywords: []string{"auto", "break", "case", "const", "continue", "default", "do", "else", "enum",
+			"extern", "for", "goto", "if", "inline", "register", "restrict", "return", "sizeof", "static",
+			"struct", "switch", "typedef", "union", "volatile", "while"},
+		types: []string{"char", "double", "float", "int", "long", "short", "signed", "unsigned", "void",
+			"bool", "size_t", "NULL", "true", "false"},
+		lineComments:  []string{"//"},
+		blockComments: [][2]string{{"/*", "*/"}},
+		strings:       []stringDelim{doubleQuoted, singleQuoted},
+		preprocessor:  true,
+	},
+	{
+		Name:          "css",
+		Label:         "CSS",
+		extensions:    []string{".css"},
+		keywords:      []string{"important", "media", "import", "keyframes", "font-face", "supports"},
+		blockComments: [][2]string{{"/*", "*/"}},
+		strings:       []stringDelim{doubleQuoted, singleQuoted},
+	},
+	{
+		Name:       "go",
+		Label:      "Go",
+		extensions: []string{".go"},
+		keywords: []string{"break", "case", "chan", "const", "continue", "default", "defer", "else",
+			"fallthrough", "for", "func", "go", "goto", "if", "import", "interface", "map", "package",
+			"range", "return", "select", "struct", "switch", "type", "var"},
+		types: []string{"any", "bool", "byte", "comparable", "complex64", "complex128", "error", "float32",
+			"float64", "int", "int8", "int16", "int32", "int64", "rune", "string", "uint", "uint8",
+			"uint16", "uint32", "uint64", "uintptr", "true", "false", "iota", "nil", "append", "cap",
+			"clear", "close", "copy", "delete", "len", "make", "max", "min", "new", "panic", "print",
+			"println", "recover"},
+		lineComments:  []string{"//"},
+		blockComments: [][2]string{{"/*", "*/"}},
+		strings:       []stringDelim{doubleQuoted, singleQuoted, backtickRaw},
+	},
+	{
+		Name:       "html",
+		Label:      "HTML",
+		extensions: []string{".html", ".htm"},
+		markup:     true,
+	},
+	{
+		Name:       "java",
+		Label:      "Java",
+		extensions: []string{".java"},
+		keywords: []string{"abstract", "assert", "break", "case", "catch", "class", "continue", "default",
+			"do", "else", "enum", "extends", "final", "finally", "for", "if", "implements", "import",
+			"instanceof", "interface", "native", "new", "package", "private", "protected", "public",
+			"return", "static", "super", "switch", "synchronized", "this", "throw", "throws", "try",
+			"var", "void", "volatile", "while"},
+		types: []string{"boolean", "byte", "char", "double", "float", "int", "long", "short", "String",
+			"Object", "Integer", "true", "false", "null"},
+		lineComments:  []string{"//"},
+		blockComments: [][2]string{{"/*", "*/"}},
+		strings:       []stringDelim{{`"""`, `"""`, true, true}, doubleQuoted, singleQuoted},
+	},
+	{
+		Name:       "javascript",
+		Label:      "JavaScript",
+		extensions: []string{".js", ".mjs", ".cjs"},
+		keywords: []string{"async", "await", "break", "case", "catch", "class", "const", "continue",
+			"debugger", "default", "delete", "do", "else", "export", "extends", "finally", "for", "from",
+			"function", "if", "import", "in", "instanceof", "let", "new", "of", "return", "static",
+			"super", "switch", "this", "throw", "try", "typeof", "var", "void", "while", "yield"},
+		types: []string{"true", "false", "null", "undefined", "NaN", "Infinity", "console", "window",
+			"document", "Object", "Array", "String", "Number", "Boolean", "Promise", "JSON", "Math"},
+		lineComments:  []string{"//"},
+		blockComments: [][2]string{{"/*", "*/"}},
+		strings:       []stringDelim{doubleQuoted, singleQuoted, {"`", "`", true, true}},
+	},
+	{
+		Name:       "json",
+		Label:      "JSON",
+		extensions: []string{".json"},
+		types:      []string{"true", "false", "null"},
+		strings:    []stringDelim{doubleQuoted},
+	},
+	{
+		Name:       "markdown",
+		Label:      "Markdown",
+		extensions: []string{".md", ".markdown"},
+		strings:    []stringDelim{{"`", "`", false, false}},
+	},
+	{
+		Name:       "python",
+		Label:      "Python",
+		extensions: []string{".py"},
+		keywords: []string{"and", "as", "assert", "async", "await", "break", "class", "continue", "def",
+			"del", "elif", "else", "except", "finally", "for", "from", "global", "if", "import", "in",
+			"is", "lambda", "nonlocal", "not", "or", "pass", "raise", "return", "try", "while", "with",
+			"yield"},
+		types: []string{"True", "False", "None", "self", "int", "float", "str", "bool", "list", "dict",
+			"set", "tuple", "bytes", "print", "len", "range", "open", "super", "isinstance", "enumerate",
+			"zip", "map", "filter"},
+		lineComments: []string{"#"},
+		strings: []stringDelim{{`"""`, `"""`, true, true}, {`'''`, `'''`, true, true},
+			doubleQuoted, singleQuoted},
+	},
+	{
+		Name:       "rust",
+		Label:      "Rust",
+		extensions: []string{".rs"},
+		keywords: []string{"as", "async", "await", "break", "const", "continue", "crate", "dyn", "else",
+			"enum", "extern", "fn", "for", "if", "impl", "in", "let", "loop", "match", "mod", "move",
+			"mut", "pub", "ref", "return", "self", "Self", "static", "struct", "super", "trait", "type",
+			"unsafe", "use", "where", "while"},
+		types: []string{"bool", "char", "f32", "f64", "i8", "i16", "i32", "i64", "i128", "isize", "str",
+			"u8", "u16", "u32", "u64", "u128", "usize", "String", "Vec", "Option", "Result", "Some",
+			"None", "Ok", "Err", "Box", "true", "false"},
+		lineComments:  []string{"//"},
+		blockComments: [][2]string{{"/*", "*/"}},
+		strings:       []stringDelim{doubleQuoted},
+	},
+	{
+		Name:       "sql",
+		Label:      "SQL",
+		extensions: []string{".sql"},
+		keywords: []string{"add", "alter", "and", "as", "asc", "between", "by", "case", "constraint",
+			"create", "database", "default", "delete", "desc", "distinct", "drop", "else", "end", "exists",
+			"foreign", "from", "group", "having", "if", "in", "index", "inner", "insert", "into", "is",
+			"join", "key", "left", "like", "limit", "not", "null", "on", "or", "order", "outer",
+			"primary", "references", "right", "select", "set", "table", "then", "union", "unique",
+			"update", "values", "when", "where"},
+		types: []string{"int", "integer", "bigint", "smallint", "tinyint", "varchar", "char", "text",
+			"blob", "date", "datetime", "timestamp", "boolean", "decimal", "float", "double", "count",
+			"sum", "avg", "min", "max", "coalesce", "now", "true", "false"},
+		lineComments:    []string{"--", "#"},
+		blockComments:   [][2]string{{"/*", "*/"}},
+		strings:         []stringDelim{singleQuoted, doubleQuoted, {"`", "`", false, false}},
+		caseInsensitive: true,
+	},
+	{
+		Name:         "yaml",
+		Label:        "YAML",
+		extensions:   []string{".yaml", ".yml"},
+		types:        []string{"true", "false", "null", "yes", "no", "on", "off"},
+		lineComments: []string{"#"},
+		strings:      []stringDelim{doubleQuoted, {`'`, `'`, false, false}},
+	},
+}
+
+// byName indexes languages by their Name
+var byName = map[string]*Language{}
+
+// byExtension indexes languages by file name extension
+var byExtension = map[string]*Language{}
+
+func init() {
+	for _, l := range languages {
+		byName[l.Name] = l
+		for _, ext := range l.extensions {
+			byExtension[ext] = l
+		}
+	}
+}
+
+// Languages returns every supported language in display order
+func Languages() []Language {
+	list := make([]Language, len(languages))
+	for i, l := range languages {
+		list[i] = *l
+	}
+	return list
+}
+
+// Supported reports whether name is a known language
+func Supported(name string) bool {
+	_, ok := byName[name]
+	return ok
+}
+
+// Label returns the human-readable name of a language, falling back to plain
+// text for unknown names
+func Label(name string) string {
+	if l, ok := byName[name]; ok {
+		return l.Label
+	}
+	return byName[Plaintext].Label
+}
+
+// Extension returns the usual file name extension for a language, including
+// the dot, falling back to the plain text one for unknown names
+func Extension(name string) string {
+	if l, ok := byName[name]; ok {
+		return l.extensions[0]
+	}
+	return byName[Plaintext].extensions[0]
+}
@@ -0,0 +1,43 @@
+package mailer
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Outbox writes each message to its own .eml file in Dir instead of sending
+// it, for local development and tests
+type Outbox struct {
+	Dir  string
+	From mail.Address
+}
+
+// Send writes msg to a new file in the outbox directory, creating the
+// directory if needed
+func (m *Outbox) Send(msg Message) error {
+	now := time.Now()
+	data, err := format(m.From, msg, now)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(m.Dir, 0o700)
+	if err != nil {
+		return err
+	}
+
+	// Name files by time so they sort in the order they were sent
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s.eml", now.UTC().Format("20060102T150405.000000000"), hex.EncodeToString(suffix))
+
+	// Messages hold live tokens, so keep them private to the user
+	return os.WriteFile(filepath.Join(m.Dir, name), data, 0o600)
+}
@@ -0,0 +1,39 @@
+package mailer
+
+import (
+	"net"
+	"net/mail"
+	"net/smtp"
+	"time"
+)
+
+// SMTP sends messages through an SMTP server. The connection is upgraded
+// with STARTTLS when the server offers it, and credentials are only sent
+// over TLS or to localhost, as net/smtp enforces.
+type SMTP struct {
+	Addr     string // host:port of the server
+	Username string // Leave empty to send without authenticating
+	Password string
+	From     mail.Address
+}
+
+// Send delivers msg through the server
+func (m *SMTP) Send(msg Message) error {
+	data, err := format(m.From, msg, time.Now())
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		host, _, err := net.SplitHostPort(m.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", m.Username, m.Password, host)
+	}
+
+	// The envelope sender is the bare address; the name is only for the
+	// From header
+	return smtp.SendMail(m.Addr, auth, m.From.Address, []string{msg.To}, data)
+}
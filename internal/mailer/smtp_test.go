@@ -0,0 +1,122 @@
+package mailer
+
+import (
+	"bufio"
+	"net"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSMTPServer accepts one connection on a local port, answers it as an
+// SMTP server that offers no extensions, and sends the commands and message
+// data it received on the returned channel
+func fakeSMTPServer(t *testing.T) (string, <-chan []string) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	received := make(chan []string, 1)
+	go func() {
+		var lines []string
+		defer func() { received <- lines }()
+
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+
+		reply("220 localhost ESMTP")
+		inData := false
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			lines = append(lines, line)
+
+			switch {
+			case inData && line == ".":
+				inData = false
+				reply("250 OK")
+			case inData:
+			case strings.HasPrefix(line, "EHLO"), strings.HasPrefix(line, "HELO"):
+				reply("250 localhost")
+			case line == "DATA":
+				inData = true
+				reply("354 Go ahead")
+			case line == "QUIT":
+				reply("221 Bye")
+				return
+			default:
+				reply("250 OK")
+			}
+		}
+	}()
+
+	return ln.Addr().String(), received
+}
+
+func TestSMTPSend(t *testing.T) {
+	addr, received := fakeSMTPServer(t)
+
+	m := &SMTP{Addr: addr, From: mail.Address{Name: "Snippetbox", Address: "no-reply@example.com"}}
+	err := m.Send(Message{To: "alice@example.com", Subject: "Hello", Body: "Hi Alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := <-received
+	want := []string{
+		"MAIL FROM:<no-reply@example.com>",
+		"RCPT TO:<alice@example.com>",
+		`From: "Snippetbox" <no-reply@example.com>`,
+		"To: alice@example.com",
+		"Subject: Hello",
+		"Hi Alice",
+	}
+	for _, w := range want {
+		found := false
+		for _, l := range lines {
+			// The client may add parameters such as BODY=8BITMIME
+			if l == w || strings.HasPrefix(l, w+" ") {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("server didn't receive %q; got:\n%s", w, strings.Join(lines, "\n"))
+		}
+	}
+}
+
+func TestFormatRejectsHeaderInjection(t *testing.T) {
+	from := mail.Address{Address: "no-reply@example.com"}
+
+	tests := []struct {
+		name string
+		msg  Message
+	}{
+		{"To", Message{To: "alice@example.com\r\nBcc: eve@example.com", Subject: "Hello"}},
+		{"Subject", Message{To: "alice@example.com", Subject: "Hello\nBcc: eve@example.com"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := format(from, tt.msg, time.Now())
+			if err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,401 @@
+package markdown
+
+import (
+	"html"
+	"net/url"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxDestinationParens is how deeply parentheses can be nested in a link
+// destination, as in CommonMark. Without a limit, a [ followed by many
+// "](" would make every one of them search the rest of the text for the end
+// of its destination.
+const maxDestinationParens = 32
+
+// inline is the text of a block, or part of one, with the positions its
+// emphasis and links could end at worked out in a single pass. Looking them
+// up instead of searching for them keeps rendering linear in the length of
+// the text.
+type inline struct {
+	s     string
+	depth int // How many emphasis and link elements it's nested in
+
+	// closers holds the positions of the delimiter runs that can close
+	// emphasis, in order, by delimiter (* then _) and run length
+	closers [2][3][]int
+	// brackets maps each [ to the ] closing it, allowing nested brackets
+	brackets map[int]int
+}
+
+// renderInline renders the inline content of a block: emphasis, code spans,
+// links and line breaks. Everything else is escaped text.
+func renderInline(b *strings.Builder, s string) {
+	newInline(s, 0).render(b)
+}
+
+// newInline finds where emphasis and links in s could end, for text nested
+// depth elements deep
+func newInline(s string, depth int) *inline {
+	t := &inline{s: s, depth: depth, brackets: make(map[int]int)}
+
+	var open []int
+	for j := 0; j < len(s); j++ {
+		switch s[j] {
+		case '\\':
+			j++
+		case '[':
+			open = append(open, j)
+		case ']':
+			if len(open) > 0 {
+				t.brackets[open[len(open)-1]] = j
+				open = open[:len(open)-1]
+			}
+		}
+	}
+
+	// Delimiter runs are found without regard to backslashes, so an escaped
+	// delimiter can still close emphasis
+	for j := 0; j < len(s); j++ {
+		if s[j] != '*' && s[j] != '_' {
+			continue
+		}
+		n := runLength(s[j:], s[j])
+		// A closer must follow text and be at most three long, and
+		// underscores can't close inside a word
+		if n <= 3 && j > 0 && s[j-1] != ' ' && s[j-1] != '\n' &&
+			!(s[j] == '_' && j+n < len(s) && isWordByte(s[j+n])) {
+			d := delimIndex(s[j])
+			t.closers[d][n-1] = append(t.closers[d][n-1], j)
+		}
+		j += n - 1
+	}
+
+	return t
+}
+
+// nested returns part of t's text as it appears inside an element, or nil
+// if it would be nested too deeply to parse
+func (t *inline) nested(s string) *inline {
+	if t.depth+1 >= maxNesting {
+		return nil
+	}
+	return newInline(s, t.depth+1)
+}
+
+// render renders t's text as escaped text and inline elements
+func (t *inline) render(b *strings.Builder) {
+	s := t.s
+	for i := 0; i < len(s); {
+		c := s[i]
+
+		switch {
+		// A backslash escapes punctuation, or makes a hard line break
+		case c == '\\' && i+1 < len(s):
+			if s[i+1] == '\n' {
+				b.WriteString("<br>\n")
+				i += 2
+				continue
+			}
+			if isPunct(s[i+1]) {
+				b.WriteString(html.EscapeString(s[i+1 : i+2]))
+				i += 2
+				continue
+			}
+
+		case c == '`':
+			if n := renderCodeSpan(b, s[i:]); n > 0 {
+				i += n
+				continue
+			}
+			// An unmatched run of backticks is literal text
+			n := runLength(s[i:], '`')
+			b.WriteString(s[i : i+n])
+			i += n
+			continue
+
+		case c == '!' && strings.HasPrefix(s[i+1:], "["):
+			// Images would be blocked by the Content-Security-Policy, so
+			// they're rendered as links to the image instead
+			if n := t.renderLink(b, i+1); n > 0 {
+				i += 1 + n
+				continue
+			}
+
+		case c == '[':
+			if n := t.renderLink(b, i); n > 0 {
+				i += n
+				continue
+			}
+
+		case c == '<':
+			if n := renderAutolink(b, s[i:]); n > 0 {
+				i += n
+				continue
+			}
+
+		case c == '*' || c == '_':
+			if n := t.renderEmphasis(b, i); n > 0 {
+				i += n
+				continue
+			}
+			// Copy the whole run so its closing half isn't matched later
+			n := runLength(s[i:], c)
+			b.WriteString(s[i : i+n])
+			i += n
+			continue
+
+		// Two or more spaces at the end of a line make a hard line break
+		case c == ' ':
+			n := runLength(s[i:], ' ')
+			if n >= 2 && strings.HasPrefix(s[i+n:], "\n") {
+				b.WriteString("<br>\n")
+				i += n + 1
+				continue
+			}
+		}
+
+		_, size := utf8.DecodeRuneInString(s[i:])
+		b.WriteString(html.EscapeString(s[i : i+size]))
+		i += size
+	}
+}
+
+// renderCodeSpan renders the code span at the start of s, returning its
+// length or 0 if the backticks aren't closed
+func renderCodeSpan(b *strings.Builder, s string) int {
+	n := len(s) - len(strings.TrimLeft(s, "`"))
+	fence := s[:n]
+
+	for j := n; j < len(s); {
+		k := strings.Index(s[j:], fence)
+		if k < 0 {
+			return 0
+		}
+		end := j + k
+		// The closing run must be exactly as long as the opening one
+		if end+n < len(s) && s[end+n] == '`' {
+			j = end + n + (len(s[end+n:]) - len(strings.TrimLeft(s[end+n:], "`")))
+			continue
+		}
+		code := strings.ReplaceAll(s[n:end], "\n", " ")
+		if len(code) > 2 && code[0] == ' ' && code[len(code)-1] == ' ' {
+			code = code[1 : len(code)-1]
+		}
+		b.WriteString("<code>" + html.EscapeString(code) + "</code>")
+		return end + n
+	}
+	return 0
+}
+
+// renderLink renders a [text](destination "title") link starting at the [
+// at t.s[i], returning its length or 0 if it isn't a well-formed link. Links
+// with unsafe destinations are rendered as plain text.
+func (t *inline) renderLink(b *strings.Builder, i int) int {
+	s := t.s
+	end, ok := t.brackets[i]
+	if !ok || !strings.HasPrefix(s[end+1:], "(") {
+		return 0
+	}
+	text := t.nested(s[i+1 : end])
+	if text == nil {
+		return 0
+	}
+
+	dest, n := parseDestination(s[end+2:])
+	if n < 0 {
+		return 0
+	}
+
+	href, ok := safeURL(dest)
+	if !ok {
+		text.render(b)
+		return end + 2 + n - i
+	}
+
+	b.WriteString(`<a href="` + html.EscapeString(href) + `" rel="nofollow noopener">`)
+	text.render(b)
+	b.WriteString("</a>")
+	return end + 2 + n - i
+}
+
+// parseDestination parses `destination "title")` and returns the destination
+// and the number of bytes consumed including the closing parenthesis, or -1
+// if it's malformed. The title is accepted but not used. Each search for the
+// end of a part stops at the next character that would start another, so
+// failed attempts can't add up to more than the length of s.
+func parseDestination(s string) (string, int) {
+	i := len(s) - len(strings.TrimLeft(s, " "))
+
+	var dest string
+	if strings.HasPrefix(s[i:], "<") {
+		end := strings.IndexAny(s[i+1:], "<>\n") + 1
+		if end == 0 || s[i+end] != '>' {
+			return "", -1
+		}
+		dest = s[i+1 : i+end]
+		i += end + 1
+	} else {
+		// Balanced parentheses are allowed inside the destination
+		start, depth := i, 0
+	loop:
+		for ; i < len(s); i++ {
+			switch s[i] {
+			case ' ', '\n':
+				break loop
+			case '(':
+				depth++
+				if depth > maxDestinationParens {
+					return "", -1
+				}
+			case ')':
+				if depth == 0 {
+					break loop
+				}
+				depth--
+			}
+		}
+		dest = s[start:i]
+	}
+
+	i += len(s[i:]) - len(strings.TrimLeft(s[i:], " \n"))
+	if i < len(s) && (s[i] == '"' || s[i] == '\'') {
+		end := strings.IndexByte(s[i+1:], s[i])
+		if end < 0 {
+			return "", -1
+		}
+		i += end + 2
+		i += len(s[i:]) - len(strings.TrimLeft(s[i:], " \n"))
+	}
+
+	if i >= len(s) || s[i] != ')' {
+		return "", -1
+	}
+	return dest, i + 1
+}
+
+// renderAutolink renders a <scheme:...> autolink at the start of s, returning
+// its length or 0 if it isn't one
+func renderAutolink(b *strings.Builder, s string) int {
+	end := strings.IndexAny(s[1:], "<> \n")
+	if end < 0 || s[1+end] != '>' {
+		return 0
+	}
+	target := s[1 : 1+end]
+	if !strings.Contains(target, ":") {
+		return 0
+	}
+	href, ok := safeURL(target)
+	if !ok {
+		return 0
+	}
+	b.WriteString(`<a href="` + html.EscapeString(href) + `" rel="nofollow noopener">` + html.EscapeString(target) + "</a>")
+	return end + 2
+}
+
+// renderEmphasis renders *em*, **strong** or ***both*** starting at t.s[i],
+// returning the length consumed or 0 if there's no matching closer
+func (t *inline) renderEmphasis(b *strings.Builder, i int) int {
+	s := t.s
+	n := runLength(s[i:], s[i])
+	if n > 3 {
+		return 0
+	}
+
+	// An opener must be followed by text, and underscores can't open
+	// inside a word
+	if i+n >= len(s) || s[i+n] == ' ' || s[i+n] == '\n' {
+		return 0
+	}
+	if s[i] == '_' && i > 0 && isWordByte(s[i-1]) {
+		return 0
+	}
+
+	// The closer is the first run after the opener that's exactly as long
+	closers := t.closers[delimIndex(s[i])][n-1]
+	k := sort.SearchInts(closers, i+n)
+	if k == len(closers) {
+		return 0
+	}
+	end := closers[k]
+
+	inner := t.nested(s[i+n : end])
+	if inner == nil {
+		return 0
+	}
+	switch n {
+	case 1:
+		b.WriteString("<em>")
+		inner.render(b)
+		b.WriteString("</em>")
+	case 2:
+		b.WriteString("<strong>")
+		inner.render(b)
+		b.WriteString("</strong>")
+	case 3:
+		b.WriteString("<em><strong>")
+		inner.render(b)
+		b.WriteString("</strong></em>")
+	}
+	return end + n - i
+}
+
+// allowedSchemes are the URL schemes links may use. Relative URLs, which
+// have no scheme, are allowed too.
+var allowedSchemes = map[string]bool{
+	"http":   true,
+	"https":  true,
+	"mailto": true,
+}
+
+// safeURL checks a link destination against allowedSchemes. Browsers ignore
+// whitespace and control characters inside a scheme (so "java\tscript:"
+// still runs), so those are stripped before the check.
+func safeURL(raw string) (string, bool) {
+	cleaned := strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, raw)
+	if cleaned == "" {
+		return "", false
+	}
+
+	u, err := url.Parse(cleaned)
+	if err != nil {
+		return "", false
+	}
+	if u.Scheme != "" && !allowedSchemes[strings.ToLower(u.Scheme)] {
+		return "", false
+	}
+	return cleaned, true
+}
+
+func isPunct(c byte) bool {
+	return c < utf8.RuneSelf && unicode.IsPunct(rune(c)) || strings.IndexByte("$+<=>^`|~", c) >= 0
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= utf8.RuneSelf
+}
+
+// runLength returns how many times c is repeated at the start of s
+func runLength(s string, c byte) int {
+	n := 0
+	for n < len(s) && s[n] == c {
+		n++
+	}
+	return n
+}
+
+// delimIndex is where emphasis closers delimited by c are kept in
+// inline.closers
+func delimIndex(c byte) int {
+	if c == '*' {
+		return 0
+	}
+	return 1
+}
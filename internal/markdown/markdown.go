@@ -0,0 +1,284 @@
+package markdown
+
+import (
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/shaheerkj/snippetbox/internal/highlight"
+)
+
+// Render converts Markdown source to HTML that is safe to embed in a page.
+//
+// The output is built from an allow-list rather than by filtering: only the
+// elements below are ever produced, all text is escaped, raw HTML in the
+// source is shown as text, no element gets an inline style or event handler
+// attribute, and links are only kept for the schemes allowed by safeURL.
+//
+//	p h1-h6 em strong code pre span blockquote ul ol li a hr br
+//
+// Fenced code blocks are highlighted with the highlight package, using the
+// language named after the opening fence or detected from the code.
+func Render(src string) string {
+	src = strings.ReplaceAll(src, "\r\n", "\n")
+	src = strings.ReplaceAll(src, "\t", "    ")
+
+	var b strings.Builder
+	renderBlocks(&b, strings.Split(src, "\n"), false, 0)
+	return b.String()
+}
+
+// maxNesting is how deeply block quotes and lists, and separately emphasis
+// and links, can be nested. Anything deeper is left as text, so that deeply
+// nested input can't take time proportional to its depth times its length.
+const maxNesting = 16
+
+var (
+	headingRX  = regexp.MustCompile(`^ {0,3}(#{1,6})(?:[ \t]+(.*?))?(?:[ \t]+#+)?[ \t]*$`)
+	fenceRX    = regexp.MustCompile("^( {0,3})(`{3,}|~{3,})[ \t]*([^`\\s]*)")
+	quoteRX    = regexp.MustCompile(`^ {0,3}> ?`)
+	listItemRX = regexp.MustCompile(`^( {0,3})([-*+]|\d{1,9}[.)])(?:( +)(.*))?$`)
+)
+
+// renderBlocks renders a sequence of lines as block elements, nested depth
+// block quotes and lists deep. In tight lists paragraphs are rendered
+// without <p> tags.
+func renderBlocks(b *strings.Builder, lines []string, tight bool, depth int) {
+	for i := 0; i < len(lines); {
+		line := lines[i]
+
+		switch {
+		case isBlank(line):
+			i++
+
+		case fenceRX.MatchString(line):
+			i = renderFence(b, lines, i)
+
+		case headingRX.MatchString(line):
+			m := headingRX.FindStringSubmatch(line)
+			level := strconv.Itoa(len(m[1]))
+			b.WriteString("<h" + level + ">")
+			renderInline(b, strings.TrimSpace(m[2]))
+			b.WriteString("</h" + level + ">\n")
+			i++
+
+		case isThematicBreak(line):
+			b.WriteString("<hr>\n")
+			i++
+
+		case depth < maxNesting && quoteRX.MatchString(line):
+			var quoted []string
+			for ; i < len(lines) && quoteRX.MatchString(lines[i]); i++ {
+				quoted = append(quoted, quoteRX.ReplaceAllString(lines[i], ""))
+			}
+			b.WriteString("<blockquote>\n")
+			renderBlocks(b, quoted, false, depth+1)
+			b.WriteString("</blockquote>\n")
+
+		case depth < maxNesting && listItemRX.MatchString(line):
+			i = renderList(b, lines, i, depth)
+
+		case strings.HasPrefix(line, "    "):
+			var code []string
+			for ; i < len(lines) && (strings.HasPrefix(lines[i], "    ") || isBlank(lines[i])); i++ {
+				code = append(code, strings.TrimPrefix(lines[i], "    "))
+			}
+			// Trailing blank lines belong to whatever comes next
+			for len(code) > 0 && isBlank(code[len(code)-1]) {
+				code = code[:len(code)-1]
+			}
+			renderCode(b, "", strings.Join(code, "\n"))
+
+		default:
+			var para []string
+			for ; i < len(lines) && !isBlank(lines[i]) && (len(para) == 0 || !startsBlock(lines[i])); i++ {
+				// Keep trailing spaces, which can mark a hard line break
+				para = append(para, strings.TrimLeft(lines[i], " "))
+			}
+			if !tight {
+				b.WriteString("<p>")
+			}
+			renderInline(b, strings.TrimRight(strings.Join(para, "\n"), " "))
+			if !tight {
+				b.WriteString("</p>")
+			}
+			b.WriteString("\n")
+		}
+	}
+}
+
+// startsBlock reports whether line interrupts a paragraph
+func startsBlock(line string) bool {
+	return fenceRX.MatchString(line) || headingRX.MatchString(line) || isThematicBreak(line) ||
+		quoteRX.MatchString(line) || listItemRX.MatchString(line)
+}
+
+// renderFence renders the fenced code block starting at lines[start] and
+// returns the index of the line after it
+func renderFence(b *strings.Builder, lines []string, start int) int {
+	m := fenceRX.FindStringSubmatch(lines[start])
+	indent, fence, info := len(m[1]), m[2], m[3]
+
+	var code []string
+	i := start + 1
+	for ; i < len(lines); i++ {
+		trimmed := strings.TrimSpace(lines[i])
+		if strings.HasPrefix(trimmed, fence) && strings.Trim(trimmed, fence[:1]) == "" {
+			i++
+			break
+		}
+		// Remove up to the opening fence's indentation from each line
+		line := lines[i]
+		for n := 0; n < indent && strings.HasPrefix(line, " "); n++ {
+			line = line[1:]
+		}
+		code = append(code, line)
+	}
+
+	renderCode(b, strings.ToLower(info), strings.Join(code, "\n"))
+	return i
+}
+
+// renderCode renders a highlighted code block using the same markup and
+// classes as code snippets
+func renderCode(b *strings.Builder, lang, code string) {
+	if !highlight.Supported(lang) {
+		lang = highlight.Detect(code)
+	}
+
+	b.WriteString(`<pre class="code"><code>`)
+	for _, line := range highlight.Lines(lang, code) {
+		b.WriteString(`<span class="line">`)
+		for _, tok := range line.Tokens {
+			if tok.Class != "" {
+				b.WriteString(`<span class="hl-` + tok.Class + `">` + html.EscapeString(tok.Text) + `</span>`)
+			} else {
+				b.WriteString(html.EscapeString(tok.Text))
+			}
+		}
+		b.WriteString("\n</span>")
+	}
+	b.WriteString("</code></pre>\n")
+}
+
+// renderList renders the list starting at lines[start], nested depth block
+// quotes and lists deep, and returns the index of the line after it
+func renderList(b *strings.Builder, lines []string, start, depth int) int {
+	first := listItemRX.FindStringSubmatch(lines[start])
+	marker := first[2]
+	ordered := marker[0] >= '0' && marker[0] <= '9'
+	// Items continue the list only if they use the same kind of marker
+	delim := marker[len(marker)-1:]
+
+	// sameList reports whether line is an item belonging to this list
+	sameList := func(line string) ([]string, bool) {
+		m := listItemRX.FindStringSubmatch(line)
+		if m == nil || (m[2][0] >= '0' && m[2][0] <= '9') != ordered || !strings.HasSuffix(m[2], delim) {
+			return nil, false
+		}
+		return m, true
+	}
+
+	var items [][]string
+	loose := false
+	i := start
+	for i < len(lines) {
+		m, ok := sameList(lines[i])
+		if !ok {
+			break
+		}
+
+		// Continuation lines must be indented to the item's content
+		contentIndent := len(m[1]) + len(m[2]) + max(len(m[3]), 1)
+		item := []string{m[4]}
+		i++
+
+		for i < len(lines) {
+			line := lines[i]
+			if isBlank(line) {
+				// A blank line only continues the item if indented content follows
+				if i+1 < len(lines) && indentOf(lines[i+1]) >= contentIndent {
+					item = append(item, "")
+					loose = true
+					i++
+					continue
+				}
+				break
+			}
+			if indentOf(line) >= contentIndent {
+				item = append(item, line[contentIndent:])
+				i++
+				continue
+			}
+			// Lazy continuation of the item's paragraph
+			if !isBlank(item[len(item)-1]) && !startsBlock(line) {
+				item = append(item, strings.TrimSpace(line))
+				i++
+				continue
+			}
+			break
+		}
+		items = append(items, item)
+
+		// Blank lines between items make the list loose
+		if i+1 < len(lines) && isBlank(lines[i]) {
+			if _, ok := sameList(lines[i+1]); ok {
+				loose = true
+				i++
+			}
+		}
+	}
+
+	if ordered {
+		n, _ := strconv.Atoi(strings.TrimRight(marker, ".)"))
+		if n != 1 {
+			b.WriteString(`<ol start="` + strconv.Itoa(n) + `">` + "\n")
+		} else {
+			b.WriteString("<ol>\n")
+		}
+	} else {
+		b.WriteString("<ul>\n")
+	}
+
+	for _, item := range items {
+		b.WriteString("<li>")
+		renderBlocks(b, item, !loose, depth+1)
+		b.WriteString("</li>\n")
+	}
+
+	if ordered {
+		b.WriteString("</ol>\n")
+	} else {
+		b.WriteString("</ul>\n")
+	}
+
+	return i
+}
+
+// isThematicBreak reports whether line is a horizontal rule: three or more
+// of the same -, * or _ character, optionally separated by spaces
+func isThematicBreak(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	if indentOf(line) > 3 || trimmed == "" || !strings.ContainsRune("-*_", rune(trimmed[0])) {
+		return false
+	}
+	count := 0
+	for _, r := range trimmed {
+		switch {
+		case r == rune(trimmed[0]):
+			count++
+		case r != ' ':
+			return false
+		}
+	}
+	return count >= 3
+}
+
+func isBlank(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
+
+func indentOf(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
@@ -0,0 +1,202 @@
+package markdown
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	tagRX  = regexp.MustCompile(`<(/?)([a-z0-9]+)((?:\s+[a-z]+="[^"<>]*")*)>`)
+	attrRX = regexp.MustCompile(`([a-z]+)="([^"]*)"`)
+)
+
+// allowedAttrs are the attributes each element Render produces may have
+var allowedAttrs = map[string]map[string]bool{
+	"p": {}, "h1": {}, "h2": {}, "h3": {}, "h4": {}, "h5": {}, "h6": {},
+	"em": {}, "strong": {}, "blockquote": {}, "ul": {}, "li": {}, "hr": {}, "br": {},
+	"code": {}, "ol": {"start": true}, "pre": {"class": true}, "span": {"class": true},
+	"a": {"href": true, "rel": true},
+}
+
+// checkAllowList fails the test if out has any markup Render shouldn't
+// produce: an element or attribute outside the allow-list, a link to an
+// unsafe scheme, or a < or > that isn't part of an allowed tag
+func checkAllowList(t *testing.T, out string) {
+	t.Helper()
+
+	for _, m := range tagRX.FindAllStringSubmatch(out, -1) {
+		attrs, ok := allowedAttrs[m[2]]
+		if !ok {
+			t.Errorf("element %q in output:\n%s", m[2], out)
+			continue
+		}
+		for _, a := range attrRX.FindAllStringSubmatch(m[3], -1) {
+			if !attrs[a[1]] {
+				t.Errorf("attribute %q on %q in output:\n%s", a[1], m[2], out)
+			}
+			if a[1] == "href" {
+				u, err := url.Parse(html.UnescapeString(a[2]))
+				if err != nil || !(u.Scheme == "" || allowedSchemes[u.Scheme]) {
+					t.Errorf("unsafe href %q in output:\n%s", a[2], out)
+				}
+			}
+		}
+	}
+
+	if rest := tagRX.ReplaceAllString(out, ""); strings.ContainsAny(rest, "<>") {
+		t.Errorf("unescaped < or > in output:\n%s", out)
+	}
+}
+
+func TestRenderIsSafe(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string // Must appear in the output, if not empty
+	}{
+		{"Script tag", "<script>alert(1)</script>", "&lt;script&gt;alert(1)&lt;/script&gt;"},
+		{"Script block", "<script>\nalert(1)\n</script>", "&lt;script&gt;"},
+		{"Image with an event handler", `<img src=x onerror=alert(1)>`, "&lt;img src=x onerror=alert(1)&gt;"},
+		{"Inline HTML in emphasis", `*<b onmouseover="alert(1)">hi</b>*`, "<em>&lt;b"},
+		{"Event handler in a heading", `# <svg onload=alert(1)>`, "<h1>&lt;svg"},
+		{"javascript: link", "[x](javascript:alert(1))", "x"},
+		{"JAVASCRIPT: link", "[x](JAVASCRIPT:alert(1))", "x"},
+		{"javascript: link with a tab", "[x](java\tscript:alert(1))", "x"},
+		{"javascript: link with an entity", "[x](javascript&#58;alert(1))", "x"},
+		{"data: link", "[x](data:text/html;base64,PHNjcmlwdD5hbGVydCgxKTwvc2NyaXB0Pg==)", "x"},
+		{"vbscript: link", "[x](vbscript:msgbox(1))", "x"},
+		{"javascript: link in angle brackets", "[x](<javascript:alert(1)>)", "x"},
+		{"javascript: image", "![x](javascript:alert(1))", "x"},
+		{"JAVASCRIPT: image", "![x](JAVASCRIPT:alert(1))", "x"},
+		{"data: image", "![x](data:image/svg+xml,<svg onload=alert(1)>)", ""},
+		{"javascript: autolink", "<javascript:alert(1)>", "&lt;javascript:alert(1)&gt;"},
+		{"Quote in a URL", `[x](https://example.com/"onmouseover="alert(1))`, `href="https://example.com/&#34;onmouseover=&#34;alert(1)"`},
+		{"Quote in an angle bracket URL", `[x](<https://example.com/" onmouseover="alert(1)>)`, `href="https://example.com/&#34;`},
+		{"Quote in a title", `[x](https://example.com "a" onmouseover="alert(1)")`, "onmouseover=&#34;alert(1)&#34;"},
+		{"Markup in a title", `[x](https://example.com "'><script>alert(1)</script>")`, `<a href="https://example.com" rel="nofollow noopener">x</a>`},
+		{"Single quote in a title", `[x](https://example.com 'a' onmouseover='alert(1)')`, ""},
+		{"Quote in an autolink", `<https://example.com/"onmouseover="alert(1)>`, `href="https://example.com/&#34;onmouseover=&#34;alert(1)"`},
+		{"Quote in link text", `["><script>alert(1)</script>](https://example.com)`, "&#34;&gt;&lt;script&gt;"},
+		{"Quote in a code fence language", "```\"><script>\nx\n```", ""},
+		{"HTML in a code block", "```html\n<script>alert(1)</script>\n```", ""},
+		{"HTML in a code span", "`<script>alert(1)</script>`", "<code>&lt;script&gt;alert(1)&lt;/script&gt;</code>"},
+		{"Safe link", "[x](https://example.com/a_(b))", `<a href="https://example.com/a_(b)" rel="nofollow noopener">x</a>`},
+		{"Safe mailto link", "[x](mailto:a@example.com)", `href="mailto:a@example.com"`},
+		{"Safe relative link", "[x](/snippets?q=a&b=c)", `href="/snippets?q=a&amp;b=c"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := Render(tt.src)
+			checkAllowList(t, out)
+			if !strings.Contains(out, tt.want) {
+				t.Errorf("output doesn't contain %q:\n%s", tt.want, out)
+			}
+		})
+	}
+}
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"Paragraph", "Hello\nworld", "<p>Hello\nworld</p>\n"},
+		{"Heading", "## Hello ##", "<h2>Hello</h2>\n"},
+		{"Emphasis", "*a* **b** ***c*** _d_", "<p><em>a</em> <strong>b</strong> <em><strong>c</strong></em> <em>d</em></p>\n"},
+		{"Nested emphasis", "*a **b** c*", "<p><em>a <strong>b</strong> c</em></p>\n"},
+		{"Unclosed emphasis", "*a b", "<p>*a b</p>\n"},
+		{"Opener before a space", "a * b*", "<p>a * b*</p>\n"},
+		{"Underscores inside words", "snake_case_name", "<p>snake_case_name</p>\n"},
+		{"Escaped delimiter", `\*a\*`, "<p>*a*</p>\n"},
+		{"Code span", "`a * b`", "<p><code>a * b</code></p>\n"},
+		{"Double backtick code span", "`` a ` b ``", "<p><code>a ` b</code></p>\n"},
+		{"Link with nested brackets", "[a [b] c](/x)", `<p><a href="/x" rel="nofollow noopener">a [b] c</a></p>` + "\n"},
+		{"Link with a title", `[a](/x "title")`, `<p><a href="/x" rel="nofollow noopener">a</a></p>` + "\n"},
+		{"Unclosed link", "[a](/x", "<p>[a](/x</p>\n"},
+		{"Image as a link", "![alt](/a.png)", `<p><a href="/a.png" rel="nofollow noopener">alt</a></p>` + "\n"},
+		{"Autolink", "<https://example.com>", `<p><a href="https://example.com" rel="nofollow noopener">https://example.com</a></p>` + "\n"},
+		{"Hard line break", "a  \nb", "<p>a<br>\nb</p>\n"},
+		{"Block quote", "> a\n> b", "<blockquote>\n<p>a\nb</p>\n</blockquote>\n"},
+		{"Nested block quote", "> > a", "<blockquote>\n<blockquote>\n<p>a</p>\n</blockquote>\n</blockquote>\n"},
+		{"Tight list", "- a\n- b", "<ul>\n<li>a\n</li>\n<li>b\n</li>\n</ul>\n"},
+		{"Ordered list", "3. a\n4. b", "<ol start=\"3\">\n<li>a\n</li>\n<li>b\n</li>\n</ol>\n"},
+		{"Loose list", "- a\n\n- b", "<ul>\n<li><p>a</p>\n</li>\n<li><p>b</p>\n</li>\n</ul>\n"},
+		{"Thematic break", "* * *", "<hr>\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Render(tt.src); got != tt.want {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderNestingIsCapped(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		open string
+	}{
+		{"Block quotes", strings.Repeat(">", 100) + " a", "<blockquote>"},
+		{"Lists", strings.Repeat("- ", 100) + "a", "<ul>"},
+		{"Emphasis", strings.Repeat("*a ", 100) + strings.Repeat(" a*", 100), "<em>"},
+		{"Links", strings.Repeat("[", 100) + "a" + strings.Repeat("](/x)", 100), "<a "},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := Render(tt.src)
+			if n := strings.Count(out, tt.open); n > maxNesting {
+				t.Errorf("%s nested %d deep; want at most %d", tt.open, n, maxNesting)
+			}
+			checkAllowList(t, out)
+		})
+	}
+}
+
+// TestRenderTime checks that input built to make a naive renderer search the
+// rest of the text over and over is still rendered in linear time. Each of
+// these took seconds before rendering was made linear.
+func TestRenderTime(t *testing.T) {
+	units := []string{
+		"*a ",
+		"_a ",
+		"**a ",
+		"[",
+		"[a](",
+		"[a](<",
+		`[a](b "`,
+		"![a](",
+		">",
+		"> ",
+		"- ",
+		"-\n ",
+		"`",
+		"`` `",
+		"<a",
+		"\\",
+	}
+
+	for _, unit := range units {
+		t.Run(unit, func(t *testing.T) {
+			src := strings.Repeat(unit, 40000)
+
+			start := time.Now()
+			out := Render(src)
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("took %s to render %d bytes", elapsed, len(src))
+			}
+			if len(out) > 10*len(src)+100 {
+				t.Errorf("rendered %d bytes as %d", len(src), len(out))
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Scopes a personal access token can have. Write tokens can read too.
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+)
+
+// APITokenPrefix starts every personal access token, so they're easy to
+// recognise, e.g. by secret scanners
+const APITokenPrefix = "sbx_"
+
+// MaxAPITokens is the most personal access tokens a user can have at once
+const MaxAPITokens = 20
+
+// APIToken is a personal access token a user created for scripts and other
+// programs to act as them. The token itself is only known when it's made.
+type APIToken struct {
+	ID       int
+	Name     string
+	Scope    string
+	Created  time.Time
+	LastUsed time.Time // Zero if it's never been used
+}
+
+// CreateAPIToken makes a personal access token for a user, returning the
+// token. Only its hash is stored.
+func (m *UserModel) CreateAPIToken(userID int, name, scope string) (string, error) {
+	token, _, err := newToken()
+	if err != nil {
+		return "", err
+	}
+	token = APITokenPrefix + token
+
+	stmt := `INSERT INTO api_tokens (user_id, name, scope, token_hash, created) 
+	         VALUES (?,?,?,?,UTC_TIMESTAMP())`
+
+	_, err = m.DB.Exec(stmt, userID, name, scope, tokenHash(token))
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// APITokens returns a user's personal access tokens, newest first
+func (m *UserModel) APITokens(userID int) ([]APIToken, error) {
+	stmt := `SELECT id, name, scope, created, last_used FROM api_tokens 
+	         WHERE user_id = ? ORDER BY id DESC`
+
+	rows, err := m.DB.Query(stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []APIToken
+
+	for rows.Next() {
+		var t APIToken
+		err := rows.Scan(&t.ID, &t.Name, &t.Scope, &t.Created, nullTime{&t.LastUsed})
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// RevokeAPIToken deletes one of a user's personal access tokens. Returns
+// ErrNoRecord if they don't have a token with that ID.
+func (m *UserModel) RevokeAPIToken(userID, id int) error {
+	result, err := m.DB.Exec(`DELETE FROM api_tokens WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNoRecord
+	}
+	return nil
+}
+
+// AuthenticateAPIToken returns the ID of the user a personal access token
+// belongs to and the token's scope, recording that it was used. Returns
+// ErrInvalidCredentials for tokens that don't exist or have been revoked.
+func (m *UserModel) AuthenticateAPIToken(token string) (int, string, error) {
+	if !strings.HasPrefix(token, APITokenPrefix) {
+		return 0, "", ErrInvalidCredentials
+	}
+
+	var (
+		id, userID int
+		scope      string
+	)
+	hash := tokenHash(token)
+	err := m.DB.QueryRow(`SELECT id, user_id, scope FROM api_tokens WHERE token_hash = ?`, hash).Scan(&id, &userID, &scope)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, "", ErrInvalidCredentials
+		}
+		return 0, "", err
+	}
+
+	// Only record the time to the minute so busy scripts don't write on
+	// every request
+	stmt := `UPDATE api_tokens SET last_used = UTC_TIMESTAMP() 
+	         WHERE id = ? AND (last_used IS NULL OR last_used < DATE_SUB(UTC_TIMESTAMP(), INTERVAL 1 MINUTE))`
+
+	_, err = m.DB.Exec(stmt, id)
+	if err != nil {
+		return 0, "", err
+	}
+
+	return userID, scope, nil
+}
+
+// APITokenCount returns how many personal access tokens a user has
+func (m *UserModel) APITokenCount(userID int) (int, error) {
+	var n int
+	err := m.DB.QueryRow(`SELECT COUNT(*) FROM api_tokens WHERE user_id = ?`, userID).Scan(&n)
+	return n, err
+}
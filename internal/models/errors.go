@@ -7,3 +7,5 @@ import "errors"
 var ErrNoRecord = errors.New("models: Record not found")
 var ErrInvalidCredentials = errors.New("models: invalid credentials")
 var ErrDuplicateEmail = errors.New("models: duplicate email")
+var ErrDuplicateHandle = errors.New("models: duplicate handle")
+var ErrTooManyAttempts = errors.New("models: too many failed attempts")
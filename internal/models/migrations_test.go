@@ -0,0 +1,170 @@
+package models
+
+import (
+	"database/sql"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// runMigration runs one of the upgrade scripts in the migrations directory
+func runMigration(t *testing.T, db *sql.DB, name string) {
+	t.Helper()
+
+	script, err := os.ReadFile("../../migrations/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(string(script))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// replaceTables drops tables from the test schema and creates them as they
+// were before a migration. drop is a comma-separated list of tables.
+func replaceTables(t *testing.T, db *sql.DB, drop, create string) {
+	t.Helper()
+
+	_, err := db.Exec(`SET FOREIGN_KEY_CHECKS = 0; DROP TABLE ` + drop + `; ` + create + `; SET FOREIGN_KEY_CHECKS = 1`)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAddSnippetSlugs(t *testing.T) {
+	db := newTestDB(t)
+
+	replaceTables(t, db, "snippets", `CREATE TABLE snippets (
+		id INT NOT NULL AUTO_INCREMENT,
+		title VARCHAR(100) NOT NULL,
+		content TEXT NOT NULL,
+		created DATETIME NOT NULL,
+		expires DATETIME NOT NULL,
+		PRIMARY KEY (id),
+		INDEX idx_created (created)
+	)`)
+
+	const n = 50
+	for i := 0; i < n; i++ {
+		_, err := db.Exec(`INSERT INTO snippets (title, content, created, expires) 
+		VALUES ('Old', 'Old content', UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL 1 DAY))`)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	runMigration(t, db, "add_snippet_slugs.sql")
+
+	rows, err := db.Query(`SELECT slug FROM snippets`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	seen := make(map[string]bool)
+	for rows.Next() {
+		var slug string
+		if err := rows.Scan(&slug); err != nil {
+			t.Fatal(err)
+		}
+		if !ValidSlug(slug) {
+			t.Errorf("got slug %q; want one ValidSlug accepts", slug)
+		}
+		if seen[slug] {
+			t.Errorf("slug %q given to more than one snippet", slug)
+		}
+		seen[slug] = true
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != n {
+		t.Errorf("got %d slugs; want %d", len(seen), n)
+	}
+
+	// The constraint the application relies on to retry collisions
+	_, err = db.Exec(`INSERT INTO snippets (slug, title, content, created, expires) 
+	SELECT slug, title, content, created, expires FROM snippets LIMIT 1`)
+	if err == nil {
+		t.Error("inserting a duplicate slug succeeded; want an error")
+	}
+}
+
+func TestMoveContentToSnippetFiles(t *testing.T) {
+	db := newTestDB(t)
+	users := &UserModel{DB: db}
+	m := &SnippetModel{DB: db}
+
+	// The tables as they were before snippets held files
+	replaceTables(t, db, "snippet_files, snippet_revisions, snippets", `CREATE TABLE snippets (
+		id INT NOT NULL AUTO_INCREMENT,
+		slug CHAR(11) NOT NULL,
+		user_id INT NULL,
+		title VARCHAR(100) NOT NULL,
+		content TEXT NOT NULL,
+		language VARCHAR(20) NOT NULL DEFAULT 'plaintext',
+		visibility ENUM('public', 'unlisted', 'private') NOT NULL DEFAULT 'public',
+		burn_after_reading BOOLEAN NOT NULL DEFAULT FALSE,
+		hashed_passphrase CHAR(60) NULL,
+		unlock_failures INT NOT NULL DEFAULT 0,
+		unlock_retry_at DATETIME NULL,
+		revision INT NOT NULL DEFAULT 1,
+		created DATETIME NOT NULL,
+		updated DATETIME NOT NULL,
+		expires DATETIME NULL,
+		deleted_at DATETIME NULL,
+		PRIMARY KEY (id),
+		CONSTRAINT snippets_uc_slug UNIQUE (slug)
+	);
+	CREATE TABLE snippet_revisions (
+		id INT NOT NULL AUTO_INCREMENT,
+		snippet_id INT NOT NULL,
+		revision INT NOT NULL,
+		title VARCHAR(100) NOT NULL,
+		content TEXT NOT NULL,
+		created DATETIME NOT NULL,
+		PRIMARY KEY (id),
+		CONSTRAINT snippet_revisions_uc_revision UNIQUE (snippet_id, revision),
+		FOREIGN KEY (snippet_id) REFERENCES snippets(id) ON DELETE CASCADE
+	)`)
+
+	// A snippet that has been edited once
+	author := newTestUser(t, users, "alice", "pa55word!")
+	const slug = "AAAAAAAAAAA"
+
+	result, err := db.Exec(`INSERT INTO snippets (slug, user_id, title, content, language, revision, created, updated) 
+	VALUES (?, ?, 'Hello', 'package main // v2', 'go', 2, UTC_TIMESTAMP(), UTC_TIMESTAMP())`, slug, author)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`INSERT INTO snippet_revisions (snippet_id, revision, title, content, created) 
+	VALUES (?, 1, 'Hello', 'package main // v1', UTC_TIMESTAMP())`, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runMigration(t, db, "move_content_to_snippet_files.sql")
+
+	s, err := m.Get(slug, author)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []File{{Name: "file1", Language: "go", Content: "package main // v2"}}
+	if !reflect.DeepEqual(s.Files, want) {
+		t.Errorf("got files %+v; want %+v", s.Files, want)
+	}
+
+	r, err := m.GetRevision(int(id), 1, author)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want[0].Content = "package main // v1"
+	if !reflect.DeepEqual(r.Files, want) {
+		t.Errorf("got revision 1 files %+v; want %+v", r.Files, want)
+	}
+}
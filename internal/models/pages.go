@@ -0,0 +1,122 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cursor marks a snippet's place in a listing ordered by creation time,
+// newest first. Ties on the time are broken by ID.
+type Cursor struct {
+	Created time.Time
+	ID      int
+}
+
+// IsZero reports whether c is unset
+func (c Cursor) IsZero() bool {
+	return c.ID == 0
+}
+
+// String encodes c for use in a URL, e.g. "1718000000-42"
+func (c Cursor) String() string {
+	return fmt.Sprintf("%d-%d", c.Created.Unix(), c.ID)
+}
+
+// ParseCursor decodes a cursor made by Cursor.String
+func ParseCursor(s string) (Cursor, error) {
+	created, id, ok := strings.Cut(s, "-")
+	if !ok {
+		return Cursor{}, errors.New("models: malformed cursor")
+	}
+	sec, err := strconv.ParseInt(created, 10, 64)
+	if err != nil {
+		return Cursor{}, err
+	}
+	n, err := strconv.Atoi(id)
+	if err != nil || n < 1 {
+		return Cursor{}, errors.New("models: malformed cursor")
+	}
+	return Cursor{Created: time.Unix(sec, 0).UTC(), ID: n}, nil
+}
+
+// PageRequest asks for one page of a listing. Pages are found by keyset
+// pagination on (created, id) rather than with OFFSET, so they stay fast
+// however far back they are. With neither cursor set it's the newest page.
+type PageRequest struct {
+	Size   int
+	Before Cursor // Only list snippets newer than this
+	After  Cursor // Only list snippets older than this
+}
+
+// PageLinks holds the cursors of the pages either side of the one returned.
+// Pass Newer as Before, or Older as After, to get them. Zero cursors mean
+// there's no page that way.
+type PageLinks struct {
+	Newer Cursor
+	Older Cursor
+}
+
+// condition restricts a query over snippets, aliased as s, to the page.
+// It's meant to be appended to a WHERE clause.
+func (pr PageRequest) condition() (string, []any) {
+	switch {
+	case !pr.Before.IsZero():
+		return ` AND (s.created > ? OR (s.created = ? AND s.id > ?))`, []any{pr.Before.Created, pr.Before.Created, pr.Before.ID}
+	case !pr.After.IsZero():
+		return ` AND (s.created < ? OR (s.created = ? AND s.id < ?))`, []any{pr.After.Created, pr.After.Created, pr.After.ID}
+	default:
+		return "", nil
+	}
+}
+
+// order sorts the rows of a page, reading newer pages from the other end,
+// and fetches one row more than the page holds to find out whether there's
+// another page beyond it. prefix qualifies the created and id columns.
+func (pr PageRequest) order(prefix string) (string, []any) {
+	dir := "DESC"
+	if !pr.Before.IsZero() {
+		dir = "ASC"
+	}
+	return fmt.Sprintf(` ORDER BY %[1]screated %[2]s, %[1]sid %[2]s LIMIT ?`, prefix, dir), []any{pr.Size + 1}
+}
+
+// paginate trims the extra row fetched by order, puts rows back in newest
+// first order and works out the links to the pages either side
+func paginate[T any](rows []T, pr PageRequest, cursor func(T) Cursor) ([]T, PageLinks) {
+	more := len(rows) > pr.Size
+	if more {
+		rows = rows[:pr.Size]
+	}
+
+	var links PageLinks
+	if len(rows) == 0 {
+		return rows, links
+	}
+
+	if !pr.Before.IsZero() {
+		slices.Reverse(rows)
+		// There must be older snippets, since that's where we came from
+		links.Older = cursor(rows[len(rows)-1])
+		if more {
+			links.Newer = cursor(rows[0])
+		}
+	} else {
+		if more {
+			links.Older = cursor(rows[len(rows)-1])
+		}
+		if !pr.After.IsZero() {
+			links.Newer = cursor(rows[0])
+		}
+	}
+
+	return rows, links
+}
+
+// snippetCursor returns the place of a snippet in a listing
+func snippetCursor(s Snippet) Cursor {
+	return Cursor{Created: s.Created, ID: s.ID}
+}
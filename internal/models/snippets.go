@@ -1,18 +1,198 @@
 package models
 
 import (
+	"crypto/rand"
 	"database/sql"
 	"errors"
+	"strings"
 	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"golang.org/x/crypto/bcrypt"
 )
 
 // Snippet represents a code snippet stored in the database
 type Snippet struct {
-	ID      int
-	Title   string
-	Content string
-	Created time.Time
-	Expires time.Time
+	ID         int
+	Slug       string // Random identifier used in URLs in place of ID
+	UserID     int    // ID of the user who created the snippet, 0 if they've deleted their account
+	AuthorName string // Name of the author, populated by queries that join users
+	// AuthorHandle is the handle of the author, populated along with AuthorName
+	AuthorHandle string
+	Title        string
+	Files        []File   // Files of the shown revision, only populated by Get and Consume
+	Tags         []string // Sorted alphabetically
+	Visibility   string
+	// BurnAfterReading snippets can be read once, through Consume, after
+	// which they're destroyed
+	BurnAfterReading bool
+	Protected        bool // Whether a passphrase is needed to read the snippet
+	Revision         int  // Number of the current revision, starting at 1
+	Created          time.Time
+	Updated          time.Time // When the current revision was written
+	Expires          time.Time // Zero if the snippet never expires
+	Deleted          time.Time // When the snippet was moved to the trash, only set by Trash
+	Stars            int       // Number of users who starred the snippet, set by Get and PublicByUser
+	Starred          bool      // Whether the viewer starred the snippet, only set by Get
+}
+
+// File is one named file within a snippet
+type File struct {
+	Position int // Zero-based position of the file within the snippet
+	Name     string
+	Language string // Highlighting language, see the highlight package
+	Content  string
+}
+
+// MaxFiles is the most files a single snippet can hold
+const MaxFiles = 20
+
+// MaxFileBytes is the most content a single file can hold, and
+// MaxSnippetBytes the most all of a snippet's files can hold between them.
+// Every revision keeps a copy of the files, and they're highlighted or
+// rendered on every view.
+const (
+	MaxFileBytes    = 100 << 10
+	MaxSnippetBytes = 500 << 10
+)
+
+// Snippet visibility levels. Public snippets are listed on the home page,
+// unlisted ones can only be reached by link and private ones only by their
+// author.
+const (
+	VisibilityPublic   = "public"
+	VisibilityUnlisted = "unlisted"
+	VisibilityPrivate  = "private"
+)
+
+// SlugLength is the number of base62 characters in a snippet slug
+const SlugLength = 11
+
+const slugAlphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// newSlug generates a random base62 slug. Slugs made only of digits are
+// never generated so they can't be confused with legacy numeric IDs.
+func newSlug() (string, error) {
+	b := make([]byte, SlugLength)
+	buf := make([]byte, 1)
+
+	for {
+		for i := 0; i < len(b); {
+			if _, err := rand.Read(buf); err != nil {
+				return "", err
+			}
+			// Reject bytes past the largest multiple of 62 to avoid modulo bias
+			if buf[0] >= 248 {
+				continue
+			}
+			b[i] = slugAlphabet[buf[0]%62]
+			i++
+		}
+		if strings.Trim(string(b), "0123456789") != "" {
+			return string(b), nil
+		}
+	}
+}
+
+// ValidSlug reports whether s has the shape of a slug made by newSlug
+func ValidSlug(s string) bool {
+	if len(s) != SlugLength {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if strings.IndexByte(slugAlphabet, s[i]) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Wrong passphrase guesses for a snippet are throttled: after
+// MaxUnlockAttempts failures, further attempts are refused for
+// UnlockLockoutMinutes.
+const (
+	MaxUnlockAttempts    = 5
+	UnlockLockoutMinutes = 15
+)
+
+// TrashRetentionDays is how long a deleted snippet can be restored before
+// it's purged for good
+const TrashRetentionDays = 30
+
+// Revision is a single version of a snippet's title and files
+type Revision struct {
+	SnippetID int
+	Number    int
+	Title     string
+	Files     []File // Only populated by GetRevision
+	Created   time.Time
+}
+
+// nullTime scans a nullable DATETIME column into a time.Time, leaving it as
+// the zero value for NULL
+type nullTime struct {
+	t *time.Time
+}
+
+func (n nullTime) Scan(value any) error {
+	var nt sql.NullTime
+	if err := nt.Scan(value); err != nil {
+		return err
+	}
+	*n.t = nt.Time
+	return nil
+}
+
+// querier is satisfied by both *sql.DB and *sql.Tx
+type querier interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+// files returns the files of one revision of a snippet in order
+func files(q querier, snippetID, revision int) ([]File, error) {
+	stmt := `SELECT position, name, language, content 
+	         FROM snippet_files 
+	         WHERE snippet_id = ? AND revision = ? 
+	         ORDER BY position`
+
+	rows, err := q.Query(stmt, snippetID, revision)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []File
+
+	for rows.Next() {
+		var f File
+		err := rows.Scan(&f.Position, &f.Name, &f.Language, &f.Content)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+// insertFiles stores the files of one revision of a snippet, numbering
+// their positions in order
+func insertFiles(tx *sql.Tx, snippetID, revision int, files []File) error {
+	stmt := `INSERT INTO snippet_files(snippet_id,revision,position,name,language,content) 
+	         VALUES(?,?,?,?,?,?)`
+
+	for i, f := range files {
+		_, err := tx.Exec(stmt, snippetID, revision, i, f.Name, f.Language, f.Content)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 // SnippetModel wraps a database connection pool
@@ -21,44 +201,102 @@ type SnippetModel struct {
 	DB *sql.DB
 }
 
-// Insert adds a new snippet to the database and returns its ID
-// The expires parameter is the number of days until expiration
-func (m *SnippetModel) Insert(title string, content string, expires int) (int, error) {
-	// SQL statement with placeholders (?) to prevent SQL injection
-	stmt := `INSERT INTO snippets(title,content,created,expires) 
-	         VALUES(?,?,UTC_TIMESTAMP(),DATE_ADD(UTC_TIMESTAMP(),INTERVAL ? DAY))`
+// Insert adds a new snippet owned by userID, made up of the given files and
+// tags, to the database and returns its slug
+// The expires parameter is when the snippet expires, or the zero time if it
+// never does. If passphrase isn't empty, readers must supply it before they
+// can see the content.
+func (m *SnippetModel) Insert(userID int, title string, files []File, tags []string, expires time.Time, visibility string, burnAfterReading bool, passphrase string) (string, error) {
+	// Only a bcrypt hash of the passphrase is stored, as for user passwords
+	var hashedPassphrase sql.NullString
+	if passphrase != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(passphrase), 12)
+		if err != nil {
+			return "", err
+		}
+		hashedPassphrase = sql.NullString{String: string(hash), Valid: true}
+	}
 
-	// Execute the SQL statement with parameters
-	result, err := m.DB.Exec(stmt, title, content, expires)
+	// The snippet and its files are written together or not at all
+	tx, err := m.DB.Begin()
 	if err != nil {
-		return 0, err
+		return "", err
 	}
+	defer tx.Rollback()
 
-	// Get the ID of the newly inserted record
-	id, err := result.LastInsertId()
-	if err != nil {
-		return 0, err
+	// SQL statement with placeholders (?) to prevent SQL injection
+	stmt := `INSERT INTO snippets(slug,user_id,title,visibility,burn_after_reading,hashed_passphrase,revision,created,updated,expires) 
+	         VALUES(?,?,?,?,?,?,1,UTC_TIMESTAMP(),UTC_TIMESTAMP(),?)`
+
+	// Slug collisions are very unlikely, so just try again with a new one
+	for attempt := 0; attempt < 5; attempt++ {
+		slug, err := newSlug()
+		if err != nil {
+			return "", err
+		}
+
+		// Execute the SQL statement with parameters
+		result, err := tx.Exec(stmt, slug, userID, title, visibility, burnAfterReading, hashedPassphrase, sql.NullTime{Time: expires.UTC(), Valid: !expires.IsZero()})
+		if err != nil {
+			var mySQLError *mysql.MySQLError
+			if errors.As(err, &mySQLError) {
+				if mySQLError.Number == 1062 && strings.Contains(mySQLError.Message, "snippets_uc_slug") {
+					continue
+				}
+			}
+			return "", err
+		}
+
+		id, err := result.LastInsertId()
+		if err != nil {
+			return "", err
+		}
+
+		err = insertFiles(tx, int(id), 1, files)
+		if err != nil {
+			return "", err
+		}
+
+		err = setTags(tx, int(id), tags)
+		if err != nil {
+			return "", err
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return "", err
+		}
+
+		return slug, nil
 	}
 
-	return int(id), nil
+	return "", errors.New("models: could not generate a unique slug")
 }
 
-// Get retrieves a specific snippet by ID
-// Returns ErrNoRecord if the snippet doesn't exist or has expired
-func (m *SnippetModel) Get(id int) (Snippet, error) {
-	// Only return snippets that haven't expired yet
-	stmt := `SELECT id, title, content, created, expires 
-	         FROM snippets 
-	         WHERE expires > UTC_TIMESTAMP() AND id = ?`
+// Get retrieves a specific snippet by slug as seen by the user viewerID (0
+// for anonymous visitors). Private snippets are only returned to their author.
+// The files of burn after reading snippets are left out for everyone but
+// their author; use Consume to read them.
+// Returns ErrNoRecord if the snippet doesn't exist, has expired or is hidden
+// from the viewer.
+func (m *SnippetModel) Get(slug string, viewerID int) (Snippet, error) {
+	// Only return snippets that haven't expired yet, along with the author's name
+	stmt := `SELECT s.id, s.slug, COALESCE(s.user_id, 0), COALESCE(u.name, ''), COALESCE(u.handle, ''), s.title, 
+	         s.visibility, s.burn_after_reading, s.hashed_passphrase IS NOT NULL, s.revision, s.created, s.updated, s.expires, ` + tagList + `, 
+	         ` + starCount + `, EXISTS(SELECT 1 FROM snippet_stars st WHERE st.snippet_id = s.id AND st.user_id = ?) 
+	         FROM snippets s 
+	         LEFT JOIN users u ON u.id = s.user_id 
+	         WHERE (s.expires IS NULL OR s.expires > UTC_TIMESTAMP()) AND s.deleted_at IS NULL 
+	         AND (s.visibility <> 'private' OR s.user_id = ?) AND s.slug = ?`
 
 	// QueryRow returns at most one row
-	row := m.DB.QueryRow(stmt, id)
+	row := m.DB.QueryRow(stmt, viewerID, viewerID, slug)
 
 	// Initialize empty Snippet struct
 	var s Snippet
 
 	// Scan the result into the struct fields
-	err := row.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires)
+	err := row.Scan(&s.ID, &s.Slug, &s.UserID, &s.AuthorName, &s.AuthorHandle, &s.Title, &s.Visibility, &s.BurnAfterReading, &s.Protected, &s.Revision, &s.Created, &s.Updated, nullTime{&s.Expires}, tagScanner{&s.Tags}, &s.Stars, &s.Starred)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			// No matching record found
@@ -68,22 +306,197 @@ func (m *SnippetModel) Get(id int) (Snippet, error) {
 		return Snippet{}, err
 	}
 
+	if !s.BurnAfterReading || (s.UserID != 0 && s.UserID == viewerID) {
+		s.Files, err = files(m.DB, s.ID, s.Revision)
+		if err != nil {
+			return Snippet{}, err
+		}
+	}
+
+	return s, nil
+}
+
+// Consume reads a burn after reading snippet and destroys it in the same
+// transaction, so only one viewer can ever see the content. The row is kept,
+// expired and without its files, until Purge removes it.
+// Returns ErrNoRecord if the snippet doesn't exist, has already been read or
+// is hidden from the viewer.
+func (m *SnippetModel) Consume(slug string, viewerID int) (Snippet, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return Snippet{}, err
+	}
+	defer tx.Rollback()
+
+	// Lock the row so a concurrent viewer blocks here and then finds it gone
+	stmt := `SELECT s.id, s.slug, COALESCE(s.user_id, 0), COALESCE(u.name, ''), COALESCE(u.handle, ''), s.title, 
+	         s.visibility, s.burn_after_reading, s.hashed_passphrase IS NOT NULL, s.revision, s.created, s.updated, s.expires 
+	         FROM snippets s 
+	         LEFT JOIN users u ON u.id = s.user_id 
+	         WHERE (s.expires IS NULL OR s.expires > UTC_TIMESTAMP()) AND s.deleted_at IS NULL AND s.burn_after_reading 
+	         AND (s.visibility <> 'private' OR s.user_id = ?) AND s.slug = ? 
+	         FOR UPDATE`
+
+	var s Snippet
+	err = tx.QueryRow(stmt, viewerID, slug).Scan(&s.ID, &s.Slug, &s.UserID, &s.AuthorName, &s.AuthorHandle, &s.Title, &s.Visibility, &s.BurnAfterReading, &s.Protected, &s.Revision, &s.Created, &s.Updated, nullTime{&s.Expires})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Snippet{}, ErrNoRecord
+		}
+		return Snippet{}, err
+	}
+
+	s.Files, err = files(tx, s.ID, s.Revision)
+	if err != nil {
+		return Snippet{}, err
+	}
+
+	stmt = `UPDATE snippets SET expires = UTC_TIMESTAMP() WHERE id = ?`
+
+	_, err = tx.Exec(stmt, s.ID)
+	if err != nil {
+		return Snippet{}, err
+	}
+
+	stmt = `DELETE FROM snippet_files WHERE snippet_id = ?`
+
+	_, err = tx.Exec(stmt, s.ID)
+	if err != nil {
+		return Snippet{}, err
+	}
+
+	// Earlier revisions would otherwise keep a copy of the content
+	stmt = `DELETE FROM snippet_revisions WHERE snippet_id = ?`
+
+	_, err = tx.Exec(stmt, s.ID)
+	if err != nil {
+		return Snippet{}, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return Snippet{}, err
+	}
+
 	return s, nil
 }
 
-// Latest returns the 10 most recently created non-expired snippets
-func (m *SnippetModel) Latest() ([]Snippet, error) {
-	// Get the 10 most recent snippets that haven't expired
-	stmt := `SELECT id, title, content, created, expires 
+// Unlock checks a passphrase for a protected snippet. Wrong guesses are
+// counted per snippet, and once there have been too many every attempt is
+// refused with ErrTooManyAttempts until the lockout period passes.
+// Returns ErrInvalidCredentials if the passphrase is wrong and ErrNoRecord if
+// the snippet doesn't exist, isn't protected or is hidden from the viewer.
+func (m *SnippetModel) Unlock(slug string, viewerID int, passphrase string) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock the row so concurrent guesses are counted one at a time
+	stmt := `SELECT id, hashed_passphrase, unlock_failures, 
+	         COALESCE(unlock_retry_at > UTC_TIMESTAMP(), FALSE) 
 	         FROM snippets 
-	         WHERE expires > UTC_TIMESTAMP() 
-	         ORDER BY id DESC 
-	         LIMIT 10`
+	         WHERE (expires IS NULL OR expires > UTC_TIMESTAMP()) AND deleted_at IS NULL AND hashed_passphrase IS NOT NULL 
+	         AND (visibility <> 'private' OR user_id = ?) AND slug = ? 
+	         FOR UPDATE`
+
+	var (
+		id               int
+		hashedPassphrase []byte
+		failures         int
+		lockedOut        bool
+	)
+	err = tx.QueryRow(stmt, viewerID, slug).Scan(&id, &hashedPassphrase, &failures, &lockedOut)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoRecord
+		}
+		return err
+	}
+
+	if lockedOut {
+		return ErrTooManyAttempts
+	}
+
+	err = bcrypt.CompareHashAndPassword(hashedPassphrase, []byte(passphrase))
+	if err != nil {
+		if !errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return err
+		}
+
+		// Start a lockout once the limit is reached, then let the count
+		// begin again when it's over
+		failures++
+		if failures >= MaxUnlockAttempts {
+			stmt = `UPDATE snippets 
+			        SET unlock_failures = 0, unlock_retry_at = DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? MINUTE) 
+			        WHERE id = ?`
+			_, err = tx.Exec(stmt, UnlockLockoutMinutes, id)
+		} else {
+			stmt = `UPDATE snippets SET unlock_failures = ? WHERE id = ?`
+			_, err = tx.Exec(stmt, failures, id)
+		}
+		if err != nil {
+			return err
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+		return ErrInvalidCredentials
+	}
+
+	if failures > 0 {
+		stmt = `UPDATE snippets SET unlock_failures = 0 WHERE id = ?`
+		_, err = tx.Exec(stmt, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// PublicSlug looks up the slug of a live public snippet by its old numeric
+// ID, so links from before slugs were introduced keep working.
+// Returns ErrNoRecord for any other snippet.
+func (m *SnippetModel) PublicSlug(id int) (string, error) {
+	stmt := `SELECT slug FROM snippets 
+	         WHERE (expires IS NULL OR expires > UTC_TIMESTAMP()) AND deleted_at IS NULL AND visibility = 'public' AND id = ?`
+
+	var slug string
+	err := m.DB.QueryRow(stmt, id).Scan(&slug)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrNoRecord
+		}
+		return "", err
+	}
+
+	return slug, nil
+}
+
+// Latest returns a page of non-expired public snippets, newest first, along
+// with the links to the pages either side.
+// Burn after reading snippets are left out so a passer-by can't consume them.
+func (m *SnippetModel) Latest(pr PageRequest) ([]Snippet, PageLinks, error) {
+	// Get public snippets that haven't expired
+	stmt := `SELECT s.id, s.slug, COALESCE(s.user_id, 0), s.title, s.visibility, s.created, s.expires, ` + tagList + ` 
+	         FROM snippets s 
+	         WHERE (s.expires IS NULL OR s.expires > UTC_TIMESTAMP()) AND s.deleted_at IS NULL AND s.visibility = 'public' 
+	         AND NOT s.burn_after_reading`
+
+	cond, args := pr.condition()
+	order, orderArgs := pr.order("s.")
+	stmt += cond + order
+	args = append(args, orderArgs...)
 
 	// Query returns multiple rows
-	rows, err := m.DB.Query(stmt)
+	rows, err := m.DB.Query(stmt, args...)
 	if err != nil {
-		return nil, err
+		return nil, PageLinks{}, err
 	}
 	defer rows.Close() // Ensure rows are closed when function returns
 
@@ -94,9 +507,9 @@ func (m *SnippetModel) Latest() ([]Snippet, error) {
 	for rows.Next() {
 		var s Snippet
 		// Scan each row into a Snippet struct
-		err := rows.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires)
+		err := rows.Scan(&s.ID, &s.Slug, &s.UserID, &s.Title, &s.Visibility, &s.Created, nullTime{&s.Expires}, tagScanner{&s.Tags})
 		if err != nil {
-			return nil, err
+			return nil, PageLinks{}, err
 		}
 		// Add snippet to slice
 		snippets = append(snippets, s)
@@ -104,10 +517,11 @@ func (m *SnippetModel) Latest() ([]Snippet, error) {
 
 	// Check for any errors that occurred during iteration
 	if err := rows.Err(); err != nil {
-		return nil, err
+		return nil, PageLinks{}, err
 	}
 
-	return snippets, nil
+	snippets, links := paginate(snippets, pr, snippetCursor)
+	return snippets, links, nil
 
 	// stmt := `select id, title, content, created, expires from snippets where expires > UTC_TIMESTAMP() ORDER BY ID DESC LIMIT 10`
 
@@ -138,3 +552,400 @@ func (m *SnippetModel) Latest() ([]Snippet, error) {
 	// }
 	// return snippets, err
 }
+
+// ByUser returns a page of the non-expired snippets created by the given
+// user, newest first, along with the links to the pages either side
+func (m *SnippetModel) ByUser(userID int, pr PageRequest) ([]Snippet, PageLinks, error) {
+	stmt := `SELECT s.id, s.slug, s.user_id, s.title, s.visibility, s.created, s.expires, ` + tagList + ` 
+	         FROM snippets s 
+	         WHERE (s.expires IS NULL OR s.expires > UTC_TIMESTAMP()) AND s.deleted_at IS NULL AND s.user_id = ?`
+	args := []any{userID}
+
+	cond, condArgs := pr.condition()
+	order, orderArgs := pr.order("s.")
+	stmt += cond + order
+	args = append(args, condArgs...)
+	args = append(args, orderArgs...)
+
+	rows, err := m.DB.Query(stmt, args...)
+	if err != nil {
+		return nil, PageLinks{}, err
+	}
+	defer rows.Close()
+
+	var snippets []Snippet
+
+	for rows.Next() {
+		var s Snippet
+		err := rows.Scan(&s.ID, &s.Slug, &s.UserID, &s.Title, &s.Visibility, &s.Created, nullTime{&s.Expires}, tagScanner{&s.Tags})
+		if err != nil {
+			return nil, PageLinks{}, err
+		}
+		snippets = append(snippets, s)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, PageLinks{}, err
+	}
+
+	snippets, links := paginate(snippets, pr, snippetCursor)
+	return snippets, links, nil
+}
+
+// PublicByUser returns a page of the snippets listed on a user's profile,
+// newest first, along with the links to the pages either side. Like Latest,
+// only live public snippets that aren't burn after reading are included.
+func (m *SnippetModel) PublicByUser(userID int, pr PageRequest) ([]Snippet, PageLinks, error) {
+	stmt := `SELECT s.id, s.slug, s.user_id, s.title, s.visibility, s.created, s.expires, ` + tagList + `, ` + starCount + ` 
+	         FROM snippets s 
+	         WHERE (s.expires IS NULL OR s.expires > UTC_TIMESTAMP()) AND s.deleted_at IS NULL AND s.visibility = 'public' 
+	         AND NOT s.burn_after_reading AND s.user_id = ?`
+	args := []any{userID}
+
+	cond, condArgs := pr.condition()
+	order, orderArgs := pr.order("s.")
+	stmt += cond + order
+	args = append(args, condArgs...)
+	args = append(args, orderArgs...)
+
+	rows, err := m.DB.Query(stmt, args...)
+	if err != nil {
+		return nil, PageLinks{}, err
+	}
+	defer rows.Close()
+
+	var snippets []Snippet
+
+	for rows.Next() {
+		var s Snippet
+		err := rows.Scan(&s.ID, &s.Slug, &s.UserID, &s.Title, &s.Visibility, &s.Created, nullTime{&s.Expires}, tagScanner{&s.Tags}, &s.Stars)
+		if err != nil {
+			return nil, PageLinks{}, err
+		}
+		snippets = append(snippets, s)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, PageLinks{}, err
+	}
+
+	snippets, links := paginate(snippets, pr, snippetCursor)
+	return snippets, links, nil
+}
+
+// SearchFilters narrow down the results of a search. Zero values don't
+// filter anything.
+type SearchFilters struct {
+	Language string    // Only match files in this language
+	Author   string    // Only match snippets by the user with this handle
+	From     time.Time // Only match snippets created at or after this time
+	To       time.Time // Only match snippets created before this time
+}
+
+// SearchResult is a snippet found by Search along with the file that
+// matched the query best, for showing an excerpt of
+type SearchResult struct {
+	Snippet
+	FileName    string
+	FileContent string
+}
+
+// Search finds snippets whose title or current files match query using the
+// FULLTEXT indexes, and returns a page of them, newest first, along with the
+// links to the pages either side. Only public snippets and the viewer's own
+// are searched, and burn after reading and passphrase protected snippets are
+// left out unless they're the viewer's, since an excerpt would give their
+// content away.
+func (m *SnippetModel) Search(query string, filters SearchFilters, pr PageRequest, viewerID int) ([]SearchResult, PageLinks, error) {
+	// The file shown for each snippet is its best match, which ROW_NUMBER()
+	// puts first
+	stmt := `SELECT id, slug, user_id, author, author_handle, title, visibility, created, expires, file_name, file_content 
+	         FROM ( 
+	             SELECT s.id, s.slug, COALESCE(s.user_id, 0) AS user_id, COALESCE(u.name, '') AS author, COALESCE(u.handle, '') AS author_handle, s.title, s.visibility, s.created, s.expires, 
+	             f.name AS file_name, f.content AS file_content, 
+	             ROW_NUMBER() OVER (PARTITION BY s.id ORDER BY MATCH(f.name, f.content) AGAINST(?) DESC, f.position) AS n 
+	             FROM snippets s 
+	             LEFT JOIN users u ON u.id = s.user_id 
+	             INNER JOIN snippet_files f ON f.snippet_id = s.id AND f.revision = s.revision 
+	             WHERE (s.expires IS NULL OR s.expires > UTC_TIMESTAMP()) AND s.deleted_at IS NULL 
+	             AND (s.visibility = 'public' OR s.user_id = ?) 
+	             AND ((NOT s.burn_after_reading AND s.hashed_passphrase IS NULL) OR s.user_id = ?) 
+	             AND (MATCH(s.title) AGAINST(?) OR MATCH(f.name, f.content) AGAINST(?))`
+	args := []any{query, viewerID, viewerID, query, query}
+
+	if filters.Language != "" {
+		stmt += ` AND f.language = ?`
+		args = append(args, filters.Language)
+	}
+	if filters.Author != "" {
+		stmt += ` AND u.handle = ?`
+		args = append(args, filters.Author)
+	}
+	if !filters.From.IsZero() {
+		stmt += ` AND s.created >= ?`
+		args = append(args, filters.From.UTC())
+	}
+	if !filters.To.IsZero() {
+		stmt += ` AND s.created < ?`
+		args = append(args, filters.To.UTC())
+	}
+
+	cond, condArgs := pr.condition()
+	order, orderArgs := pr.order("")
+	stmt += cond + ` 
+	         ) r 
+	         WHERE n = 1` + order
+	args = append(args, condArgs...)
+	args = append(args, orderArgs...)
+
+	rows, err := m.DB.Query(stmt, args...)
+	if err != nil {
+		return nil, PageLinks{}, err
+	}
+	defer rows.Close()
+
+	var results []SearchResult
+
+	for rows.Next() {
+		var r SearchResult
+		err := rows.Scan(&r.ID, &r.Slug, &r.UserID, &r.AuthorName, &r.AuthorHandle, &r.Title, &r.Visibility, &r.Created, nullTime{&r.Expires}, &r.FileName, &r.FileContent)
+		if err != nil {
+			return nil, PageLinks{}, err
+		}
+		results = append(results, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, PageLinks{}, err
+	}
+
+	results, links := paginate(results, pr, func(r SearchResult) Cursor {
+		return snippetCursor(r.Snippet)
+	})
+	return results, links, nil
+}
+
+// Delete moves a snippet owned by userID to the trash. Deleted snippets are
+// hidden from every other query until they're restored.
+// Returns ErrNoRecord if the user doesn't own a live snippet with that slug.
+func (m *SnippetModel) Delete(slug string, userID int) error {
+	stmt := `UPDATE snippets SET deleted_at = UTC_TIMESTAMP() 
+	         WHERE deleted_at IS NULL AND slug = ? AND user_id = ?`
+
+	result, err := m.DB.Exec(stmt, slug, userID)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNoRecord
+	}
+
+	return nil
+}
+
+// Restore takes a snippet owned by userID back out of the trash, as long as
+// it was deleted within the retention period.
+// Returns ErrNoRecord if there's no such snippet in the user's trash.
+func (m *SnippetModel) Restore(slug string, userID int) error {
+	stmt := `UPDATE snippets SET deleted_at = NULL 
+	         WHERE deleted_at > DATE_SUB(UTC_TIMESTAMP(), INTERVAL ? DAY) AND slug = ? AND user_id = ?`
+
+	result, err := m.DB.Exec(stmt, TrashRetentionDays, slug, userID)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNoRecord
+	}
+
+	return nil
+}
+
+// Trash returns the user's deleted snippets that can still be restored,
+// most recently deleted first
+func (m *SnippetModel) Trash(userID int) ([]Snippet, error) {
+	stmt := `SELECT id, slug, user_id, title, created, expires, deleted_at 
+	         FROM snippets 
+	         WHERE deleted_at > DATE_SUB(UTC_TIMESTAMP(), INTERVAL ? DAY) AND user_id = ? 
+	         ORDER BY deleted_at DESC`
+
+	rows, err := m.DB.Query(stmt, TrashRetentionDays, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var snippets []Snippet
+
+	for rows.Next() {
+		var s Snippet
+		err := rows.Scan(&s.ID, &s.Slug, &s.UserID, &s.Title, &s.Created, nullTime{&s.Expires}, &s.Deleted)
+		if err != nil {
+			return nil, err
+		}
+		snippets = append(snippets, s)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return snippets, nil
+}
+
+// Purge permanently removes snippets that have been in the trash for longer
+// than the retention period, or that expired more than that long ago.
+// Returns the number of snippets removed.
+func (m *SnippetModel) Purge() (int, error) {
+	stmt := `DELETE FROM snippets 
+	         WHERE deleted_at < DATE_SUB(UTC_TIMESTAMP(), INTERVAL ? DAY) 
+	         OR expires < DATE_SUB(UTC_TIMESTAMP(), INTERVAL ? DAY)`
+
+	result, err := m.DB.Exec(stmt, TrashRetentionDays, TrashRetentionDays)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(n), nil
+}
+
+// Update saves a new title, set of files and tags for a snippet owned by
+// userID. The previous title is copied into snippet_revisions and the files
+// of every revision are kept, so earlier versions can still be viewed and
+// diffed. Tags aren't part of revisions.
+// Burn after reading snippets can't be edited.
+// Returns ErrNoRecord if the user doesn't own a live snippet with that slug.
+func (m *SnippetModel) Update(slug string, userID int, title string, files []File, tags []string) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock the row so concurrent edits can't both write the same revision number
+	stmt := `SELECT id, revision, title, updated 
+	         FROM snippets 
+	         WHERE (expires IS NULL OR expires > UTC_TIMESTAMP()) AND deleted_at IS NULL AND NOT burn_after_reading 
+	         AND slug = ? AND user_id = ? 
+	         FOR UPDATE`
+
+	var prev Revision
+	err = tx.QueryRow(stmt, slug, userID).Scan(&prev.SnippetID, &prev.Number, &prev.Title, &prev.Created)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoRecord
+		}
+		return err
+	}
+
+	stmt = `INSERT INTO snippet_revisions(snippet_id,revision,title,created) 
+	        VALUES(?,?,?,?)`
+
+	_, err = tx.Exec(stmt, prev.SnippetID, prev.Number, prev.Title, prev.Created)
+	if err != nil {
+		return err
+	}
+
+	err = insertFiles(tx, prev.SnippetID, prev.Number+1, files)
+	if err != nil {
+		return err
+	}
+
+	err = setTags(tx, prev.SnippetID, tags)
+	if err != nil {
+		return err
+	}
+
+	stmt = `UPDATE snippets 
+	        SET title = ?, revision = revision + 1, updated = UTC_TIMESTAMP() 
+	        WHERE id = ?`
+
+	_, err = tx.Exec(stmt, title, prev.SnippetID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Revisions returns every version of a snippet, including the current one,
+// newest first, without their files. The same visibility rules as Get apply.
+func (m *SnippetModel) Revisions(id, viewerID int) ([]Revision, error) {
+	stmt := `SELECT r.snippet_id, r.revision, r.title, r.created 
+	         FROM snippet_revisions r 
+	         INNER JOIN snippets s ON s.id = r.snippet_id 
+	         WHERE (s.expires IS NULL OR s.expires > UTC_TIMESTAMP()) AND s.deleted_at IS NULL 
+	         AND (s.visibility <> 'private' OR s.user_id = ?) AND s.id = ? 
+	         UNION ALL 
+	         SELECT s.id, s.revision, s.title, s.updated 
+	         FROM snippets s 
+	         WHERE (s.expires IS NULL OR s.expires > UTC_TIMESTAMP()) AND s.deleted_at IS NULL 
+	         AND (s.visibility <> 'private' OR s.user_id = ?) AND s.id = ? 
+	         ORDER BY revision DESC`
+
+	rows, err := m.DB.Query(stmt, viewerID, id, viewerID, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []Revision
+
+	for rows.Next() {
+		var r Revision
+		err := rows.Scan(&r.SnippetID, &r.Number, &r.Title, &r.Created)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return revisions, nil
+}
+
+// GetRevision retrieves a previous version of a snippet. The current version
+// lives in the snippets table, so callers should use Get for that.
+// Returns ErrNoRecord if the revision doesn't exist or the snippet is hidden
+// from the viewer.
+func (m *SnippetModel) GetRevision(id, number, viewerID int) (Revision, error) {
+	stmt := `SELECT r.snippet_id, r.revision, r.title, r.created 
+	         FROM snippet_revisions r 
+	         INNER JOIN snippets s ON s.id = r.snippet_id 
+	         WHERE (s.expires IS NULL OR s.expires > UTC_TIMESTAMP()) AND s.deleted_at IS NULL 
+	         AND (s.visibility <> 'private' OR s.user_id = ?) AND s.id = ? AND r.revision = ?`
+
+	var r Revision
+	err := m.DB.QueryRow(stmt, viewerID, id, number).Scan(&r.SnippetID, &r.Number, &r.Title, &r.Created)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Revision{}, ErrNoRecord
+		}
+		return Revision{}, err
+	}
+
+	r.Files, err = files(m.DB, r.SnippetID, r.Number)
+	if err != nil {
+		return Revision{}, err
+	}
+
+	return r, nil
+}
@@ -0,0 +1,257 @@
+package models
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConsume(t *testing.T) {
+	db := newTestDB(t)
+	users := &UserModel{DB: db}
+	m := &SnippetModel{DB: db}
+
+	author := newTestUser(t, users, "alice", "pa55word!")
+	reader := newTestUser(t, users, "bob", "pa55word!")
+	files := []File{{Name: "secret.txt", Content: "The launch code is 0000"}}
+
+	slug, err := m.Insert(author, "Secret", files, nil, time.Time{}, VisibilityUnlisted, true, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Viewing a burn after reading snippet mustn't give its content away
+	s, err := m.Get(slug, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Files) != 0 {
+		t.Errorf("Get returned %d files of a burn after reading snippet; want none", len(s.Files))
+	}
+
+	// Its author can see the content without destroying it
+	s, err = m.Get(slug, author)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Files) != 1 || s.Files[0].Content != files[0].Content {
+		t.Errorf("Get returned files %+v to the author; want %+v", s.Files, files)
+	}
+
+	s, err = m.Consume(slug, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Files) != 1 || s.Files[0].Content != files[0].Content {
+		t.Errorf("got files %+v; want %+v", s.Files, files)
+	}
+
+	tests := []struct {
+		name string
+		read func() error
+	}{
+		{"Consuming again", func() error { _, err := m.Consume(slug, reader); return err }},
+		{"Consuming as the author", func() error { _, err := m.Consume(slug, author); return err }},
+		{"Getting it", func() error { _, err := m.Get(slug, reader); return err }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.read(); !errors.Is(err, ErrNoRecord) {
+				t.Errorf("got %v; want %v", err, ErrNoRecord)
+			}
+		})
+	}
+
+	// No copy of the content may be left behind
+	for _, table := range []string{"snippet_files", "snippet_revisions"} {
+		var n int
+		err := db.QueryRow(`SELECT COUNT(*) FROM `+table+` WHERE snippet_id = ?`, s.ID).Scan(&n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 0 {
+			t.Errorf("%d rows left in %s", n, table)
+		}
+	}
+}
+
+func TestConsumeConcurrently(t *testing.T) {
+	db := newTestDB(t)
+	users := &UserModel{DB: db}
+	m := &SnippetModel{DB: db}
+
+	author := newTestUser(t, users, "alice", "pa55word!")
+	slug, err := m.Insert(author, "Secret", []File{{Name: "secret.txt", Content: "Only once"}}, nil, time.Time{}, VisibilityUnlisted, true, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const readers = 10
+	errs := make(chan error, readers)
+	var wg sync.WaitGroup
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := m.Consume(slug, 0)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	var read int
+	for err := range errs {
+		switch {
+		case err == nil:
+			read++
+		case !errors.Is(err, ErrNoRecord):
+			t.Errorf("got %v; want nil or %v", err, ErrNoRecord)
+		}
+	}
+	if read != 1 {
+		t.Errorf("snippet was read %d times; want once", read)
+	}
+}
+
+func TestConsumeOnlyBurnsWhatItShould(t *testing.T) {
+	db := newTestDB(t)
+	users := &UserModel{DB: db}
+	m := &SnippetModel{DB: db}
+
+	author := newTestUser(t, users, "alice", "pa55word!")
+	reader := newTestUser(t, users, "bob", "pa55word!")
+	files := []File{{Name: "a.txt", Content: "a"}}
+
+	tests := []struct {
+		name       string
+		visibility string
+		burn       bool
+		viewerID   int
+	}{
+		{"Ordinary snippet", VisibilityPublic, false, reader},
+		{"Someone else's private snippet", VisibilityPrivate, true, reader},
+		{"Anonymous viewer of a private snippet", VisibilityPrivate, true, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			slug, err := m.Insert(author, tt.name, files, nil, time.Time{}, tt.visibility, tt.burn, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = m.Consume(slug, tt.viewerID)
+			if !errors.Is(err, ErrNoRecord) {
+				t.Fatalf("got %v; want %v", err, ErrNoRecord)
+			}
+
+			// The author can still see it, files and all
+			s, err := m.Get(slug, author)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(s.Files) != 1 {
+				t.Errorf("got %d files; want 1", len(s.Files))
+			}
+			if tt.burn {
+				if _, err := m.Consume(slug, author); err != nil {
+					t.Errorf("author couldn't consume it: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestUnlock(t *testing.T) {
+	db := newTestDB(t)
+	users := &UserModel{DB: db}
+	m := &SnippetModel{DB: db}
+
+	author := newTestUser(t, users, "alice", "pa55word!")
+	slug, err := m.Insert(author, "Protected", []File{{Name: "a.txt", Content: "a"}}, nil, time.Time{}, VisibilityUnlisted, false, "open sesame")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const right, wrong = "open sesame", "open barley"
+
+	// Each step is an attempt and the result it should get
+	type attempt struct {
+		passphrase string
+		want       error
+	}
+	var steps []attempt
+
+	// A right passphrase resets the count, so one short of the limit twice
+	// doesn't lock anyone out
+	for i := 0; i < 2; i++ {
+		for j := 1; j < MaxUnlockAttempts; j++ {
+			steps = append(steps, attempt{wrong, ErrInvalidCredentials})
+		}
+		steps = append(steps, attempt{right, nil})
+	}
+
+	// Reaching the limit locks the snippet, even for the right passphrase
+	for j := 0; j < MaxUnlockAttempts; j++ {
+		steps = append(steps, attempt{wrong, ErrInvalidCredentials})
+	}
+	steps = append(steps,
+		attempt{right, ErrTooManyAttempts},
+		attempt{wrong, ErrTooManyAttempts},
+		attempt{right, ErrTooManyAttempts},
+	)
+
+	for i, step := range steps {
+		err := m.Unlock(slug, 0, step.passphrase)
+		if !errors.Is(err, step.want) {
+			t.Fatalf("attempt %d with %q: got %v; want %v", i+1, step.passphrase, err, step.want)
+		}
+	}
+
+	// Guesses made during the lockout don't extend it or count afterwards
+	var failures int
+	err = db.QueryRow(`SELECT unlock_failures FROM snippets WHERE slug = ?`, slug).Scan(&failures)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failures != 0 {
+		t.Errorf("%d failures counted during the lockout; want 0", failures)
+	}
+
+	// Once the lockout is over the right passphrase works again
+	_, err = db.Exec(`UPDATE snippets SET unlock_retry_at = DATE_SUB(UTC_TIMESTAMP(), INTERVAL 1 SECOND) WHERE slug = ?`, slug)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Unlock(slug, 0, right); err != nil {
+		t.Errorf("got %v after the lockout; want nil", err)
+	}
+}
+
+func TestUnlockLocksPerSnippet(t *testing.T) {
+	db := newTestDB(t)
+	users := &UserModel{DB: db}
+	m := &SnippetModel{DB: db}
+
+	author := newTestUser(t, users, "alice", "pa55word!")
+	var slugs []string
+	for _, title := range []string{"Locked", "Other"} {
+		slug, err := m.Insert(author, title, []File{{Name: "a.txt", Content: "a"}}, nil, time.Time{}, VisibilityUnlisted, false, "open sesame")
+		if err != nil {
+			t.Fatal(err)
+		}
+		slugs = append(slugs, slug)
+	}
+
+	for i := 0; i < MaxUnlockAttempts; i++ {
+		m.Unlock(slugs[0], 0, "wrong")
+	}
+	if err := m.Unlock(slugs[0], 0, "open sesame"); !errors.Is(err, ErrTooManyAttempts) {
+		t.Errorf("locked snippet: got %v; want %v", err, ErrTooManyAttempts)
+	}
+	if err := m.Unlock(slugs[1], 0, "open sesame"); err != nil {
+		t.Errorf("other snippet: got %v; want nil", err)
+	}
+}
@@ -0,0 +1,21 @@
+package models
+
+// starCount is a subquery counting the stars of the snippet aliased s
+const starCount = `(SELECT COUNT(*) FROM snippet_stars st WHERE st.snippet_id = s.id)`
+
+// Star records that a user starred a snippet. Starring a snippet twice
+// is not an error. Callers should check the user can see the snippet first.
+func (m *SnippetModel) Star(snippetID, userID int) error {
+	stmt := `INSERT IGNORE INTO snippet_stars (user_id, snippet_id, created) VALUES (?,?,UTC_TIMESTAMP())`
+
+	_, err := m.DB.Exec(stmt, userID, snippetID)
+	return err
+}
+
+// Unstar removes a user's star from a snippet, if they had given it one
+func (m *SnippetModel) Unstar(snippetID, userID int) error {
+	stmt := `DELETE FROM snippet_stars WHERE user_id = ? AND snippet_id = ?`
+
+	_, err := m.DB.Exec(stmt, userID, snippetID)
+	return err
+}
@@ -0,0 +1,155 @@
+package models
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// MaxTags is the most tags a single snippet can have
+const MaxTags = 10
+
+// TagCount is a tag along with the number of live public snippets that
+// have it
+type TagCount struct {
+	Name  string
+	Count int
+}
+
+// tagList selects the tags of the snippet aliased as s, in alphabetical
+// order and separated by spaces. Tags can't contain spaces themselves.
+const tagList = `(SELECT GROUP_CONCAT(t.name ORDER BY t.name SEPARATOR ' ') 
+	FROM snippet_tags st INNER JOIN tags t ON t.id = st.tag_id WHERE st.snippet_id = s.id)`
+
+// tagScanner scans a column selected with tagList into a slice of tags
+type tagScanner struct {
+	tags *[]string
+}
+
+func (ts tagScanner) Scan(value any) error {
+	var list sql.NullString
+	if err := list.Scan(value); err != nil {
+		return err
+	}
+	*ts.tags = strings.Fields(list.String)
+	return nil
+}
+
+// setTags replaces the tags of a snippet, creating any tags that don't
+// exist yet
+func setTags(tx *sql.Tx, snippetID int, tags []string) error {
+	stmt := `DELETE FROM snippet_tags WHERE snippet_id = ?`
+
+	_, err := tx.Exec(stmt, snippetID)
+	if err != nil {
+		return err
+	}
+
+	for _, tag := range tags {
+		// LAST_INSERT_ID(id) makes LastInsertId return the ID of an
+		// existing tag too
+		stmt = `INSERT INTO tags(name) VALUES(?) 
+		        ON DUPLICATE KEY UPDATE id = LAST_INSERT_ID(id)`
+
+		result, err := tx.Exec(stmt, tag)
+		if err != nil {
+			return err
+		}
+
+		tagID, err := result.LastInsertId()
+		if err != nil {
+			return err
+		}
+
+		stmt = `INSERT INTO snippet_tags(snippet_id,tag_id) VALUES(?,?)`
+
+		_, err = tx.Exec(stmt, snippetID, tagID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ByTag returns a page of the non-expired public snippets with the given
+// tag, newest first, along with the links to the pages either side
+func (m *SnippetModel) ByTag(tag string, pr PageRequest) ([]Snippet, PageLinks, error) {
+	stmt := `SELECT s.id, s.slug, COALESCE(s.user_id, 0), s.title, s.visibility, s.created, s.expires, ` + tagList + ` 
+	         FROM snippets s 
+	         INNER JOIN snippet_tags bt ON bt.snippet_id = s.id 
+	         INNER JOIN tags tag ON tag.id = bt.tag_id 
+	         WHERE (s.expires IS NULL OR s.expires > UTC_TIMESTAMP()) AND s.deleted_at IS NULL AND s.visibility = 'public' 
+	         AND NOT s.burn_after_reading AND tag.name = ?`
+	args := []any{tag}
+
+	cond, condArgs := pr.condition()
+	order, orderArgs := pr.order("s.")
+	stmt += cond + order
+	args = append(args, condArgs...)
+	args = append(args, orderArgs...)
+
+	rows, err := m.DB.Query(stmt, args...)
+	if err != nil {
+		return nil, PageLinks{}, err
+	}
+	defer rows.Close()
+
+	var snippets []Snippet
+
+	for rows.Next() {
+		var s Snippet
+		err := rows.Scan(&s.ID, &s.Slug, &s.UserID, &s.Title, &s.Visibility, &s.Created, nullTime{&s.Expires}, tagScanner{&s.Tags})
+		if err != nil {
+			return nil, PageLinks{}, err
+		}
+		snippets = append(snippets, s)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, PageLinks{}, err
+	}
+
+	snippets, links := paginate(snippets, pr, snippetCursor)
+	return snippets, links, nil
+}
+
+// TagCloud returns the tags of live public snippets with how many snippets
+// have each, in alphabetical order. Only the limit most used tags are
+// returned.
+func (m *SnippetModel) TagCloud(limit int) ([]TagCount, error) {
+	stmt := `SELECT name, n FROM ( 
+	             SELECT t.name, COUNT(*) AS n 
+	             FROM tags t 
+	             INNER JOIN snippet_tags st ON st.tag_id = t.id 
+	             INNER JOIN snippets s ON s.id = st.snippet_id 
+	             WHERE (s.expires IS NULL OR s.expires > UTC_TIMESTAMP()) AND s.deleted_at IS NULL AND s.visibility = 'public' 
+	             AND NOT s.burn_after_reading 
+	             GROUP BY t.id, t.name 
+	             ORDER BY n DESC, t.name 
+	             LIMIT ? 
+	         ) c 
+	         ORDER BY name`
+
+	rows, err := m.DB.Query(stmt, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tags []TagCount
+
+	for rows.Next() {
+		var t TagCount
+		err := rows.Scan(&t.Name, &t.Count)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, t)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
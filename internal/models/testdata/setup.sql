@@ -0,0 +1,166 @@
+-- Schema for tests, matching the one in the readme. Run against an empty
+-- database; teardown.sql drops it again.
+
+CREATE TABLE snippets (
+    id INT NOT NULL AUTO_INCREMENT,
+    slug CHAR(11) NOT NULL,
+    user_id INT NULL, -- NULL once the author has deleted their account but kept the snippet
+    title VARCHAR(100) NOT NULL,
+    visibility ENUM('public', 'unlisted', 'private') NOT NULL DEFAULT 'public',
+    burn_after_reading BOOLEAN NOT NULL DEFAULT FALSE,
+    hashed_passphrase CHAR(60) NULL,
+    unlock_failures INT NOT NULL DEFAULT 0,
+    unlock_retry_at DATETIME NULL,
+    revision INT NOT NULL DEFAULT 1,
+    created DATETIME NOT NULL,
+    updated DATETIME NOT NULL,
+    expires DATETIME NULL,
+    deleted_at DATETIME NULL,
+    PRIMARY KEY (id),
+    CONSTRAINT snippets_uc_slug UNIQUE (slug),
+    INDEX idx_created (created),
+    INDEX idx_user_id (user_id),
+    FULLTEXT INDEX snippets_ft_title (title)
+);
+
+CREATE TABLE snippet_revisions (
+    id INT NOT NULL AUTO_INCREMENT,
+    snippet_id INT NOT NULL,
+    revision INT NOT NULL,
+    title VARCHAR(100) NOT NULL,
+    created DATETIME NOT NULL,
+    PRIMARY KEY (id),
+    CONSTRAINT snippet_revisions_uc_revision UNIQUE (snippet_id, revision),
+    FOREIGN KEY (snippet_id) REFERENCES snippets(id) ON DELETE CASCADE
+);
+
+-- The files of every revision of a snippet, in order
+CREATE TABLE snippet_files (
+    id INT NOT NULL AUTO_INCREMENT,
+    snippet_id INT NOT NULL,
+    revision INT NOT NULL,
+    position INT NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    language VARCHAR(20) NOT NULL DEFAULT 'plaintext',
+    content MEDIUMTEXT NOT NULL,
+    PRIMARY KEY (id),
+    CONSTRAINT snippet_files_uc_position UNIQUE (snippet_id, revision, position),
+    FULLTEXT INDEX snippet_files_ft_content (name, content),
+    FOREIGN KEY (snippet_id) REFERENCES snippets(id) ON DELETE CASCADE
+);
+
+CREATE TABLE tags (
+    id INT NOT NULL AUTO_INCREMENT,
+    name VARCHAR(30) NOT NULL,
+    PRIMARY KEY (id),
+    CONSTRAINT tags_uc_name UNIQUE (name)
+);
+
+CREATE TABLE snippet_tags (
+    snippet_id INT NOT NULL,
+    tag_id INT NOT NULL,
+    PRIMARY KEY (snippet_id, tag_id),
+    INDEX idx_tag_id (tag_id),
+    FOREIGN KEY (snippet_id) REFERENCES snippets(id) ON DELETE CASCADE,
+    FOREIGN KEY (tag_id) REFERENCES tags(id)
+);
+
+CREATE TABLE users (
+    id INT NOT NULL AUTO_INCREMENT,
+    name VARCHAR(255) NOT NULL,
+    handle VARCHAR(30) NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    hashed_password CHAR(60) NOT NULL,
+    bio VARCHAR(500) NOT NULL DEFAULT '',
+    session_version INT NOT NULL DEFAULT 0, -- Bumped to log out every session
+    email_verified_at DATETIME NULL,
+    totp_secret VARBINARY(128) NULL, -- Encrypted; NULL when two-factor authentication is off
+    totp_last_step BIGINT NOT NULL DEFAULT 0, -- Time step of the last code accepted, to stop replays
+    created DATETIME NOT NULL,
+    PRIMARY KEY (id),
+    CONSTRAINT users_uc_email UNIQUE (email),
+    CONSTRAINT users_uc_handle UNIQUE (handle)
+);
+
+-- Avatars are stored in the database rather than linked to, since the
+-- Content-Security-Policy only allows images from the site itself
+CREATE TABLE user_avatars (
+    user_id INT NOT NULL,
+    content_type VARCHAR(20) NOT NULL,
+    image MEDIUMBLOB NOT NULL,
+    updated DATETIME NOT NULL,
+    PRIMARY KEY (user_id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Email changes waiting for the new address to be confirmed
+CREATE TABLE email_changes (
+    user_id INT NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    token_hash CHAR(64) NOT NULL,
+    expires DATETIME NOT NULL,
+    PRIMARY KEY (user_id),
+    CONSTRAINT email_changes_uc_token_hash UNIQUE (token_hash),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Failed logins per throttle key (an email or IP address), stored hashed.
+-- Only used with -throttle-store=mysql.
+CREATE TABLE throttle_failures (
+    key_hash CHAR(64) NOT NULL,
+    failures INT NOT NULL,
+    last_failure DATETIME NOT NULL,
+    PRIMARY KEY (key_hash),
+    INDEX idx_last_failure (last_failure)
+);
+
+CREATE TABLE password_resets (
+    token_hash CHAR(64) NOT NULL,
+    user_id INT NOT NULL,
+    expires DATETIME NOT NULL,
+    PRIMARY KEY (token_hash),
+    INDEX idx_user_id (user_id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Personal access tokens; only a hash of each is kept
+CREATE TABLE api_tokens (
+    id INT NOT NULL AUTO_INCREMENT,
+    user_id INT NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    scope ENUM('read', 'write') NOT NULL,
+    token_hash CHAR(64) NOT NULL,
+    created DATETIME NOT NULL,
+    last_used DATETIME NULL,
+    PRIMARY KEY (id),
+    CONSTRAINT api_tokens_uc_token_hash UNIQUE (token_hash),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE user_recovery_codes (
+    user_id INT NOT NULL,
+    code_hash CHAR(64) NOT NULL,
+    PRIMARY KEY (user_id, code_hash),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE snippet_stars (
+    user_id INT NOT NULL,
+    snippet_id INT NOT NULL,
+    created DATETIME NOT NULL,
+    PRIMARY KEY (user_id, snippet_id),
+    INDEX idx_snippet_id (snippet_id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (snippet_id) REFERENCES snippets(id) ON DELETE CASCADE
+);
+
+CREATE TABLE sessions (
+    token CHAR(43) PRIMARY KEY,
+    data BLOB NOT NULL,
+    expiry TIMESTAMP(6) NOT NULL
+);
+
+CREATE INDEX sessions_expiry_idx ON sessions (expiry);
+
+ALTER TABLE snippets ADD CONSTRAINT snippets_fk_user_id
+    FOREIGN KEY (user_id) REFERENCES users(id);
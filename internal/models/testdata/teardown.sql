@@ -0,0 +1,19 @@
+SET FOREIGN_KEY_CHECKS = 0;
+
+DROP TABLE IF EXISTS
+    snippet_stars,
+    user_recovery_codes,
+    api_tokens,
+    password_resets,
+    throttle_failures,
+    email_changes,
+    user_avatars,
+    snippet_tags,
+    tags,
+    snippet_files,
+    snippet_revisions,
+    snippets,
+    users,
+    sessions;
+
+SET FOREIGN_KEY_CHECKS = 1;
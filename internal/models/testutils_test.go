@@ -0,0 +1,65 @@
+package models
+
+import (
+	"database/sql"
+	"os"
+	"testing"
+
+	_ "github.com/go-sql-driver/mysql"
+)
+
+// newTestDB connects to the test database named by SNIPPETBOX_TEST_DSN and
+// creates the schema in it, dropping it again when the test ends. Tests
+// that need a database are skipped without one. The DSN needs
+// parseTime=true and multiStatements=true.
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	dsn := os.Getenv("SNIPPETBOX_TEST_DSN")
+	if dsn == "" {
+		t.Skip("SNIPPETBOX_TEST_DSN not set")
+	}
+
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runScript := func(name string) {
+		script, err := os.ReadFile("./testdata/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = db.Exec(string(script))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Clear out anything a crashed run left behind
+	runScript("teardown.sql")
+	runScript("setup.sql")
+
+	t.Cleanup(func() {
+		defer db.Close()
+		runScript("teardown.sql")
+	})
+
+	return db
+}
+
+// newTestUser adds a user with a verified email address and returns their ID
+func newTestUser(t *testing.T, m *UserModel, handle, password string) int {
+	t.Helper()
+
+	email := handle + "@example.com"
+	id, err := m.Insert(handle, handle, email, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.VerifyEmail(id, email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
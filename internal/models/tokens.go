@@ -0,0 +1,27 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+// newToken generates a random token to be handed to a user, e.g. in a
+// link, along with the hash of it to be stored. Only the hash is ever kept
+// so a leaked database can't be used to redeem tokens.
+func newToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, tokenHash(token), nil
+}
+
+// tokenHash returns the hex SHA-256 of a token. Tokens are random enough
+// that a fast hash is all that's needed.
+func tokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,155 @@
+package models
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"strings"
+)
+
+// RecoveryCodeCount is how many recovery codes a user gets when they turn on
+// two-factor authentication
+const RecoveryCodeCount = 10
+
+// recoveryAlphabet leaves out characters that are easy to mix up
+const recoveryAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+// newRecoveryCode generates a random code like "k7mq2-x9dfa"
+func newRecoveryCode() (string, error) {
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	// The alphabet has 31 characters, so the modulo bias is slight and the
+	// codes still carry over 49 bits
+	for i := range b {
+		b[i] = recoveryAlphabet[int(b[i])%len(recoveryAlphabet)]
+	}
+	return string(b[:5]) + "-" + string(b[5:]), nil
+}
+
+// NormalizeRecoveryCode puts a recovery code as typed by a user into the
+// form it was issued in, ignoring case, spaces and the hyphen
+func NormalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer(" ", "", "-", "").Replace(code))
+	if len(code) != 10 {
+		return code
+	}
+	return code[:5] + "-" + code[5:]
+}
+
+// TwoFactorSecret returns a user's encrypted TOTP secret along with the last
+// time step a code was accepted for. The secret is nil if two-factor
+// authentication is off.
+func (m *UserModel) TwoFactorSecret(id int) ([]byte, int64, error) {
+	stmt := `SELECT totp_secret, totp_last_step FROM users WHERE id = ?`
+
+	var (
+		secret   []byte
+		lastStep int64
+	)
+	err := m.DB.QueryRow(stmt, id).Scan(&secret, &lastStep)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, 0, ErrNoRecord
+		}
+		return nil, 0, err
+	}
+
+	return secret, lastStep, nil
+}
+
+// EnableTwoFactor turns on two-factor authentication for a user with an
+// encrypted TOTP secret, recording step as used since the code that
+// confirmed the secret was just entered. It returns a fresh set of recovery
+// codes, which are only stored hashed so can't be shown again.
+func (m *UserModel) EnableTwoFactor(id int, encryptedSecret []byte, step int64) ([]string, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	stmt := `UPDATE users SET totp_secret = ?, totp_last_step = ? WHERE id = ?`
+	_, err = tx.Exec(stmt, encryptedSecret, step, id)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(`DELETE FROM user_recovery_codes WHERE user_id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		codes[i], err = newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		_, err = tx.Exec(`INSERT INTO user_recovery_codes (user_id, code_hash) VALUES (?,?)`, id, tokenHash(codes[i]))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return codes, tx.Commit()
+}
+
+// DisableTwoFactor turns off two-factor authentication for a user and
+// throws away their recovery codes
+func (m *UserModel) DisableTwoFactor(id int) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`UPDATE users SET totp_secret = NULL, totp_last_step = 0 WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM user_recovery_codes WHERE user_id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UseTOTPStep records that a code for step has been accepted, so the same
+// code can't be replayed. Reports false if a code for this or a later step
+// was already used.
+func (m *UserModel) UseTOTPStep(id int, step int64) (bool, error) {
+	stmt := `UPDATE users SET totp_last_step = ? WHERE id = ? AND totp_last_step < ?`
+
+	result, err := m.DB.Exec(stmt, step, id, step)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+// UseRecoveryCode uses up one of a user's recovery codes. Reports false if
+// the code isn't one of theirs or was already used.
+func (m *UserModel) UseRecoveryCode(id int, code string) (bool, error) {
+	stmt := `DELETE FROM user_recovery_codes WHERE user_id = ? AND code_hash = ?`
+
+	result, err := m.DB.Exec(stmt, id, tokenHash(NormalizeRecoveryCode(code)))
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+// RecoveryCodesLeft returns how many unused recovery codes a user has
+func (m *UserModel) RecoveryCodesLeft(id int) (int, error) {
+	var n int
+	err := m.DB.QueryRow(`SELECT COUNT(*) FROM user_recovery_codes WHERE user_id = ?`, id).Scan(&n)
+	return n, err
+}
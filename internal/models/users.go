@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"errors"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-sql-driver/mysql"
@@ -13,46 +14,82 @@ import (
 type User struct {
 	ID             int
 	Name           string
+	Handle         string // Unique public name used in profile URLs
 	Email          string
 	HashedPassword []byte
+	Bio            string
+	HasAvatar      bool
+	EmailVerified  bool // Whether the user has followed a link sent to their address
+	TwoFactor      bool // Whether logging in needs a TOTP or recovery code too
 	Created        time.Time
 }
 
+// Profile is a user's public profile along with totals over their public
+// snippets
+type Profile struct {
+	User
+	Snippets int // Number of live public snippets
+	Stars    int // Stars given to those snippets
+}
+
+// MaxAvatarBytes is the largest avatar image that can be uploaded
+const MaxAvatarBytes = 256 << 10
+
 type UserModel struct {
 	DB *sql.DB
 }
 
-func (m *UserModel) Insert(name, email, password string) error {
+func (m *UserModel) Insert(name, handle, email, password string) (int, error) {
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	stmt := `INSERT INTO users (name, email, hashed_password, created) VALUES (?,?,?,UTC_TIMESTAMP())`
+	stmt := `INSERT INTO users (name, handle, email, hashed_password, created) VALUES (?,?,?,?,UTC_TIMESTAMP())`
 
-	_, err = m.DB.Exec(stmt, name, email, string(hashedPassword))
+	result, err := m.DB.Exec(stmt, name, handle, email, string(hashedPassword))
 	if err != nil {
 		var mySQLError *mysql.MySQLError
 		if errors.As(err, &mySQLError) {
 			if mySQLError.Number == 1062 && strings.Contains(mySQLError.Message, "users_uc_email") {
-				return ErrDuplicateEmail
+				return 0, ErrDuplicateEmail
+			}
+			if mySQLError.Number == 1062 && strings.Contains(mySQLError.Message, "users_uc_handle") {
+				return 0, ErrDuplicateHandle
 			}
 		}
-		return err
+		return 0, err
 	}
 
-	return nil
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
 }
 
+// dummyHash is compared against when no user has the email being logged in
+// with, so the response takes as long as for a wrong password and doesn't
+// reveal whether an account exists. It uses the same cost as real hashes.
+var dummyHash = sync.OnceValue(func() []byte {
+	hash, err := bcrypt.GenerateFromPassword([]byte("not anyone's password"), 12)
+	if err != nil {
+		panic(err)
+	}
+	return hash
+})
+
 func (m *UserModel) Authenticate(email, password string) (int, error) {
 
 	var id int
 	var hashedPassword []byte
 
-	stmt := `SELECT hashed_password FROM users WHERE email=?`
+	stmt := `SELECT id, hashed_password FROM users WHERE email=?`
 
-	err := m.DB.QueryRow(stmt, email).Scan(&hashedPassword)
+	err := m.DB.QueryRow(stmt, email).Scan(&id, &hashedPassword)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
+			bcrypt.CompareHashAndPassword(dummyHash(), []byte(password))
 			return 0, ErrInvalidCredentials
 		} else {
 			return 0, err
@@ -74,3 +111,506 @@ func (m *UserModel) Authenticate(email, password string) (int, error) {
 func (m *UserModel) Exists(id int) (bool, error) {
 	return false, nil
 }
+
+// EmailChangeHours is how long the link to confirm a new email address
+// stays valid
+const EmailChangeHours = 24
+
+// SessionVersion returns a number that changes whenever the user's existing
+// sessions should stop working, such as when their password changes. A
+// session is only valid while it was created with the current version.
+// Returns ErrNoRecord if the user doesn't exist, e.g. after deleting their
+// account.
+func (m *UserModel) SessionVersion(id int) (int, error) {
+	stmt := `SELECT session_version FROM users WHERE id = ?`
+
+	var version int
+	err := m.DB.QueryRow(stmt, id).Scan(&version)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrNoRecord
+		}
+		return 0, err
+	}
+
+	return version, nil
+}
+
+// CheckPassword confirms a logged in user's password, for re-authenticating
+// before sensitive changes.
+// Returns ErrInvalidCredentials if the password is wrong.
+func (m *UserModel) CheckPassword(id int, password string) error {
+	stmt := `SELECT hashed_password FROM users WHERE id = ?`
+
+	var hashedPassword []byte
+	err := m.DB.QueryRow(stmt, id).Scan(&hashedPassword)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrInvalidCredentials
+		}
+		return err
+	}
+
+	err = bcrypt.CompareHashAndPassword(hashedPassword, []byte(password))
+	if err != nil {
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return ErrInvalidCredentials
+		}
+		return err
+	}
+
+	return nil
+}
+
+// ChangePassword replaces a user's password once the current one has been
+// verified, bumps their session version so every other session is logged
+// out, and revokes their personal access tokens, in case the password was
+// changed because someone else had it.
+// Returns ErrInvalidCredentials if currentPassword is wrong.
+func (m *UserModel) ChangePassword(id int, currentPassword, newPassword string) error {
+	err := m.CheckPassword(id, currentPassword)
+	if err != nil {
+		return err
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), 12)
+	if err != nil {
+		return err
+	}
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `UPDATE users SET hashed_password = ?, session_version = session_version + 1 WHERE id = ?`
+
+	_, err = tx.Exec(stmt, string(hashedPassword), id)
+	if err != nil {
+		return err
+	}
+
+	// Reset links sent before the change shouldn't undo it
+	_, err = tx.Exec(`DELETE FROM password_resets WHERE user_id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM api_tokens WHERE user_id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RequestEmailChange records that a user wants to change their email address
+// and returns the token to send to the new address to confirm it. Any earlier
+// request is replaced.
+// Returns ErrDuplicateEmail if another user already has the address.
+func (m *UserModel) RequestEmailChange(id int, email string) (string, error) {
+	var taken bool
+	err := m.DB.QueryRow(`SELECT EXISTS(SELECT 1 FROM users WHERE email = ? AND id <> ?)`, email, id).Scan(&taken)
+	if err != nil {
+		return "", err
+	}
+	if taken {
+		return "", ErrDuplicateEmail
+	}
+
+	token, hash, err := newToken()
+	if err != nil {
+		return "", err
+	}
+
+	stmt := `INSERT INTO email_changes (user_id, email, token_hash, expires) 
+	         VALUES (?,?,?,DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? HOUR)) 
+	         ON DUPLICATE KEY UPDATE email = VALUES(email), token_hash = VALUES(token_hash), expires = VALUES(expires)`
+
+	_, err = m.DB.Exec(stmt, id, email, hash, EmailChangeHours)
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// PendingEmail returns the address a user has asked to change their email
+// to, or an empty string if there's no unexpired request
+func (m *UserModel) PendingEmail(id int) (string, error) {
+	stmt := `SELECT email FROM email_changes WHERE user_id = ? AND expires > UTC_TIMESTAMP()`
+
+	var email string
+	err := m.DB.QueryRow(stmt, id).Scan(&email)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", err
+	}
+
+	return email, nil
+}
+
+// ConfirmEmailChange applies the email change a token was issued for. Each
+// token can only be used once. Returns the ID of the user.
+// Returns ErrNoRecord if the token is unknown or has expired, and
+// ErrDuplicateEmail if the address was taken in the meantime.
+func (m *UserModel) ConfirmEmailChange(token string) (int, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	stmt := `SELECT user_id, email FROM email_changes 
+	         WHERE token_hash = ? AND expires > UTC_TIMESTAMP() 
+	         FOR UPDATE`
+
+	var (
+		id    int
+		email string
+	)
+	err = tx.QueryRow(stmt, tokenHash(token)).Scan(&id, &email)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrNoRecord
+		}
+		return 0, err
+	}
+
+	// The link was sent to the new address, so following it verifies it
+	_, err = tx.Exec(`UPDATE users SET email = ?, email_verified_at = UTC_TIMESTAMP() WHERE id = ?`, email, id)
+	if err != nil {
+		var mySQLError *mysql.MySQLError
+		if errors.As(err, &mySQLError) {
+			if mySQLError.Number == 1062 && strings.Contains(mySQLError.Message, "users_uc_email") {
+				return 0, ErrDuplicateEmail
+			}
+		}
+		return 0, err
+	}
+
+	_, err = tx.Exec(`DELETE FROM email_changes WHERE user_id = ?`, id)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, tx.Commit()
+}
+
+// PasswordResetMinutes is how long a password reset link stays valid
+const PasswordResetMinutes = 60
+
+// CreatePasswordReset issues a single-use token for resetting the password
+// of the user with the given email, returning it along with the user's name
+// to address them by. Only a hash of the token is stored.
+// Returns ErrNoRecord if no user has the email.
+func (m *UserModel) CreatePasswordReset(email string) (string, string, error) {
+	var (
+		id   int
+		name string
+	)
+	err := m.DB.QueryRow(`SELECT id, name FROM users WHERE email = ?`, email).Scan(&id, &name)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", "", ErrNoRecord
+		}
+		return "", "", err
+	}
+
+	token, hash, err := newToken()
+	if err != nil {
+		return "", "", err
+	}
+
+	stmt := `INSERT INTO password_resets (token_hash, user_id, expires) 
+	         VALUES (?,?,DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? MINUTE))`
+
+	_, err = m.DB.Exec(stmt, hash, id, PasswordResetMinutes)
+	if err != nil {
+		return "", "", err
+	}
+
+	return token, name, nil
+}
+
+// ValidPasswordReset reports whether a password reset token can still be
+// used
+func (m *UserModel) ValidPasswordReset(token string) (bool, error) {
+	stmt := `SELECT EXISTS(SELECT 1 FROM password_resets WHERE token_hash = ? AND expires > UTC_TIMESTAMP())`
+
+	var valid bool
+	err := m.DB.QueryRow(stmt, tokenHash(token)).Scan(&valid)
+	return valid, err
+}
+
+// ResetPassword sets a new password for the user a reset token was issued
+// to. Every reset token of the user is used up, their session version is
+// bumped and their personal access tokens are revoked, so any session or
+// token an attacker might have stops working.
+// Returns ErrNoRecord if the token is unknown, used or has expired.
+func (m *UserModel) ResetPassword(token, newPassword string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), 12)
+	if err != nil {
+		return err
+	}
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `SELECT user_id FROM password_resets 
+	         WHERE token_hash = ? AND expires > UTC_TIMESTAMP() 
+	         FOR UPDATE`
+
+	var id int
+	err = tx.QueryRow(stmt, tokenHash(token)).Scan(&id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoRecord
+		}
+		return err
+	}
+
+	// The link was emailed to the user, so following it verifies their address
+	stmt = `UPDATE users SET hashed_password = ?, session_version = session_version + 1, 
+	        email_verified_at = COALESCE(email_verified_at, UTC_TIMESTAMP()) WHERE id = ?`
+	_, err = tx.Exec(stmt, string(hashedPassword), id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM password_resets WHERE user_id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM api_tokens WHERE user_id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// PurgePasswordResets removes expired password reset tokens. Returns the
+// number removed.
+func (m *UserModel) PurgePasswordResets() (int, error) {
+	result, err := m.DB.Exec(`DELETE FROM password_resets WHERE expires <= UTC_TIMESTAMP()`)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := result.RowsAffected()
+	return int(n), err
+}
+
+// VerifyEmail marks a user's email address as verified, as long as it's
+// still the given one. Verifying an address twice is not an error.
+// Returns ErrNoRecord if the user doesn't exist or has another address.
+func (m *UserModel) VerifyEmail(id int, email string) error {
+	stmt := `UPDATE users SET email_verified_at = COALESCE(email_verified_at, UTC_TIMESTAMP()) 
+	         WHERE id = ? AND email = ?`
+
+	result, err := m.DB.Exec(stmt, id, email)
+	if err != nil {
+		return err
+	}
+
+	// Rows that already had a date count as unchanged, so check the user
+	// exists before reporting there's nothing to verify
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		var exists bool
+		err = m.DB.QueryRow(`SELECT EXISTS(SELECT 1 FROM users WHERE id = ? AND email = ?)`, id, email).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return ErrNoRecord
+		}
+	}
+
+	return nil
+}
+
+// PurgeUnverified deletes accounts whose email addresses still haven't been
+// verified maxAge after signing up. Unverified users can't create snippets,
+// but any account that somehow has some is left alone. Returns the number of
+// accounts deleted.
+func (m *UserModel) PurgeUnverified(maxAge time.Duration) (int, error) {
+	stmt := `DELETE FROM users 
+	         WHERE email_verified_at IS NULL AND created < DATE_SUB(UTC_TIMESTAMP(), INTERVAL ? SECOND) 
+	         AND NOT EXISTS (SELECT 1 FROM snippets WHERE snippets.user_id = users.id)`
+
+	result, err := m.DB.Exec(stmt, int64(maxAge.Seconds()))
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := result.RowsAffected()
+	return int(n), err
+}
+
+// Delete removes a user's account. With keepSnippets, their live public and
+// unlisted snippets stay up without an author; every other snippet of theirs,
+// including private and trashed ones, is deleted along with the account.
+func (m *UserModel) Delete(id int, keepSnippets bool) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if keepSnippets {
+		stmt := `UPDATE snippets SET user_id = NULL 
+		         WHERE (expires IS NULL OR expires > UTC_TIMESTAMP()) AND deleted_at IS NULL 
+		         AND visibility <> 'private' AND user_id = ?`
+
+		_, err = tx.Exec(stmt, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Files, revisions, tags and stars of the snippets go with them
+	_, err = tx.Exec(`DELETE FROM snippets WHERE user_id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	// Avatars, stars given and pending email changes go with the user
+	_, err = tx.Exec(`DELETE FROM users WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetByEmail retrieves the ID, name and email of the user with an email
+// address
+func (m *UserModel) GetByEmail(email string) (User, error) {
+	stmt := `SELECT id, name, email FROM users WHERE email = ?`
+
+	var u User
+	err := m.DB.QueryRow(stmt, email).Scan(&u.ID, &u.Name, &u.Email)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return User{}, ErrNoRecord
+		}
+		return User{}, err
+	}
+
+	return u, nil
+}
+
+// Get retrieves a user by ID, without their password hash
+func (m *UserModel) Get(id int) (User, error) {
+	stmt := `SELECT id, name, handle, email, bio, EXISTS(SELECT 1 FROM user_avatars WHERE user_id = users.id), 
+	         email_verified_at IS NOT NULL, totp_secret IS NOT NULL, created 
+	         FROM users WHERE id = ?`
+
+	var u User
+	err := m.DB.QueryRow(stmt, id).Scan(&u.ID, &u.Name, &u.Handle, &u.Email, &u.Bio, &u.HasAvatar, &u.EmailVerified, &u.TwoFactor, &u.Created)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return User{}, ErrNoRecord
+		}
+		return User{}, err
+	}
+
+	return u, nil
+}
+
+// Profile looks up a user's public profile by handle. Handles are matched
+// case-insensitively. The totals only count live public snippets, the same
+// ones listed on the profile.
+func (m *UserModel) Profile(handle string) (Profile, error) {
+	stmt := `SELECT u.id, u.name, u.handle, u.bio, EXISTS(SELECT 1 FROM user_avatars WHERE user_id = u.id), u.created, 
+	         COUNT(DISTINCT s.id), COUNT(st.snippet_id) 
+	         FROM users u 
+	         LEFT JOIN snippets s ON s.user_id = u.id 
+	         AND (s.expires IS NULL OR s.expires > UTC_TIMESTAMP()) AND s.deleted_at IS NULL AND s.visibility = 'public' 
+	         AND NOT s.burn_after_reading 
+	         LEFT JOIN snippet_stars st ON st.snippet_id = s.id 
+	         WHERE u.handle = ? 
+	         GROUP BY u.id`
+
+	var p Profile
+	err := m.DB.QueryRow(stmt, handle).Scan(&p.ID, &p.Name, &p.Handle, &p.Bio, &p.HasAvatar, &p.Created, &p.Snippets, &p.Stars)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Profile{}, ErrNoRecord
+		}
+		return Profile{}, err
+	}
+
+	return p, nil
+}
+
+// UpdateProfile changes the public details of a user.
+// Returns ErrDuplicateHandle if another user has the handle.
+func (m *UserModel) UpdateProfile(id int, name, handle, bio string) error {
+	stmt := `UPDATE users SET name = ?, handle = ?, bio = ? WHERE id = ?`
+
+	_, err := m.DB.Exec(stmt, name, handle, bio, id)
+	if err != nil {
+		var mySQLError *mysql.MySQLError
+		if errors.As(err, &mySQLError) {
+			if mySQLError.Number == 1062 && strings.Contains(mySQLError.Message, "users_uc_handle") {
+				return ErrDuplicateHandle
+			}
+		}
+		return err
+	}
+
+	return nil
+}
+
+// SetAvatar stores a user's avatar image, replacing any they had before.
+// The image should already have been checked to be one browsers can show.
+func (m *UserModel) SetAvatar(id int, contentType string, image []byte) error {
+	stmt := `INSERT INTO user_avatars (user_id, content_type, image, updated) VALUES (?,?,?,UTC_TIMESTAMP()) 
+	         ON DUPLICATE KEY UPDATE content_type = VALUES(content_type), image = VALUES(image), updated = VALUES(updated)`
+
+	_, err := m.DB.Exec(stmt, id, contentType, image)
+	return err
+}
+
+// RemoveAvatar deletes a user's avatar image, if they have one
+func (m *UserModel) RemoveAvatar(id int) error {
+	stmt := `DELETE FROM user_avatars WHERE user_id = ?`
+
+	_, err := m.DB.Exec(stmt, id)
+	return err
+}
+
+// Avatar returns the avatar image of the user with a handle, along with its
+// content type and when it was uploaded.
+// Returns ErrNoRecord if there's no such user or they have no avatar.
+func (m *UserModel) Avatar(handle string) ([]byte, string, time.Time, error) {
+	stmt := `SELECT a.image, a.content_type, a.updated 
+	         FROM user_avatars a 
+	         INNER JOIN users u ON u.id = a.user_id 
+	         WHERE u.handle = ?`
+
+	var (
+		image       []byte
+		contentType string
+		updated     time.Time
+	)
+	err := m.DB.QueryRow(stmt, handle).Scan(&image, &contentType, &updated)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, "", time.Time{}, ErrNoRecord
+		}
+		return nil, "", time.Time{}, err
+	}
+
+	return image, contentType, updated, nil
+}
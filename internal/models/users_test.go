@@ -0,0 +1,70 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestPasswordChangesRevokeAPITokens(t *testing.T) {
+	db := newTestDB(t)
+	m := &UserModel{DB: db}
+
+	tests := []struct {
+		name   string
+		change func(t *testing.T, id int, email string) error
+	}{
+		{
+			name: "ChangePassword",
+			change: func(t *testing.T, id int, email string) error {
+				return m.ChangePassword(id, "password123", "new password")
+			},
+		},
+		{
+			name: "ResetPassword",
+			change: func(t *testing.T, id int, email string) error {
+				token, _, err := m.CreatePasswordReset(email)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return m.ResetPassword(token, "new password")
+			},
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handle := fmt.Sprintf("user%d", i)
+			id := newTestUser(t, m, handle, "password123")
+			otherID := newTestUser(t, m, fmt.Sprintf("other%d", i), "password123")
+
+			token, err := m.CreateAPIToken(id, "script", ScopeWrite)
+			if err != nil {
+				t.Fatal(err)
+			}
+			otherToken, err := m.CreateAPIToken(otherID, "script", ScopeRead)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = tt.change(t, id, handle+"@example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, _, err = m.AuthenticateAPIToken(token)
+			if !errors.Is(err, ErrInvalidCredentials) {
+				t.Errorf("token still works after the password changed: err = %v", err)
+			}
+			if n, err := m.APITokenCount(id); err != nil || n != 0 {
+				t.Errorf("user has %d tokens left (err %v); want 0", n, err)
+			}
+
+			// Other users' tokens aren't touched
+			gotID, _, err := m.AuthenticateAPIToken(otherToken)
+			if err != nil || gotID != otherID {
+				t.Errorf("another user's token stopped working: id %d, err %v", gotID, err)
+			}
+		})
+	}
+}
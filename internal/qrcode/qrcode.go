@@ -0,0 +1,166 @@
+// Package qrcode draws QR codes (ISO/IEC 18004) so the app can show them
+// without sending their content to a third-party service. It only supports
+// what the app needs: byte mode, error correction level M and versions 1 to
+// 10, which hold up to 213 bytes.
+package qrcode
+
+import "errors"
+
+// ErrTooLong is returned for text that doesn't fit in a version 10 code
+var ErrTooLong = errors.New("qrcode: text too long")
+
+// Code is a QR code. Dark modules are true.
+type Code struct {
+	Size    int // Width and height in modules, without the quiet zone
+	modules [][]bool
+}
+
+// Dark reports whether the module at x, y is dark. Coordinates outside the
+// code, i.e. in the quiet zone, are light.
+func (c *Code) Dark(x, y int) bool {
+	return x >= 0 && y >= 0 && x < c.Size && y < c.Size && c.modules[y][x]
+}
+
+// Error correction parameters for level M, indexed by version
+var (
+	eccPerBlock = [...]int{0, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26}
+	eccBlocks   = [...]int{0, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5}
+)
+
+const maxVersion = 10
+
+// Encode makes the smallest QR code holding text, with the mask that gives
+// the fewest scanning problems
+func Encode(text string) (*Code, error) {
+	data := []byte(text)
+
+	version := 1
+	for ; version <= maxVersion; version++ {
+		// Mode indicator, character count and the data itself
+		if 4+countBits(version)+8*len(data) <= 8*dataCodewords(version) {
+			break
+		}
+	}
+	if version > maxVersion {
+		return nil, ErrTooLong
+	}
+
+	codewords := addECC(version, encodeData(version, data))
+
+	var best *code
+	bestPenalty := 0
+	for mask := range 8 {
+		c := newCode(version)
+		c.drawCodewords(codewords)
+		c.applyMask(mask)
+		c.drawFormat(mask)
+		if p := c.penalty(); best == nil || p < bestPenalty {
+			best, bestPenalty = c, p
+		}
+	}
+
+	return &Code{Size: best.size, modules: best.modules}, nil
+}
+
+// countBits is the length of the character count in byte mode
+func countBits(version int) int {
+	if version < 10 {
+		return 8
+	}
+	return 16
+}
+
+// rawCodewords is the number of 8-bit codewords a version holds, data and
+// error correction together, with any remainder bits left out
+func rawCodewords(version int) int {
+	modules := (16*version+128)*version + 64
+	if version >= 2 {
+		align := version/7 + 2
+		modules -= (25*align-10)*align - 55
+		if version >= 7 {
+			modules -= 36
+		}
+	}
+	return modules / 8
+}
+
+// dataCodewords is the number of codewords available for data
+func dataCodewords(version int) int {
+	return rawCodewords(version) - eccPerBlock[version]*eccBlocks[version]
+}
+
+// encodeData lays out data in byte mode and pads it to fill the version
+func encodeData(version int, data []byte) []byte {
+	var bits bitBuffer
+	bits.append(0b0100, 4)
+	bits.append(len(data), countBits(version))
+	for _, b := range data {
+		bits.append(int(b), 8)
+	}
+
+	capacity := 8 * dataCodewords(version)
+	bits.append(0, min(4, capacity-bits.len())) // Terminator
+	bits.append(0, (8-bits.len()%8)%8)
+	for pad := 0xEC; bits.len() < capacity; pad ^= 0xEC ^ 0x11 {
+		bits.append(pad, 8)
+	}
+	return bits.bytes
+}
+
+// addECC splits data into blocks, adds the error correction codewords to
+// each and interleaves them
+func addECC(version int, data []byte) []byte {
+	numBlocks, eccLen := eccBlocks[version], eccPerBlock[version]
+	raw := rawCodewords(version)
+	numShort := numBlocks - raw%numBlocks
+	shortLen := raw / numBlocks
+	divisor := rsDivisor(eccLen)
+
+	// Long blocks have one more data codeword than short ones
+	blocks := make([][]byte, numBlocks)
+	k := 0
+	for i := range blocks {
+		n := shortLen - eccLen
+		if i >= numShort {
+			n++
+		}
+		block := append([]byte{}, data[k:k+n]...)
+		k += n
+		ecc := rsRemainder(block, divisor)
+		if i < numShort {
+			block = append(block, 0) // Placeholder, skipped below
+		}
+		blocks[i] = append(block, ecc...)
+	}
+
+	result := make([]byte, 0, raw)
+	for i := range blocks[0] {
+		for j, block := range blocks {
+			if i != shortLen-eccLen || j >= numShort {
+				result = append(result, block[i])
+			}
+		}
+	}
+	return result
+}
+
+// bitBuffer collects bits most significant first
+type bitBuffer struct {
+	bytes []byte
+	n     int
+}
+
+func (b *bitBuffer) len() int { return b.n }
+
+// append adds the low n bits of v
+func (b *bitBuffer) append(v, n int) {
+	for i := n - 1; i >= 0; i-- {
+		if b.n%8 == 0 {
+			b.bytes = append(b.bytes, 0)
+		}
+		if v>>i&1 != 0 {
+			b.bytes[b.n/8] |= 0x80 >> (b.n % 8)
+		}
+		b.n++
+	}
+}
@@ -0,0 +1,104 @@
+// Package ratelimit limits how often each client can make requests, using
+// a token bucket per client. A bucket holds up to a limit's worth of
+// requests and refills steadily, so clients can burst up to the limit but
+// not keep going faster than it.
+package ratelimit
+
+import (
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Limit is how many requests a client can make per period
+type Limit struct {
+	Requests int
+	Per      time.Duration
+}
+
+// rate returns how many tokens a bucket gains per second
+func (l Limit) rate() float64 {
+	return float64(l.Requests) / l.Per.Seconds()
+}
+
+// Policy describes the limit in the form of the RateLimit-Policy header,
+// e.g. "10;w=60" for 10 requests a minute
+func (l Limit) Policy() string {
+	return strconv.Itoa(l.Requests) + ";w=" + strconv.Itoa(int(math.Ceil(l.Per.Seconds())))
+}
+
+// Result is the outcome of asking to make a request
+type Result struct {
+	Allowed    bool
+	Remaining  int           // Requests that could be made straight away
+	Reset      time.Duration // Until the bucket is full again
+	RetryAfter time.Duration // Until a request is allowed, if this one wasn't
+}
+
+// bucket holds a client's tokens as of its last request
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// sweepInterval is how often buckets that have filled up again are thrown
+// away, since they're no different from new ones
+const sweepInterval = time.Minute
+
+// Limiter applies a limit to each client separately
+type Limiter struct {
+	Limit Limit
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// New returns a Limiter for limit
+func New(limit Limit) *Limiter {
+	return &Limiter{Limit: limit, buckets: make(map[string]*bucket)}
+}
+
+// Allow takes a token from the bucket for key at now, if there's one to
+// take
+func (l *Limiter) Allow(key string, now time.Time) Result {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	capacity, rate := float64(l.Limit.Requests), l.Limit.rate()
+
+	if now.Sub(l.lastSweep) >= sweepInterval {
+		for k, b := range l.buckets {
+			if b.tokens+now.Sub(b.last).Seconds()*rate >= capacity {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: capacity, last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = min(capacity, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+
+	result := Result{Allowed: b.tokens >= 1}
+	if result.Allowed {
+		b.tokens--
+	} else {
+		result.RetryAfter = seconds((1 - b.tokens) / rate)
+	}
+	result.Remaining = int(b.tokens)
+	result.Reset = seconds((capacity - b.tokens) / rate)
+
+	return result
+}
+
+// seconds converts a number of seconds to a duration
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,127 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+var t0 = time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func TestAllow(t *testing.T) {
+	// 10 requests a minute refills a token every 6 seconds
+	limit := Limit{Requests: 10, Per: time.Minute}
+
+	type request struct {
+		at   time.Duration // After t0
+		want Result
+	}
+	tests := []struct {
+		name     string
+		requests []request
+	}{
+		{
+			name: "First request",
+			requests: []request{
+				{0, Result{Allowed: true, Remaining: 9, Reset: 6 * time.Second}},
+			},
+		},
+		{
+			name: "Burst to the limit",
+			requests: []request{
+				{0, Result{Allowed: true, Remaining: 9, Reset: 6 * time.Second}},
+				{0, Result{Allowed: true, Remaining: 8, Reset: 12 * time.Second}},
+				{0, Result{Allowed: true, Remaining: 7, Reset: 18 * time.Second}},
+				{0, Result{Allowed: true, Remaining: 6, Reset: 24 * time.Second}},
+				{0, Result{Allowed: true, Remaining: 5, Reset: 30 * time.Second}},
+				{0, Result{Allowed: true, Remaining: 4, Reset: 36 * time.Second}},
+				{0, Result{Allowed: true, Remaining: 3, Reset: 42 * time.Second}},
+				{0, Result{Allowed: true, Remaining: 2, Reset: 48 * time.Second}},
+				{0, Result{Allowed: true, Remaining: 1, Reset: 54 * time.Second}},
+				{0, Result{Allowed: true, Remaining: 0, Reset: time.Minute}},
+				{0, Result{Allowed: false, Remaining: 0, Reset: time.Minute, RetryAfter: 6 * time.Second}},
+				{3 * time.Second, Result{Allowed: false, Remaining: 0, Reset: 57 * time.Second, RetryAfter: 3 * time.Second}},
+				{6 * time.Second, Result{Allowed: true, Remaining: 0, Reset: time.Minute}},
+			},
+		},
+		{
+			name: "Refill stops at the limit",
+			requests: []request{
+				{0, Result{Allowed: true, Remaining: 9, Reset: 6 * time.Second}},
+				{time.Hour, Result{Allowed: true, Remaining: 9, Reset: 6 * time.Second}},
+			},
+		},
+		{
+			name: "Partial tokens don't count",
+			requests: []request{
+				{0, Result{Allowed: true, Remaining: 9, Reset: 6 * time.Second}},
+				{0, Result{Allowed: true, Remaining: 8, Reset: 12 * time.Second}},
+				{3 * time.Second, Result{Allowed: true, Remaining: 7, Reset: 15 * time.Second}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := New(limit)
+			for i, req := range tt.requests {
+				got := l.Allow("client", t0.Add(req.at))
+				if got != req.want {
+					t.Fatalf("request %d: got %+v; want %+v", i+1, got, req.want)
+				}
+			}
+		})
+	}
+}
+
+func TestAllowKeysSeparately(t *testing.T) {
+	l := New(Limit{Requests: 1, Per: time.Minute})
+
+	if !l.Allow("a", t0).Allowed {
+		t.Fatal("a's first request wasn't allowed")
+	}
+	if l.Allow("a", t0).Allowed {
+		t.Fatal("a's second request was allowed")
+	}
+	if !l.Allow("b", t0).Allowed {
+		t.Fatal("b's first request wasn't allowed after a ran out")
+	}
+}
+
+func TestSweep(t *testing.T) {
+	// A token every minute
+	l := New(Limit{Requests: 2, Per: 2 * time.Minute})
+	l.Allow("full", t0)
+	l.Allow("empty", t0)
+	l.Allow("empty", t0)
+
+	// By the next sweep "full" has refilled and can go, but "empty" hasn't
+	l.Allow("other", t0.Add(sweepInterval))
+	if _, ok := l.buckets["full"]; ok {
+		t.Error("refilled bucket wasn't swept")
+	}
+	if _, ok := l.buckets["empty"]; !ok {
+		t.Error("bucket that hadn't refilled was swept")
+	}
+
+	// Sweeping doesn't change what the empty bucket allows
+	if got := l.Allow("empty", t0.Add(sweepInterval)); !got.Allowed || got.Remaining != 0 {
+		t.Errorf("got %+v after sweeping; want one allowed request", got)
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	tests := []struct {
+		limit Limit
+		want  string
+	}{
+		{Limit{Requests: 10, Per: time.Minute}, "10;w=60"},
+		{Limit{Requests: 600, Per: time.Hour}, "600;w=3600"},
+		{Limit{Requests: 5, Per: 1500 * time.Millisecond}, "5;w=2"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.limit.Policy(); got != tt.want {
+			t.Errorf("%+v.Policy() = %q; want %q", tt.limit, got, tt.want)
+		}
+	}
+}
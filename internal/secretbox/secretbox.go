@@ -0,0 +1,55 @@
+// Package secretbox encrypts small values, such as two-factor secrets, so
+// they aren't readable by anyone with a copy of the database alone.
+package secretbox
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+)
+
+// ErrDecrypt is returned for values that weren't sealed with the box's key
+// or have been tampered with
+var ErrDecrypt = errors.New("secretbox: could not decrypt")
+
+// Box seals and opens values with AES-256-GCM
+type Box struct {
+	aead cipher.AEAD
+}
+
+// New returns a Box using a 32-byte key
+func New(key []byte) (*Box, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Box{aead: aead}, nil
+}
+
+// Seal encrypts plaintext, returning a random nonce followed by the
+// ciphertext
+func (b *Box) Seal(plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return b.aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// Open decrypts a value made by Seal
+func (b *Box) Open(sealed []byte) ([]byte, error) {
+	n := b.aead.NonceSize()
+	if len(sealed) < n {
+		return nil, ErrDecrypt
+	}
+	plaintext, err := b.aead.Open(nil, sealed[:n], sealed[n:], nil)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plaintext, nil
+}
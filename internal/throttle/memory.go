@@ -0,0 +1,62 @@
+package throttle
+
+import (
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps failure records in memory. Records are lost on restart
+// and aren't shared between servers.
+type MemoryStore struct {
+	mu      sync.Mutex
+	records map[string]Record
+}
+
+// NewMemoryStore returns an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: make(map[string]Record)}
+}
+
+func (s *MemoryStore) Get(key string) (Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.records[key], nil
+}
+
+func (s *MemoryStore) Add(key string, t, forgetBefore time.Time) (Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec := s.records[key]
+	if rec.Last.Before(forgetBefore) {
+		rec.Failures = 0
+	}
+	rec.Failures++
+	rec.Last = t
+	s.records[key] = rec
+
+	return rec, nil
+}
+
+func (s *MemoryStore) Reset(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.records, key)
+	return nil
+}
+
+func (s *MemoryStore) Prune(t time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+	for key, rec := range s.records {
+		if rec.Last.Before(t) {
+			delete(s.records, key)
+			n++
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,77 @@
+package throttle
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+// MySQLStore keeps failure records in the throttle_failures table, so every
+// server behind a load balancer sees the same counts. Keys are stored
+// hashed, so the table doesn't collect the email addresses people try.
+type MySQLStore struct {
+	DB *sql.DB
+}
+
+// keyHash returns the form a key is stored in
+func keyHash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *MySQLStore) Get(key string) (Record, error) {
+	stmt := `SELECT failures, last_failure FROM throttle_failures WHERE key_hash = ?`
+
+	var rec Record
+	err := s.DB.QueryRow(stmt, keyHash(key)).Scan(&rec.Failures, &rec.Last)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return Record{}, err
+	}
+	return rec, nil
+}
+
+func (s *MySQLStore) Add(key string, t, forgetBefore time.Time) (Record, error) {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return Record{}, err
+	}
+	defer tx.Rollback()
+
+	// Assignments are made left to right, so failures is worked out from
+	// the old last_failure. The row stays locked until the transaction ends,
+	// so concurrent failures are counted one at a time.
+	stmt := `INSERT INTO throttle_failures (key_hash, failures, last_failure) VALUES (?, 1, ?) 
+	         ON DUPLICATE KEY UPDATE failures = IF(last_failure < ?, 1, failures + 1), last_failure = VALUES(last_failure)`
+
+	hash := keyHash(key)
+	_, err = tx.Exec(stmt, hash, t.UTC(), forgetBefore.UTC())
+	if err != nil {
+		return Record{}, err
+	}
+
+	var rec Record
+	stmt = `SELECT failures, last_failure FROM throttle_failures WHERE key_hash = ?`
+	err = tx.QueryRow(stmt, hash).Scan(&rec.Failures, &rec.Last)
+	if err != nil {
+		return Record{}, err
+	}
+
+	return rec, tx.Commit()
+}
+
+func (s *MySQLStore) Reset(key string) error {
+	_, err := s.DB.Exec(`DELETE FROM throttle_failures WHERE key_hash = ?`, keyHash(key))
+	return err
+}
+
+func (s *MySQLStore) Prune(t time.Time) (int, error) {
+	result, err := s.DB.Exec(`DELETE FROM throttle_failures WHERE last_failure < ?`, t.UTC())
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := result.RowsAffected()
+	return int(n), err
+}
@@ -0,0 +1,94 @@
+// Package throttle slows down repeated failures, such as wrong passwords,
+// for a key like an email address or IP address. After a few free failures
+// each one doubles the wait before the next attempt, and after too many the
+// key is locked out for a while.
+package throttle
+
+import "time"
+
+// Record is the failures counted for a key
+type Record struct {
+	Failures int
+	Last     time.Time // When the latest failure happened
+}
+
+// Store keeps failure records. MemoryStore suits a single server; MySQLStore
+// shares records between several.
+type Store interface {
+	// Get returns the record for key, which is empty if there isn't one
+	Get(key string) (Record, error)
+	// Add counts a failure for key at t and returns the updated record.
+	// Failures last counted before forgetBefore are forgotten first.
+	Add(key string, t, forgetBefore time.Time) (Record, error)
+	// Reset forgets the failures for key
+	Reset(key string) error
+	// Prune forgets every record whose last failure was before t
+	Prune(t time.Time) (int, error)
+}
+
+// Policy decides how long a key has to wait after failing
+type Policy struct {
+	FreeFailures int           // Failures allowed before any wait
+	BaseDelay    time.Duration // Wait after the first failure past the free ones, doubled for each after
+	MaxDelay     time.Duration
+	LockoutAfter int           // Failures that lock the key out
+	Lockout      time.Duration // How long a lockout lasts; each further failure starts another
+	Window       time.Duration // How long after the last failure they're all forgotten
+}
+
+// delay returns how long after its last failure a key with rec has to wait
+func (p Policy) delay(rec Record) time.Duration {
+	switch {
+	case rec.Failures >= p.LockoutAfter:
+		return p.Lockout
+	case rec.Failures <= p.FreeFailures:
+		return 0
+	}
+
+	d := p.BaseDelay
+	for i := p.FreeFailures + 1; i < rec.Failures && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	return min(d, p.MaxDelay)
+}
+
+// Throttle applies a policy to the records in a store
+type Throttle struct {
+	Store  Store
+	Policy Policy
+}
+
+// Wait returns how long key has to wait at now before it can try again, or
+// zero if it can try straight away
+func (t *Throttle) Wait(key string, now time.Time) (time.Duration, error) {
+	rec, err := t.Store.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	if rec.Failures == 0 || rec.Last.Before(now.Add(-t.Policy.Window)) {
+		return 0, nil
+	}
+
+	wait := rec.Last.Add(t.Policy.delay(rec)).Sub(now)
+	return max(wait, 0), nil
+}
+
+// Fail counts a failure for key at now. It reports whether this failure
+// locked the key out when it wasn't already.
+func (t *Throttle) Fail(key string, now time.Time) (bool, error) {
+	rec, err := t.Store.Add(key, now, now.Add(-t.Policy.Window))
+	if err != nil {
+		return false, err
+	}
+	return rec.Failures == t.Policy.LockoutAfter, nil
+}
+
+// Reset forgets the failures for key, e.g. once it has succeeded
+func (t *Throttle) Reset(key string) error {
+	return t.Store.Reset(key)
+}
+
+// Prune forgets failures that are too old to matter any more
+func (t *Throttle) Prune(now time.Time) (int, error) {
+	return t.Store.Prune(now.Add(-t.Policy.Window))
+}
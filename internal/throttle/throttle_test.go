@@ -0,0 +1,265 @@
+package throttle
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+)
+
+var testPolicy = Policy{
+	FreeFailures: 2,
+	BaseDelay:    time.Second,
+	MaxDelay:     8 * time.Second,
+	LockoutAfter: 8,
+	Lockout:      time.Minute,
+	Window:       time.Hour,
+}
+
+// t0 is whole seconds, since MySQL's DATETIME doesn't keep fractions
+var t0 = time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// forEachStore runs test against a fresh MemoryStore and, if a test
+// database is set up, a fresh MySQLStore
+func forEachStore(t *testing.T, test func(t *testing.T, store Store)) {
+	t.Run("memory", func(t *testing.T) {
+		test(t, NewMemoryStore())
+	})
+
+	t.Run("mysql", func(t *testing.T) {
+		db := newTestDB(t)
+		test(t, &MySQLStore{DB: db})
+	})
+}
+
+// newTestDB connects to the test database named by SNIPPETBOX_TEST_DSN and
+// creates the schema in it, dropping it again when the test ends. The
+// tests using it are skipped without one.
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	dsn := os.Getenv("SNIPPETBOX_TEST_DSN")
+	if dsn == "" {
+		t.Skip("SNIPPETBOX_TEST_DSN not set")
+	}
+
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runScript := func(name string) {
+		script, err := os.ReadFile(filepath.Join("..", "models", "testdata", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = db.Exec(string(script))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	runScript("teardown.sql")
+	runScript("setup.sql")
+
+	t.Cleanup(func() {
+		defer db.Close()
+		runScript("teardown.sql")
+	})
+
+	return db
+}
+
+func TestSchedule(t *testing.T) {
+	// How long a key waits after each of its failures in a row
+	tests := []struct {
+		failure   int
+		wait      time.Duration
+		lockedOut bool // Whether this failure starts the lockout
+	}{
+		{1, 0, false},
+		{2, 0, false},
+		{3, time.Second, false},
+		{4, 2 * time.Second, false},
+		{5, 4 * time.Second, false},
+		{6, 8 * time.Second, false},
+		{7, 8 * time.Second, false},
+		{8, time.Minute, true},
+		{9, time.Minute, false},
+		{10, time.Minute, false},
+	}
+
+	forEachStore(t, func(t *testing.T, store Store) {
+		th := &Throttle{Store: store, Policy: testPolicy}
+
+		for _, tt := range tests {
+			now := t0.Add(time.Duration(tt.failure) * time.Minute)
+
+			lockedOut, err := th.Fail("email:alice@example.com", now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if lockedOut != tt.lockedOut {
+				t.Errorf("failure %d: lockedOut = %t; want %t", tt.failure, lockedOut, tt.lockedOut)
+			}
+
+			wait, err := th.Wait("email:alice@example.com", now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if wait != tt.wait {
+				t.Errorf("failure %d: wait = %s; want %s", tt.failure, wait, tt.wait)
+			}
+
+			// The wait runs down as time passes
+			if tt.wait > 0 {
+				wait, err = th.Wait("email:alice@example.com", now.Add(tt.wait-time.Millisecond))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if wait != time.Millisecond {
+					t.Errorf("failure %d: wait just before it ends = %s; want 1ms", tt.failure, wait)
+				}
+			}
+			wait, err = th.Wait("email:alice@example.com", now.Add(tt.wait))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if wait != 0 {
+				t.Errorf("failure %d: wait once it's over = %s; want 0", tt.failure, wait)
+			}
+		}
+	})
+}
+
+func TestWindow(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		th := &Throttle{Store: store, Policy: testPolicy}
+
+		for i := 0; i < 5; i++ {
+			_, err := th.Fail("ip:192.0.2.1", t0)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		tests := []struct {
+			name string
+			now  time.Time
+			want time.Duration
+		}{
+			{"inside the window", t0.Add(time.Second), 3 * time.Second},
+			{"at the end of the window", t0.Add(testPolicy.Window), 0},
+			{"after the window", t0.Add(testPolicy.Window + time.Second), 0},
+		}
+		for _, tt := range tests {
+			wait, err := th.Wait("ip:192.0.2.1", tt.now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if wait != tt.want {
+				t.Errorf("%s: wait = %s; want %s", tt.name, wait, tt.want)
+			}
+		}
+
+		// Failures after the window start counting from one again
+		later := t0.Add(testPolicy.Window + time.Minute)
+		_, err := th.Fail("ip:192.0.2.1", later)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec, err := store.Get("ip:192.0.2.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rec.Failures != 1 || !rec.Last.Equal(later) {
+			t.Errorf("got %+v; want 1 failure at %s", rec, later)
+		}
+	})
+}
+
+func TestResetAndKeys(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		th := &Throttle{Store: store, Policy: testPolicy}
+
+		for _, key := range []string{"email:alice@example.com", "email:alice@example.com", "email:alice@example.com", "ip:192.0.2.1"} {
+			_, err := th.Fail(key, t0)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		tests := []struct {
+			key      string
+			failures int
+		}{
+			{"email:alice@example.com", 3},
+			{"ip:192.0.2.1", 1},
+			{"email:bob@example.com", 0},
+		}
+		for _, tt := range tests {
+			rec, err := store.Get(tt.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rec.Failures != tt.failures {
+				t.Errorf("%s has %d failures; want %d", tt.key, rec.Failures, tt.failures)
+			}
+		}
+
+		err := th.Reset("email:alice@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		wait, err := th.Wait("email:alice@example.com", t0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wait != 0 {
+			t.Errorf("wait after a reset = %s; want 0", wait)
+		}
+		rec, err := store.Get("ip:192.0.2.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rec.Failures != 1 {
+			t.Errorf("resetting one key changed another: %+v", rec)
+		}
+	})
+}
+
+func TestPrune(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		th := &Throttle{Store: store, Policy: testPolicy}
+
+		_, err := th.Fail("old", t0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = th.Fail("recent", t0.Add(30*time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		n, err := th.Prune(t0.Add(testPolicy.Window + time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 1 {
+			t.Errorf("pruned %d records; want 1", n)
+		}
+
+		for key, want := range map[string]int{"old": 0, "recent": 1} {
+			rec, err := store.Get(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rec.Failures != want {
+				t.Errorf("%s has %d failures after pruning; want %d", key, rec.Failures, want)
+			}
+		}
+	})
+}
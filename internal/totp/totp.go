@@ -0,0 +1,95 @@
+// Package totp implements time-based one-time passwords (RFC 6238) with the
+// parameters authenticator apps expect by default: HMAC-SHA1, six digits and
+// a 30 second step.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+const (
+	// Step is how long each code is valid for
+	Step = 30 * time.Second
+	// Digits is the length of a code
+	Digits = 6
+	// skew is how many steps either side of the current one are accepted,
+	// to allow for clock drift and slow typing
+	skew = 1
+)
+
+// encoding is the base32 form secrets are shown in, without padding as
+// authenticator apps expect
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewSecret generates a random 160-bit secret, the size RFC 4226 recommends
+func NewSecret() ([]byte, error) {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// EncodeSecret returns the secret in the base32 form users type into their
+// authenticator app
+func EncodeSecret(secret []byte) string {
+	return encoding.EncodeToString(secret)
+}
+
+// URI returns the otpauth:// URI authenticator apps read from QR codes
+func URI(issuer, account string, secret []byte) string {
+	v := url.Values{}
+	v.Set("secret", EncodeSecret(secret))
+	v.Set("issuer", issuer)
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
+
+// Code returns the code for the step containing t
+func Code(secret []byte, t time.Time) string {
+	return code(secret, stepAt(t))
+}
+
+// Validate checks input against the codes of the steps around t. It returns
+// the step the code belongs to, so callers can refuse to accept a step twice.
+func Validate(secret []byte, input string, t time.Time) (int64, bool) {
+	if len(input) != Digits {
+		return 0, false
+	}
+	now := stepAt(t)
+	for step := now - skew; step <= now+skew; step++ {
+		if subtle.ConstantTimeCompare([]byte(code(secret, step)), []byte(input)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// stepAt returns the number of the step containing t
+func stepAt(t time.Time) int64 {
+	return t.Unix() / int64(Step/time.Second)
+}
+
+// code computes the HOTP value (RFC 4226) for a counter
+func code(secret []byte, counter int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation
+	offset := sum[len(sum)-1] & 0x0F
+	value := binary.BigEndian.Uint32(sum[offset:]) & 0x7FFFFFFF
+
+	return fmt.Sprintf("%0*d", Digits, value%1_000_000)
+}
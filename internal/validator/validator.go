@@ -9,6 +9,14 @@ import (
 
 var EmailRX = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
 
+// TagRX matches a tag: lowercase letters, digits and a few symbols used in
+// names like "c++", "c#" and "node.js", at most 30 characters long
+var TagRX = regexp.MustCompile(`^[a-z0-9][a-z0-9+#._-]{0,29}$`)
+
+// HandleRX matches a user handle: 3 to 30 letters, digits, underscores or
+// hyphens, so it can be used in a URL as is
+var HandleRX = regexp.MustCompile(`^[a-zA-Z0-9_-]{3,30}$`)
+
 type Validator struct {
 	FieldErrors    map[string]string
 	NonFieldErrors []string
@@ -50,6 +58,11 @@ func MaxChars(value string, n int) bool {
 	return utf8.RuneCountInString(value) <= n
 }
 
+// Returns true if a value is no more than n bytes long.
+func MaxBytes(value string, n int) bool {
+	return len(value) <= n
+}
+
 // Returns true if a value is in a list of specific permitted values
 func PermittedValue[T comparable](value T, permittedValues ...T) bool {
 	return slices.Contains(permittedValues, value)
@@ -61,3 +74,18 @@ func MinChars(value string, n int) bool {
 func Matches(value string, rx *regexp.Regexp) bool {
 	return rx.MatchString(value)
 }
+
+// Returns true if there are no more than n values
+func MaxCount[T any](values []T, n int) bool {
+	return len(values) <= n
+}
+
+// Returns true if every value matches the regular expression
+func AllMatch(values []string, rx *regexp.Regexp) bool {
+	for _, value := range values {
+		if !rx.MatchString(value) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,31 @@
+-- Gives every existing snippet a random slug, for databases created before
+-- snippets were addressed by slug. Old /snippet/view/{id} links keep working
+-- by redirecting to the new address.
+
+ALTER TABLE snippets ADD slug CHAR(11) NULL AFTER id;
+
+-- Slugs are 11 base62 characters, like the ones the application makes. The
+-- first one is always a letter, so a slug is never mistaken for an old ID.
+SET @letters = 'ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz';
+SET @alphabet = CONCAT('0123456789', @letters);
+
+UPDATE snippets SET slug = CONCAT(
+    SUBSTRING(@letters, 1 + FLOOR(RAND() * 52), 1),
+    SUBSTRING(@alphabet, 1 + FLOOR(RAND() * 62), 1),
+    SUBSTRING(@alphabet, 1 + FLOOR(RAND() * 62), 1),
+    SUBSTRING(@alphabet, 1 + FLOOR(RAND() * 62), 1),
+    SUBSTRING(@alphabet, 1 + FLOOR(RAND() * 62), 1),
+    SUBSTRING(@alphabet, 1 + FLOOR(RAND() * 62), 1),
+    SUBSTRING(@alphabet, 1 + FLOOR(RAND() * 62), 1),
+    SUBSTRING(@alphabet, 1 + FLOOR(RAND() * 62), 1),
+    SUBSTRING(@alphabet, 1 + FLOOR(RAND() * 62), 1),
+    SUBSTRING(@alphabet, 1 + FLOOR(RAND() * 62), 1),
+    SUBSTRING(@alphabet, 1 + FLOOR(RAND() * 62), 1)
+) WHERE slug IS NULL;
+
+-- Two slugs colliding is vanishingly unlikely, but if it happens this fails
+-- with a duplicate entry error and nothing else needs undoing: set one of
+-- the two back to NULL, then run the UPDATE above and this statement again.
+ALTER TABLE snippets
+    MODIFY slug CHAR(11) NOT NULL,
+    ADD CONSTRAINT snippets_uc_slug UNIQUE (slug);
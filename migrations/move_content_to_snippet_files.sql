@@ -0,0 +1,32 @@
+-- Moves the content of every snippet, and of its earlier revisions, into
+-- snippet_files, for databases created before snippets could hold several
+-- files. Each becomes a snippet with a single file named file1, the name
+-- the application gives a file left unnamed.
+
+CREATE TABLE snippet_files (
+    id INT NOT NULL AUTO_INCREMENT,
+    snippet_id INT NOT NULL,
+    revision INT NOT NULL,
+    position INT NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    language VARCHAR(20) NOT NULL DEFAULT 'plaintext',
+    content MEDIUMTEXT NOT NULL,
+    PRIMARY KEY (id),
+    CONSTRAINT snippet_files_uc_position UNIQUE (snippet_id, revision, position),
+    FULLTEXT INDEX snippet_files_ft_content (name, content),
+    FOREIGN KEY (snippet_id) REFERENCES snippets(id) ON DELETE CASCADE
+);
+
+INSERT INTO snippet_files (snippet_id, revision, position, name, language, content)
+SELECT id, revision, 0, 'file1', language, content FROM snippets;
+
+-- The language of earlier revisions wasn't recorded, so they keep the
+-- snippet's current one
+INSERT INTO snippet_files (snippet_id, revision, position, name, language, content)
+SELECT r.snippet_id, r.revision, 0, 'file1', s.language, r.content
+FROM snippet_revisions r
+INNER JOIN snippets s ON s.id = r.snippet_id;
+
+-- Only drop the old columns once everything has been copied
+ALTER TABLE snippets DROP COLUMN content, DROP COLUMN language;
+ALTER TABLE snippet_revisions DROP COLUMN content;
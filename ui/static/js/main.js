@@ -5,4 +5,99 @@ for (var i = 0; i < navLinks.length; i++) {
 		link.classList.add("live");
 		break;
 	}
-}
\ No newline at end of file
+}
+
+// Highlight the lines named in a #L12 or #L12-L20 fragment on snippet pages.
+// Lines in the second and later files are prefixed, e.g. #F2-L12-L20.
+function highlightLines() {
+	var selected = document.querySelectorAll("pre.code .line.selected");
+	for (var i = 0; i < selected.length; i++) {
+		selected[i].classList.remove("selected");
+	}
+
+	var match = /^#((?:F\d+-)?)L(\d+)(?:-L(\d+))?$/.exec(window.location.hash);
+	if (!match) {
+		return;
+	}
+	var prefix = match[1];
+	var start = parseInt(match[2], 10);
+	var end = match[3] ? parseInt(match[3], 10) : start;
+	if (end < start) {
+		var tmp = start;
+		start = end;
+		end = tmp;
+	}
+
+	for (var n = start; n <= end; n++) {
+		var line = document.getElementById(prefix + "L" + n);
+		if (line) {
+			line.classList.add("selected");
+		}
+	}
+
+	var first = document.getElementById(prefix + "L" + start);
+	if (first) {
+		first.scrollIntoView();
+	}
+}
+
+window.addEventListener("hashchange", highlightLines);
+highlightLines();
+
+// Shift-click a second line number in the same file to select the range
+// between them
+var lastLine = null;
+var lastPrefix = null;
+document.addEventListener("click", function (e) {
+	var target = e.target;
+	if (!target.classList || !target.classList.contains("ln")) {
+		return;
+	}
+	var line = parseInt(target.getAttribute("data-line"), 10);
+	var prefix = target.parentNode.id.replace(/L\d+$/, "");
+	if (e.shiftKey && lastLine !== null && prefix === lastPrefix) {
+		e.preventDefault();
+		window.location.hash = "#" + prefix + "L" + Math.min(lastLine, line) + "-L" + Math.max(lastLine, line);
+		return;
+	}
+	lastLine = line;
+	lastPrefix = prefix;
+});
+
+// Add and remove files on the create and edit forms. Removed files are
+// dropped from the DOM; the server ignores any gaps in the numbering.
+var fileLists = document.querySelectorAll("div.files");
+for (var i = 0; i < fileLists.length; i++) {
+	setUpFileList(fileLists[i]);
+}
+
+function setUpFileList(list) {
+	var next = list.querySelectorAll("fieldset.file").length;
+
+	list.addEventListener("click", function (e) {
+		var target = e.target;
+		if (target.classList.contains("remove-file")) {
+			if (list.querySelectorAll("fieldset.file").length > 1) {
+				list.removeChild(target.parentNode);
+			}
+			return;
+		}
+		if (!target.classList.contains("add-file")) {
+			return;
+		}
+
+		var files = list.querySelectorAll("fieldset.file");
+		var copy = files[files.length - 1].cloneNode(true);
+		var errors = copy.querySelectorAll("label.error");
+		for (var j = 0; j < errors.length; j++) {
+			errors[j].parentNode.removeChild(errors[j]);
+		}
+		var fields = copy.querySelectorAll("input, select, textarea");
+		for (var j = 0; j < fields.length; j++) {
+			fields[j].name = fields[j].name.replace(/^files\[\d+\]/, "files[" + next + "]");
+			fields[j].value = "";
+		}
+		next++;
+		list.insertBefore(copy, target);
+	});
+}